@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/eniac/Beldi/pkg/beldilib"
+)
+
+// daalconfig prints or changes the DAAL config of a table, e.g.
+// daalconfig -logsize 101 gctest
+func main() {
+	create := flag.Bool("create", false, "Create the config table first")
+	logSize := flag.Int("logsize", 0, "Max LOGSIZE of a row, 0 keeps the current one")
+	intent := flag.Int64("intent", -1, "Seconds GC keeps finished intents, -1 keeps the current one")
+	dangle := flag.Int64("dangle", -1, "Seconds GC keeps unlinked rows, -1 keeps the current one")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <table>\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	tablename := flag.Arg(0)
+	if *create {
+		beldilib.CreateConfigTable()
+		beldilib.WaitUntilActive(beldilib.ConfigTable)
+	}
+	config, _ := beldilib.LookupDAALConfig(tablename)
+	if *logSize != 0 || *intent != -1 || *dangle != -1 {
+		if *logSize != 0 {
+			config.LogSize = *logSize
+		}
+		if *intent != -1 {
+			config.IntentTimeout = *intent
+		}
+		if *dangle != -1 {
+			config.DanglingTimeout = *dangle
+		}
+		if err := beldilib.SetDAALConfig(tablename, config); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	body, err := json.Marshal(config)
+	beldilib.CHECK(err)
+	os.Stdout.Write(body)
+	fmt.Println()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/eniac/Beldi/pkg/beldilib"
+)
+
+// inspect prints the call tree of a workflow instance from its DynamoDB
+// tables, e.g. inspect -tables hotel,flight frontend <instance id>
+func main() {
+	tables := flag.String("tables", "", "Comma separated DAAL tables to search for writes")
+	raw := flag.Bool("json", false, "Print the history as JSON")
+	flag.Parse()
+	if flag.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <lambda> <instance id>\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	var names []string
+	if *tables != "" {
+		names = strings.Split(*tables, ",")
+	}
+	history := beldilib.InspectInstance(flag.Arg(0), flag.Arg(1), names)
+	if *raw {
+		body, err := json.Marshal(history)
+		beldilib.CHECK(err)
+		os.Stdout.Write(body)
+		fmt.Println()
+		return
+	}
+	history.Render(os.Stdout)
+}
@@ -7,6 +7,7 @@ require (
 	github.com/aws/aws-lambda-go v1.19.1
 	github.com/aws/aws-sdk-go v1.34.6
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	cs.utexas.edu/zjia/faas-memdb v0.0.0
 	github.com/google/uuid v1.1.1 // indirect
 	github.com/hailocab/go-geoindex v0.0.0-20160127134810-64631bfe9711
 	github.com/lithammer/shortuuid v3.0.0+incompatible
@@ -14,3 +15,6 @@ require (
 )
 
 replace cs.utexas.edu/zjia/faas => /src/boki/worker/golang
+
+// memdb is a module of its own, shared with the boki module
+replace cs.utexas.edu/zjia/faas-memdb => ../memdb
@@ -64,7 +64,9 @@ func SendRequest(env *beldilib.Env, userId string, flightId string, hotelId stri
 		"hotelId":  hotelId,
 		"userId":   userId,
 	}
-	beldilib.CommitTxn(env)
+	if !beldilib.CommitTxn(env) {
+		return "Place Order Fails"
+	}
 	beldilib.AsyncInvoke(env, data.Torder(), data.RPCInput{
 		Function: "PlaceOrder",
 		Input:    input,
@@ -1,35 +1,37 @@
 package main
 
 import (
-	"sync"
-	"time"
+	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 	"github.com/eniac/Beldi/pkg/beldilib"
 )
 
 func main() {
-	services := []string{"flight", "hotel", "order"}
-	statics := []string{"user", "search", "recommendation", "rate", "profile", "geo", "gateway", "frontend"}
+	interval := flag.Duration("interval", 100*time.Millisecond, "pause between GC rounds of a service")
+	concurrency := flag.Int("concurrency", 64, "goroutines per service")
+	rate := flag.Int("rate", 0, "DB operations per second, 0 is unlimited")
+	report := flag.Duration("report", time.Minute, "period of the summary log")
+	status := flag.String("status", "", "address of the status endpoint, e.g. :8081")
+	flag.Parse()
+
+	d := beldilib.NewGCDaemon(beldilib.GCConfig{
+		Services:       []string{"flight", "hotel", "order"},
+		Statics:        []string{"user", "search", "recommendation", "rate", "profile", "geo", "gateway", "frontend"},
+		Interval:       *interval,
+		Concurrency:    *concurrency,
+		OpsPerSec:      *rate,
+		ReportInterval: *report,
+		StatusAddr:     *status,
+	})
+	d.Start()
 
-	for {
-		var wg sync.WaitGroup
-		for _, service := range services {
-			wg.Add(1)
-			go func(service string) {
-				defer wg.Done()
-				log.Printf("[INFO] Start GC: %s", service)
-				beldilib.GC(service)
-			}(service)
-		}
-		for _, service := range statics {
-			wg.Add(1)
-			go func(service string) {
-				defer wg.Done()
-				log.Printf("[INFO] Start static GC: %s", service)
-				beldilib.StaticGC(service)
-			}(service)
-		}
-		wg.Wait()
-		time.Sleep(100 * time.Millisecond)
-	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+	log.Printf("[INFO] Stopping GC")
+	d.Stop()
 }
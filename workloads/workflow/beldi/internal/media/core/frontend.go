@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/eniac/Beldi/pkg/beldilib"
-	"sync"
 )
 
 type ComposeInput struct {
@@ -24,36 +23,34 @@ func Compose(env *beldilib.Env, input ComposeInput) {
 	if res.(float64) != 0 {
 		fmt.Println(fmt.Sprintf("DEBUG: result is %s", res))
 	}
-	var wg sync.WaitGroup
-	wg.Add(4)
-	go func() {
-		defer wg.Done()
-		beldilib.AssignedSyncInvoke(env, TUniqueId(), RPCInput{
-			Function: "UploadUniqueId2",
-			Input:    aws.JSONValue{"reqId": reqId},
-		}, env.StepNumber)
-	}()
-	go func() {
-		defer wg.Done()
-		beldilib.AssignedSyncInvoke(env, TUser(), RPCInput{
-			Function: "UploadUser",
-			Input:    aws.JSONValue{"reqId": reqId, "username": input.Username},
-		}, env.StepNumber+1)
-	}()
-	go func() {
-		defer wg.Done()
-		beldilib.AssignedSyncInvoke(env, TMovieId(), RPCInput{
-			Function: "UploadMovie",
-			Input:    aws.JSONValue{"reqId": reqId, "title": input.Title, "rating": input.Rating},
-		}, env.StepNumber+2)
-	}()
-	go func() {
-		defer wg.Done()
-		beldilib.AssignedSyncInvoke(env, TText(), RPCInput{
-			Function: "UploadText2",
-			Input:    aws.JSONValue{"reqId": reqId, "text": input.Text},
-		}, env.StepNumber+3)
-	}()
-	wg.Wait()
-	env.StepNumber += 4
+	beldilib.Parallel(env,
+		&beldilib.ParallelCall{
+			Callee: TUniqueId(),
+			Input: RPCInput{
+				Function: "UploadUniqueId2",
+				Input:    aws.JSONValue{"reqId": reqId},
+			},
+		},
+		&beldilib.ParallelCall{
+			Callee: TUser(),
+			Input: RPCInput{
+				Function: "UploadUser",
+				Input:    aws.JSONValue{"reqId": reqId, "username": input.Username},
+			},
+		},
+		&beldilib.ParallelCall{
+			Callee: TMovieId(),
+			Input: RPCInput{
+				Function: "UploadMovie",
+				Input:    aws.JSONValue{"reqId": reqId, "title": input.Title, "rating": input.Rating},
+			},
+		},
+		&beldilib.ParallelCall{
+			Callee: TText(),
+			Input: RPCInput{
+				Function: "UploadText2",
+				Input:    aws.JSONValue{"reqId": reqId, "text": input.Text},
+			},
+		},
+	)
 }
@@ -7,15 +7,13 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/eniac/Beldi/pkg/beldilib"
-	"github.com/lithammer/shortuuid"
 	"github.com/mitchellh/mapstructure"
-	"time"
 )
 
 func RegisterUserWithUserId(env *beldilib.Env, firstName string, lastName string, username string, password string,
 	userId string) {
 	hasher := sha512.New()
-	salt := shortuuid.New()
+	salt := beldilib.UUID(env)
 	hasher.Write([]byte(password + salt))
 	passwordHash := hex.EncodeToString(hasher.Sum(nil))
 	user := User{
@@ -32,7 +30,7 @@ func RegisterUserWithUserId(env *beldilib.Env, firstName string, lastName string
 }
 
 func RegisterUser(env *beldilib.Env, firstName string, lastName string, username string, password string) {
-	RegisterUserWithUserId(env, firstName, lastName, username, password, shortuuid.New())
+	RegisterUserWithUserId(env, firstName, lastName, username, password, beldilib.UUID(env))
 }
 
 func Login(env *beldilib.Env, username string, password string) string {
@@ -45,7 +43,7 @@ func Login(env *beldilib.Env, username string, password string) string {
 	if passwordHash == user.Password {
 		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 			"user_id":   user.UserId,
-			"timestamp": time.Now().Format("20060102150405"),
+			"timestamp": beldilib.Now(env).Format("20060102150405"),
 			"TTL":       "60000",
 		})
 		tokenString, err := token.SignedString("secret")
@@ -0,0 +1,145 @@
+package beldilib
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// BackfillGC prepares the tables of lambdaId, created before gcidx, for
+// GC. It adds gcidx where it is missing and queues what GC used to find
+// by scans: finished intents with GCQ=DONE#<shard> and unlinked rows with
+// GCQ=DANGLE. Intents finished before WRITES existed do not know the keys
+// they wrote, so their write logs are removed by one scan of the DAAL
+// table here. It is meant to run once per lambda, next to a running
+// workload if need be, and returns the number of intents queued.
+func BackfillGC(lambdaId string) int {
+	intentTable := fmt.Sprintf("%s-collector", lambdaId)
+	hasDAAL := addGCIndex(lambdaId)
+	if !addGCIndex(intentTable) {
+		return 0
+	}
+	run := newGCRun(&GCStats{}, 0, nil, nil)
+
+	filter := expression.Name("DONE").Equal(expression.Value(true)).
+		And(expression.AttributeNotExists(expression.Name("GCQ")))
+	items := LibScan(intentTable, []string{"InstanceId", writesTracked}, filter)
+	idx := make(map[string]bool)
+	for _, item := range items {
+		if tracked, _ := item[writesTracked].(bool); !tracked {
+			idx[item["InstanceId"].(string)+"-"] = true
+		}
+	}
+	if hasDAAL && len(idx) > 0 {
+		backfillWrites(run, lambdaId, idx)
+	}
+	for _, item := range items {
+		instanceId := item["InstanceId"].(string)
+		run.spawn(func() {
+			LibWrite(intentTable, aws.JSONValue{"InstanceId": instanceId},
+				map[expression.NameBuilder]expression.OperandBuilder{
+					expression.Name("GCQ"): expression.Value(doneQueue(instanceId)),
+				})
+		})
+	}
+	run.wait()
+	return len(items)
+}
+
+// backfillWrites removes the write logs of the instances in idx from every
+// row of tablename, then queues the rows ClearRow unlinked before GCQ
+// existed and the ones unlinked now
+func backfillWrites(run *gcRun, tablename string, idx map[string]bool) {
+	ts := time.Now().Unix()
+	var start map[string]*dynamodb.AttributeValue
+	for {
+		var rows []aws.JSONValue
+		rows, start = LibScanPage(tablename, []string{"K", "ROWHASH", "LOGS", "NEXTROW", "TS", "GCQ"},
+			expression.AttributeExists(expression.Name("K")), start, ScanPageSize)
+		for _, row := range rows {
+			row_ := row
+			run.spawn(func() {
+				key := row_["K"].(string)
+				rowHash := row_["ROWHASH"].(string)
+				run.ClearRowDAAL(row_, idx, tablename)
+				if _, unlinked := row_["TS"]; unlinked {
+					if _, queued := row_["GCQ"]; !queued {
+						LibWrite(tablename, aws.JSONValue{"K": key, "ROWHASH": rowHash},
+							map[expression.NameBuilder]expression.OperandBuilder{
+								expression.Name("GCQ"): expression.Value("DANGLE"),
+							})
+					}
+				}
+				if nextRow, exists := row_["NEXTROW"].(string); exists && rowHash == "HEAD" {
+					run.ClearRow(tablename, key, "HEAD", nextRow, ts)
+				}
+			})
+		}
+		run.wait()
+		if start == nil {
+			return
+		}
+	}
+}
+
+// addGCIndex creates gcidx on tablename unless it has one, and waits for
+// the index to be built. It returns false if there is no such table.
+func addGCIndex(tablename string) bool {
+	for {
+		res, err := DBClient.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(kTablePrefix + tablename)})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+			return false
+		}
+		CHECK(err)
+		var index *dynamodb.GlobalSecondaryIndexDescription
+		for _, desc := range res.Table.GlobalSecondaryIndexes {
+			if aws.StringValue(desc.IndexName) == gcIndex {
+				index = desc
+			}
+		}
+		if index == nil {
+			_, err = DBClient.UpdateTable(&dynamodb.UpdateTableInput{
+				TableName: aws.String(kTablePrefix + tablename),
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("GCQ"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("TS"),
+						AttributeType: aws.String("N"),
+					},
+				},
+				GlobalSecondaryIndexUpdates: []*dynamodb.GlobalSecondaryIndexUpdate{
+					{
+						Create: &dynamodb.CreateGlobalSecondaryIndexAction{
+							IndexName: aws.String(gcIndex),
+							KeySchema: []*dynamodb.KeySchemaElement{
+								{
+									AttributeName: aws.String("GCQ"),
+									KeyType:       aws.String("HASH"),
+								},
+								{
+									AttributeName: aws.String("TS"),
+									KeyType:       aws.String("RANGE"),
+								},
+							},
+							Projection: &dynamodb.Projection{
+								ProjectionType: aws.String("KEYS_ONLY"),
+							},
+						},
+					},
+				},
+			})
+			CHECK(err)
+		} else if aws.StringValue(index.IndexStatus) == "ACTIVE" {
+			return true
+		}
+		fmt.Printf("%s: building %s\n", tablename, gcIndex)
+		time.Sleep(3 * time.Second)
+	}
+}
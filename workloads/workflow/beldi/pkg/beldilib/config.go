@@ -0,0 +1,186 @@
+package beldilib
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// DAALConfig is the DAAL policy of a table. LogSize bounds the LOGS of a
+// row. GC reads the timeouts from the table of a lambda: IntentTimeout is
+// how many seconds it leaves finished intents alone, DanglingTimeout how
+// long it keeps unlinked rows.
+type DAALConfig struct {
+	LogSize         int   `json:"logSize"`
+	IntentTimeout   int64 `json:"intentTimeout"`
+	DanglingTimeout int64 `json:"danglingTimeout"`
+}
+
+func (c DAALConfig) Validate() error {
+	if c.LogSize < 1 {
+		return fmt.Errorf("LogSize must be positive, have %d", c.LogSize)
+	}
+	// LOGS, LOGSIZE and friends share the 400KB limit of an item
+	if c.LogSize > 10000 {
+		return fmt.Errorf("LogSize must be at most 10000, have %d", c.LogSize)
+	}
+	if c.IntentTimeout < 0 || c.DanglingTimeout < 0 {
+		return fmt.Errorf("Timeouts must not be negative, have %d and %d", c.IntentTimeout, c.DanglingTimeout)
+	}
+	return nil
+}
+
+// DefaultDAALConfig applies to tables without a config, it comes from
+// DLOGSIZE and T
+func DefaultDAALConfig() DAALConfig {
+	return DAALConfig{
+		LogSize:         GLOGSIZE(),
+		IntentTimeout:   T,
+		DanglingTimeout: T,
+	}
+}
+
+// Configs are persisted in this table, keyed by table name
+const ConfigTable = "beldi-config"
+
+// Functions reread the config of a table at most every DAALConfigTTL
+var DAALConfigTTL = 10 * time.Second
+
+type cachedDAALConfig struct {
+	config  DAALConfig
+	fetched time.Time
+}
+
+var daalConfigs = map[string]cachedDAALConfig{}
+var daalConfigsMutex = sync.Mutex{}
+
+// Without a config table every table uses the default config. A missing
+// table is remembered for ConfigTableRecheck, so functions neither query
+// nor warn about it on every DAALConfigTTL.
+var ConfigTableRecheck = 5 * time.Minute
+
+var configTableMissing time.Time
+
+func CreateConfigTable() {
+	_, _ = DBClient.CreateTable(&dynamodb.CreateTableInput{
+		BillingMode: aws.String("PAY_PER_REQUEST"),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("K"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("K"),
+				KeyType:       aws.String("HASH"),
+			},
+		},
+		TableName: aws.String(kTablePrefix + ConfigTable),
+	})
+	daalConfigsMutex.Lock()
+	configTableMissing = time.Time{}
+	daalConfigsMutex.Unlock()
+}
+
+func readDAALConfig(tablename string) (DAALConfig, bool, error) {
+	config := DefaultDAALConfig()
+	Key, err := dynamodbattribute.MarshalMap(aws.JSONValue{"K": tablename})
+	CHECK(err)
+	res, err := DBClient.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(kTablePrefix + ConfigTable),
+		Key:            Key,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return config, false, err
+	}
+	if len(res.Item) == 0 {
+		return config, false, nil
+	}
+	var item aws.JSONValue
+	err = dynamodbattribute.UnmarshalMap(res.Item, &item)
+	if err != nil {
+		return config, false, err
+	}
+	if v, ok := item["logSize"].(float64); ok {
+		config.LogSize = int(v)
+	}
+	if v, ok := item["intentTimeout"].(float64); ok {
+		config.IntentTimeout = int64(v)
+	}
+	if v, ok := item["danglingTimeout"].(float64); ok {
+		config.DanglingTimeout = int64(v)
+	}
+	return config, true, nil
+}
+
+// GetDAALConfig returns the config of tablename, or the default one if it
+// has none or the config table is missing
+func GetDAALConfig(tablename string) DAALConfig {
+	daalConfigsMutex.Lock()
+	cached, exists := daalConfigs[tablename]
+	missing := configTableMissing
+	daalConfigsMutex.Unlock()
+	if exists && time.Since(cached.fetched) < DAALConfigTTL {
+		return cached.config
+	}
+	if !missing.IsZero() && time.Since(missing) < ConfigTableRecheck {
+		return DefaultDAALConfig()
+	}
+	config, _, err := readDAALConfig(tablename)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+		daalConfigsMutex.Lock()
+		if configTableMissing.IsZero() {
+			log.Printf("[WARN] No %s table, using default DAAL configs", ConfigTable)
+		}
+		configTableMissing = time.Now()
+		daalConfigsMutex.Unlock()
+		return DefaultDAALConfig()
+	}
+	if err == nil {
+		err = config.Validate()
+	}
+	if err != nil {
+		log.Printf("[WARN] Using default DAAL config for %s: %v", tablename, err)
+		config = DefaultDAALConfig()
+	}
+	daalConfigsMutex.Lock()
+	configTableMissing = time.Time{}
+	daalConfigs[tablename] = cachedDAALConfig{config: config, fetched: time.Now()}
+	daalConfigsMutex.Unlock()
+	return config
+}
+
+// LookupDAALConfig returns the persisted config of tablename, bypassing
+// the cache, and whether there is one
+func LookupDAALConfig(tablename string) (DAALConfig, bool) {
+	config, exists, err := readDAALConfig(tablename)
+	CHECK(err)
+	return config, exists
+}
+
+// SetDAALConfig persists the config of tablename. Functions pick it up
+// within DAALConfigTTL. Rows already full keep their LOGSIZE, so LogSize
+// can change under a running workload.
+func SetDAALConfig(tablename string, config DAALConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	LibWrite(ConfigTable, aws.JSONValue{"K": tablename}, map[expression.NameBuilder]expression.OperandBuilder{
+		expression.Name("logSize"):         expression.Value(config.LogSize),
+		expression.Name("intentTimeout"):   expression.Value(config.IntentTimeout),
+		expression.Name("danglingTimeout"): expression.Value(config.DanglingTimeout),
+	})
+	daalConfigsMutex.Lock()
+	delete(daalConfigs, tablename)
+	daalConfigsMutex.Unlock()
+	return nil
+}
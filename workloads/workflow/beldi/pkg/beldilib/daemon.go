@@ -0,0 +1,203 @@
+package beldilib
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GCStats counts the work of GC on a lambda since the daemon started. Lag
+// is how many seconds the oldest finished intent waited past IntentTimeout
+// when the last round started, 0 if GC keeps up.
+type GCStats struct {
+	Rounds          int64 `json:"rounds"`
+	RowsScanned     int64 `json:"rowsScanned"`
+	LogsRemoved     int64 `json:"logsRemoved"`
+	RowsMarked      int64 `json:"rowsMarked"`
+	RowsDeleted     int64 `json:"rowsDeleted"`
+	IntentsCleared  int64 `json:"intentsCleared"`
+	ReadLogsCleared int64 `json:"readLogsCleared"`
+	IndexQueries    int64 `json:"indexQueries"`
+	Lag             int64 `json:"lag"`
+	LastRound       int64 `json:"lastRound"`
+}
+
+func (s *GCStats) snapshot() GCStats {
+	return GCStats{
+		Rounds:          atomic.LoadInt64(&s.Rounds),
+		RowsScanned:     atomic.LoadInt64(&s.RowsScanned),
+		LogsRemoved:     atomic.LoadInt64(&s.LogsRemoved),
+		RowsMarked:      atomic.LoadInt64(&s.RowsMarked),
+		RowsDeleted:     atomic.LoadInt64(&s.RowsDeleted),
+		IntentsCleared:  atomic.LoadInt64(&s.IntentsCleared),
+		ReadLogsCleared: atomic.LoadInt64(&s.ReadLogsCleared),
+		IndexQueries:    atomic.LoadInt64(&s.IndexQueries),
+		Lag:             atomic.LoadInt64(&s.Lag),
+		LastRound:       atomic.LoadInt64(&s.LastRound),
+	}
+}
+
+// rateLimiter spaces operations interval apart, a nil one does not limit
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(opsPerSec int) *rateLimiter {
+	if opsPerSec <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Second / time.Duration(opsPerSec)}
+}
+
+func (l *rateLimiter) wait() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+type GCConfig struct {
+	// Lambdas with DAAL tables, and lambdas only keeping read logs
+	Services []string
+	Statics  []string
+	// Pause between the rounds of a lambda
+	Interval time.Duration
+	// Goroutines per lambda, and DB operations per second of all of them,
+	// 0 is unlimited
+	Concurrency int
+	OpsPerSec   int
+	// Period of the summary log, 0 disables it
+	ReportInterval time.Duration
+	// Address of the status endpoint, empty disables it
+	StatusAddr string
+}
+
+// GCDaemon runs GC rounds on every lambda of its config until Stop
+type GCDaemon struct {
+	config  GCConfig
+	limiter *rateLimiter
+	stats   map[string]*GCStats
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func NewGCDaemon(config GCConfig) *GCDaemon {
+	d := &GCDaemon{
+		config:  config,
+		limiter: newRateLimiter(config.OpsPerSec),
+		stats:   make(map[string]*GCStats),
+		stop:    make(chan struct{}),
+	}
+	for _, lambdaId := range append(append([]string{}, config.Services...), config.Statics...) {
+		d.stats[lambdaId] = &GCStats{}
+	}
+	return d
+}
+
+// Stats returns the statistics of every lambda
+func (d *GCDaemon) Stats() map[string]GCStats {
+	res := make(map[string]GCStats)
+	for lambdaId, stats := range d.stats {
+		res[lambdaId] = stats.snapshot()
+	}
+	return res
+}
+
+func (d *GCDaemon) loop(lambdaId string, static bool) {
+	defer d.wg.Done()
+	stats := d.stats[lambdaId]
+	for {
+		r := newGCRun(stats, d.config.Concurrency, d.limiter, d.stop)
+		if !r.round(lambdaId, static) {
+			log.Printf("[INFO] GC of %s stopped between phases", lambdaId)
+			return
+		}
+		atomic.AddInt64(&stats.Rounds, 1)
+		atomic.StoreInt64(&stats.LastRound, time.Now().Unix())
+		select {
+		case <-d.stop:
+			return
+		case <-time.After(d.config.Interval):
+		}
+	}
+}
+
+func (d *GCDaemon) report() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.config.ReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+		stats := d.Stats()
+		lambdaIds := make([]string, 0, len(stats))
+		for lambdaId := range stats {
+			lambdaIds = append(lambdaIds, lambdaId)
+		}
+		sort.Strings(lambdaIds)
+		for _, lambdaId := range lambdaIds {
+			s := stats[lambdaId]
+			log.Printf("[INFO] GC %s: rounds=%d scanned=%d logs=%d marked=%d deleted=%d intents=%d readlogs=%d queries=%d lag=%ds",
+				lambdaId, s.Rounds, s.RowsScanned, s.LogsRemoved, s.RowsMarked, s.RowsDeleted,
+				s.IntentsCleared, s.ReadLogsCleared, s.IndexQueries, s.Lag)
+		}
+	}
+}
+
+func (d *GCDaemon) serveStatus() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		CHECK(json.NewEncoder(w).Encode(d.Stats()))
+	})
+	server := &http.Server{Addr: d.config.StatusAddr, Handler: mux}
+	go func() {
+		<-d.stop
+		server.Close()
+	}()
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Printf("[WARN] GC status endpoint failed: %v", err)
+	}
+}
+
+// Start runs the daemon in the background
+func (d *GCDaemon) Start() {
+	for _, lambdaId := range d.config.Services {
+		d.wg.Add(1)
+		go d.loop(lambdaId, false)
+	}
+	for _, lambdaId := range d.config.Statics {
+		d.wg.Add(1)
+		go d.loop(lambdaId, true)
+	}
+	if d.config.ReportInterval > 0 {
+		d.wg.Add(1)
+		go d.report()
+	}
+	if d.config.StatusAddr != "" {
+		go d.serveStatus()
+	}
+}
+
+// Stop lets every lambda finish its current GC phase and waits for them
+func (d *GCDaemon) Stop() {
+	close(d.stop)
+	d.wg.Wait()
+}
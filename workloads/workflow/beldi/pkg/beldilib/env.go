@@ -14,6 +14,7 @@ type Env struct {
 	StepNumber  int32
 	Input       interface{}
 	TxnId       string
+	Coordinator string
 	Instruction string
 	Baseline    bool
 	FaasCtx     context.Context
@@ -0,0 +1,188 @@
+package beldilib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// StepHistory is a step of an instance. Invocations and reads are logged
+// in the log table of the lambda, writes in the LOGS maps of DAAL rows.
+type StepHistory struct {
+	Step   int32            `json:"step"`
+	Kind   string           `json:"kind"`
+	Table  string           `json:"table,omitempty"`
+	Key    string           `json:"key,omitempty"`
+	Callee string           `json:"callee,omitempty"`
+	Log    aws.JSONValue    `json:"log,omitempty"`
+	Result interface{}      `json:"result,omitempty"`
+	Child  *InstanceHistory `json:"child,omitempty"`
+}
+
+// InstanceHistory is reconstructed from the collector, log and data tables.
+// Start and End are in seconds.
+type InstanceHistory struct {
+	InstanceId string         `json:"instanceId"`
+	LambdaId   string         `json:"lambdaId,omitempty"`
+	Input      interface{}    `json:"input,omitempty"`
+	Start      int64          `json:"start,omitempty"`
+	End        int64          `json:"end,omitempty"`
+	Found      bool           `json:"found"`
+	Done       bool           `json:"done"`
+	Steps      []*StepHistory `json:"steps"`
+}
+
+// writeSteps collects the steps of instanceId logged in the LOGS maps of
+// rows of tables
+func writeSteps(instanceId string, tables []string) map[int32]*StepHistory {
+	steps := make(map[int32]*StepHistory)
+	prefix := instanceId + "-"
+	for _, table := range tables {
+		items := LibScan(table, []string{"K", "ROWHASH", "LOGS"},
+			expression.AttributeExists(expression.Name("LOGS")))
+		for _, item := range items {
+			logs, _ := item["LOGS"].(map[string]interface{})
+			for cid, res := range logs {
+				if !strings.HasPrefix(cid, prefix) {
+					continue
+				}
+				step, err := strconv.Atoi(cid[len(prefix):])
+				if err != nil {
+					continue
+				}
+				kind := "Write"
+				if res != nil {
+					kind = "CondWrite"
+				}
+				key, _ := item["K"].(string)
+				steps[int32(step)] = &StepHistory{
+					Step:   int32(step),
+					Kind:   kind,
+					Table:  table,
+					Key:    key,
+					Result: res,
+				}
+			}
+		}
+	}
+	return steps
+}
+
+func inspectInstance(lambdaId string, instanceId string, tables []string, visited map[string]bool) *InstanceHistory {
+	h := &InstanceHistory{
+		InstanceId: instanceId,
+		LambdaId:   lambdaId,
+		Steps:      make([]*StepHistory, 0),
+	}
+	if lambdaId == "" || visited[instanceId] {
+		return h
+	}
+	visited[instanceId] = true
+	intent := LibRead(fmt.Sprintf("%s-collector", lambdaId), aws.JSONValue{"InstanceId": instanceId},
+		[]string{"DONE", "INPUT", "ST", "TS"})
+	if len(intent) > 0 {
+		h.Found = true
+		h.Done, _ = intent["DONE"].(bool)
+		h.Input = intent["INPUT"]
+		if st, ok := intent["ST"].(float64); ok {
+			h.Start = int64(st)
+		}
+		if ts, ok := intent["TS"].(float64); ok {
+			h.End = int64(ts)
+		}
+	}
+
+	steps := writeSteps(instanceId, tables)
+	items := LibQuery(fmt.Sprintf("%s-log", lambdaId),
+		expression.Key("InstanceId").Equal(expression.Value(instanceId)),
+		[]string{"InstanceId", "StepNumber", "Callee", "CalleeName", "RET", "V", "VS", "Res"})
+	for _, item := range items {
+		stepNumber := int32(item["StepNumber"].(float64))
+		delete(item, "InstanceId")
+		delete(item, "StepNumber")
+		step := &StepHistory{Step: stepNumber, Kind: "Read", Log: item}
+		if childId, ok := item["Callee"].(string); ok {
+			step.Kind = "Invoke"
+			step.Callee, _ = item["CalleeName"].(string)
+			step.Result = item["RET"]
+			step.Child = inspectInstance(step.Callee, childId, tables, visited)
+			if step.Child.LambdaId == "" {
+				step.Child.LambdaId = "?"
+			}
+		} else if vs, ok := item["VS"]; ok {
+			step.Kind = "Scan"
+			step.Result = vs
+		} else if v, ok := item["V"]; ok {
+			step.Result = v
+		}
+		steps[stepNumber] = step
+	}
+	for _, step := range steps {
+		h.Steps = append(h.Steps, step)
+	}
+	sort.Slice(h.Steps, func(i, j int) bool { return h.Steps[i].Step < h.Steps[j].Step })
+	return h
+}
+
+// InspectInstance reconstructs the history of instance instanceId of
+// lambdaId, following the instances it invoked recursively. Writes are only
+// found in tables. Callees invoked before their names were logged are not
+// followed.
+func InspectInstance(lambdaId string, instanceId string, tables []string) *InstanceHistory {
+	return inspectInstance(lambdaId, instanceId, tables, make(map[string]bool))
+}
+
+func formatValue(v interface{}) string {
+	raw, err := json.Marshal(v)
+	CHECK(err)
+	s := string(raw)
+	if len(s) > 64 {
+		s = s[:61] + "..."
+	}
+	return s
+}
+
+func (h *InstanceHistory) render(w io.Writer, indent string) {
+	status := "NOT FOUND"
+	if h.Done {
+		status = "DONE"
+	} else if h.Found {
+		status = "RUNNING"
+	}
+	fmt.Fprintf(w, "%s%s %s %s", indent, h.LambdaId, h.InstanceId, status)
+	if h.Start != 0 {
+		fmt.Fprintf(w, " started=%s", time.Unix(h.Start, 0).Format(time.RFC3339))
+		if h.End != 0 {
+			fmt.Fprintf(w, " took=%ds", h.End-h.Start)
+		}
+	}
+	fmt.Fprintln(w)
+	for _, step := range h.Steps {
+		fmt.Fprintf(w, "%s  #%d %s", indent, step.Step, step.Kind)
+		if step.Table != "" {
+			fmt.Fprintf(w, " table=%s key=%s", step.Table, step.Key)
+		}
+		if step.Callee != "" {
+			fmt.Fprintf(w, " callee=%s", step.Callee)
+		}
+		if step.Result != nil {
+			fmt.Fprintf(w, " -> %s", formatValue(step.Result))
+		}
+		fmt.Fprintln(w)
+		if step.Child != nil {
+			step.Child.render(w, indent+"    ")
+		}
+	}
+}
+
+// Render writes the call tree of h, one line per instance and step
+func (h *InstanceHistory) Render(w io.Writer) {
+	h.render(w, "")
+}
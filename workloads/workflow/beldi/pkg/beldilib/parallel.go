@@ -0,0 +1,84 @@
+package beldilib
+
+import (
+	"sync"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// ParallelCall is one invocation of Parallel. Output is filled in by
+// Parallel, and decoded into Result unless it is nil.
+//
+// Then are invoked, concurrently, once the call returned and its Result is
+// decoded. A call whose input depends on that result sets MakeInput, which
+// is called right before it is invoked, instead of Input.
+type ParallelCall struct {
+	Callee    string
+	Input     interface{}
+	MakeInput func() interface{}
+	Result    interface{}
+	Output    interface{}
+	Then      []*ParallelCall
+	failure   interface{}
+}
+
+// Parallel invokes calls concurrently and waits for all of them, including
+// their Then. They take the next steps in order, each call followed by its
+// Then, so a replay numbers them the same. If calls panicked, Parallel
+// panics with the first failure in that order.
+// Invocations inside a transaction log their callees as steps, so they
+// cannot run in parallel.
+func Parallel(env *Env, calls ...*ParallelCall) {
+	if env.Instruction == "EXECUTE" {
+		panic("Parallel is not supported inside a transaction")
+	}
+	var ordered []*ParallelCall
+	steps := make(map[*ParallelCall]int32)
+	var reserve func(calls []*ParallelCall)
+	reserve = func(calls []*ParallelCall) {
+		for _, call := range calls {
+			steps[call] = env.StepNumber
+			env.StepNumber += 1
+			ordered = append(ordered, call)
+			reserve(call.Then)
+		}
+	}
+	reserve(calls)
+	var wg sync.WaitGroup
+	var run func(calls []*ParallelCall)
+	run = func(calls []*ParallelCall) {
+		wg.Add(len(calls))
+		for _, call := range calls {
+			go func(call *ParallelCall) {
+				defer wg.Done()
+				runCall(env, call, steps[call])
+				if call.failure == nil {
+					run(call.Then)
+				}
+			}(call)
+		}
+	}
+	run(calls)
+	wg.Wait()
+	for _, call := range ordered {
+		if call.failure != nil {
+			panic(call.failure)
+		}
+	}
+}
+
+// runCall invokes call and decodes its result. A panic cannot leave the
+// goroutine, so it is kept for Parallel to panic with.
+func runCall(env *Env, call *ParallelCall, stepNumber int32) {
+	defer func() {
+		call.failure = recover()
+	}()
+	input := call.Input
+	if call.MakeInput != nil {
+		input = call.MakeInput()
+	}
+	call.Output, _ = AssignedSyncInvoke(env, call.Callee, input, stepNumber)
+	if call.Result != nil {
+		CHECK(mapstructure.Decode(call.Output, call.Result))
+	}
+}
@@ -0,0 +1,96 @@
+package beldilib
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// The tail of a chain is found from a hint, either cached by this process
+// or kept in TAIL of the HEAD row, and checked by reading the hinted row.
+// A hint only ever falls behind, so its NEXTROW leads to the tail, unless
+// GC deleted the hinted row meanwhile. Then, or if the walk gets long, the
+// whole chain is queried by LastRow.
+
+// TailCacheSize bounds the number of keys whose tail this process caches,
+// 0 disables the cache
+var TailCacheSize = 4096
+
+// Rows read from a hint before falling back to LastRow
+const tailWalkLimit = 3
+
+var tailCache = map[string]string{}
+var tailCacheMutex = sync.Mutex{}
+
+func cachedTail(tablename string, key string) (string, bool) {
+	tailCacheMutex.Lock()
+	defer tailCacheMutex.Unlock()
+	row, exists := tailCache[tablename+writeSep+key]
+	return row, exists
+}
+
+func cacheTail(tablename string, key string, row string) {
+	if TailCacheSize <= 0 {
+		return
+	}
+	tailCacheMutex.Lock()
+	defer tailCacheMutex.Unlock()
+	id := tablename + writeSep + key
+	if _, exists := tailCache[id]; !exists && len(tailCache) >= TailCacheSize {
+		for victim := range tailCache {
+			delete(tailCache, victim)
+			break
+		}
+	}
+	tailCache[id] = row
+}
+
+// writeTailHint points TAIL of the HEAD row to row, for other processes
+func writeTailHint(tablename string, key string, row string) {
+	LibWrite(tablename, aws.JSONValue{"K": key, "ROWHASH": "HEAD"},
+		map[expression.NameBuilder]expression.OperandBuilder{
+			expression.Name("TAIL"): expression.Value(row),
+		})
+}
+
+// TailRow returns the last row of key, "" if key has no HEAD
+func TailRow(tablename string, key string) string {
+	row, cached := cachedTail(tablename, key)
+	hint := ""
+	if !cached {
+		head := LibRead(tablename, aws.JSONValue{"K": key, "ROWHASH": "HEAD"}, []string{"ROWHASH", "NEXTROW", "TAIL"})
+		if len(head) == 0 {
+			return ""
+		}
+		hint, _ = head["TAIL"].(string)
+		row = hint
+		if row == "" {
+			row = "HEAD"
+		}
+	}
+	for i := 0; i < tailWalkLimit; i++ {
+		res := LibRead(tablename, aws.JSONValue{"K": key, "ROWHASH": row}, []string{"ROWHASH", "NEXTROW"})
+		if len(res) == 0 {
+			// collected by GC
+			break
+		}
+		next, exists := res["NEXTROW"].(string)
+		if !exists {
+			cacheTail(tablename, key, row)
+			if !cached && row != hint && row != "HEAD" {
+				writeTailHint(tablename, key, row)
+			}
+			return row
+		}
+		row = next
+	}
+	row = LastRow(tablename, key)
+	if row != "" {
+		cacheTail(tablename, key, row)
+		if row != "HEAD" {
+			writeTailHint(tablename, key, row)
+		}
+	}
+	return row
+}
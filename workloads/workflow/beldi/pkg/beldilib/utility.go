@@ -25,6 +25,10 @@ func CreateMainTable(lambdaId string) {
 				AttributeName: aws.String("TS"),
 				AttributeType: aws.String("N"),
 			},
+			{
+				AttributeName: aws.String("GCQ"),
+				AttributeType: aws.String("S"),
+			},
 		},
 		KeySchema: []*dynamodb.KeySchemaElement{
 			{
@@ -63,6 +67,22 @@ func CreateMainTable(lambdaId string) {
 					ProjectionType:   aws.String("INCLUDE"),
 				},
 			},
+			&dynamodb.GlobalSecondaryIndex{
+				IndexName: aws.String("gcidx"),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("GCQ"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("TS"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				Projection: &dynamodb.Projection{
+					ProjectionType: aws.String("KEYS_ONLY"),
+				},
+			},
 		},
 		TableName: aws.String(kTablePrefix + lambdaId),
 	})
@@ -107,6 +127,10 @@ func CreateCollectorTable(lambdaId string) {
 				AttributeName: aws.String("TS"),
 				AttributeType: aws.String("N"),
 			},
+			{
+				AttributeName: aws.String("GCQ"),
+				AttributeType: aws.String("S"),
+			},
 		},
 		KeySchema: []*dynamodb.KeySchemaElement{
 			{
@@ -128,6 +152,22 @@ func CreateCollectorTable(lambdaId string) {
 					ProjectionType: aws.String("KEYS_ONLY"),
 				},
 			},
+			&dynamodb.GlobalSecondaryIndex{
+				IndexName: aws.String("gcidx"),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("GCQ"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("TS"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				Projection: &dynamodb.Projection{
+					ProjectionType: aws.String("KEYS_ONLY"),
+				},
+			},
 		},
 	})
 }
@@ -230,7 +270,7 @@ func WriteHead(tablename string, key string) {
 	LibWrite(tablename, aws.JSONValue{"K": key, "ROWHASH": "HEAD"},
 		map[expression.NameBuilder]expression.OperandBuilder{
 			expression.Name("GCSIZE"):  expression.Value(0),
-			expression.Name("LOGSIZE"): expression.Value(GLOGSIZE()),
+			expression.Name("LOGSIZE"): expression.Value(GetDAALConfig(tablename).LogSize),
 			expression.Name("NEXTROW"): expression.Value("ROW2"),
 			expression.Name("LOGS"):    expression.Value(aws.JSONValue{"ignore": nil}),
 		})
@@ -252,7 +292,7 @@ func WriteNRows(tablename string, key string, n int) {
 		LibWrite(tablename, aws.JSONValue{"K": key, "ROWHASH": fmt.Sprintf("ROW%d", i)},
 			map[expression.NameBuilder]expression.OperandBuilder{
 				expression.Name("GCSIZE"):  expression.Value(0),
-				expression.Name("LOGSIZE"): expression.Value(GLOGSIZE()),
+				expression.Name("LOGSIZE"): expression.Value(GetDAALConfig(tablename).LogSize),
 				expression.Name("NEXTROW"): expression.Value(fmt.Sprintf("ROW%d", i+1)),
 				expression.Name("LOGS"):    expression.Value(aws.JSONValue{"ignore": nil}),
 			})
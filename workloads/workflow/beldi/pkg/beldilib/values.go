@@ -0,0 +1,44 @@
+package beldilib
+
+import (
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/lithammer/shortuuid"
+)
+
+// logValue logs value in the log table the first time, and returns the
+// logged value on replay
+func logValue(env *Env, value string) string {
+	if TYPE == "BASELINE" {
+		return value
+	}
+	logKey := aws.JSONValue{"InstanceId": env.InstanceId, "StepNumber": env.StepNumber}
+	env.StepNumber += 1
+	if LibPut(env.LogTable, logKey, aws.JSONValue{"V": value}) {
+		return value
+	}
+	return LibRead(env.LogTable, logKey, []string{"V"})["V"].(string)
+}
+
+// UUID is shortuuid.New() as a step
+func UUID(env *Env) string {
+	return logValue(env, shortuuid.New())
+}
+
+// Now is time.Now() as a step
+func Now(env *Env) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, logValue(env, time.Now().Format(time.RFC3339Nano)))
+	CHECK(err)
+	return t
+}
+
+// Rand returns a generator seeded with a seed logged as a step, which draws
+// the same numbers on replay
+func Rand(env *Env) *rand.Rand {
+	seed, err := strconv.ParseInt(logValue(env, strconv.FormatInt(time.Now().UnixNano(), 10)), 10, 64)
+	CHECK(err)
+	return rand.New(rand.NewSource(seed))
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/eniac/Beldi/pkg/beldilib"
+)
+
+// gcbackfill prepares the tables of lambdas deployed before the gc index
+// for GC, once, e.g. gcbackfill hotel flight order
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s <lambda>...\n", os.Args[0])
+		os.Exit(2)
+	}
+	for _, lambdaId := range os.Args[1:] {
+		queued := beldilib.BackfillGC(lambdaId)
+		fmt.Printf("%s: queued %d finished intents\n", lambdaId, queued)
+	}
+}
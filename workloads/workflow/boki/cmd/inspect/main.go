@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/eniac/Beldi/pkg/cayonlib"
+)
+
+// inspect prints the call tree of a workflow instance. The shared log is
+// only reachable from functions, so the history is fetched from the
+// inspector function through the gateway.
+func main() {
+	gateway := flag.String("gateway", "http://localhost:8080", "Boki gateway address")
+	function := flag.String("function", "inspector", "Name of the inspector function")
+	raw := flag.Bool("json", false, "Print the history as JSON")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <instance id>\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	payload, err := json.Marshal(map[string]string{"InstanceId": flag.Arg(0)})
+	cayonlib.CHECK(err)
+	resp, err := http.Post(fmt.Sprintf("%s/function/%s", *gateway, *function), "application/json", bytes.NewReader(payload))
+	cayonlib.CHECK(err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	cayonlib.CHECK(err)
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "Inspector failed with %s: %s\n", resp.Status, body)
+		os.Exit(1)
+	}
+	if *raw {
+		os.Stdout.Write(body)
+		fmt.Println()
+		return
+	}
+	var history cayonlib.InstanceHistory
+	cayonlib.CHECK(json.Unmarshal(body, &history))
+	history.Render(os.Stdout)
+}
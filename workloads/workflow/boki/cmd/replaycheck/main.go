@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/eniac/Beldi/internal/media/core"
+	"github.com/eniac/Beldi/pkg/cayonlib"
+	"github.com/eniac/Beldi/pkg/localfaas"
+	"github.com/eniac/Beldi/pkg/replaycheck"
+)
+
+// replaycheck checks media handlers for non-deterministic steps. Callees
+// are stubbed, so only the checked function runs.
+func stub(env *cayonlib.Env) interface{} {
+	return 0
+}
+
+var uniqueId = cayonlib.NewService().
+	Register("UploadUniqueId2", func(env *cayonlib.Env, req core.ReqRequest) {
+		core.UploadUniqueId2(env, req.ReqId)
+	})
+
+func main() {
+	report := replaycheck.Check(func(fe *localfaas.Environment) {
+		fe.Register(core.TUniqueId(), cayonlib.CreateFuncHandlerFactory(uniqueId.Handler))
+		fe.Register(core.TComposeReview(), cayonlib.CreateFuncHandlerFactory(stub))
+	}, core.TUniqueId(), cayonlib.RPCInput{
+		Function: "UploadUniqueId2",
+		Input:    core.ReqRequest{ReqId: "replaycheck"},
+	})
+	fmt.Printf("%s: %s\n", core.TUniqueId(), report)
+	if !report.Ok() {
+		os.Exit(1)
+	}
+}
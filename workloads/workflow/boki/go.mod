@@ -8,11 +8,16 @@ require (
 	github.com/aws/aws-sdk-go v1.34.6
 	github.com/cespare/xxhash/v2 v2.1.1
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	cs.utexas.edu/zjia/faas-memdb v0.0.0
 	github.com/google/uuid v1.1.1 // indirect
 	github.com/hailocab/go-geoindex v0.0.0-20160127134810-64631bfe9711
 	github.com/lithammer/shortuuid v3.0.0+incompatible
 	github.com/mitchellh/mapstructure v1.3.3
 	github.com/golang/snappy v0.0.2
+	github.com/vmihailenco/msgpack/v5 v5.3.5
 )
 
 replace cs.utexas.edu/zjia/faas => /src/boki/worker/golang
+
+// memdb is a module of its own, shared with the beldi module
+replace cs.utexas.edu/zjia/faas-memdb => ../memdb
@@ -1,14 +1,39 @@
 package main
 
 import (
-	"github.com/aws/aws-lambda-go/lambda"
+	"context"
+	"fmt"
+
+	"cs.utexas.edu/zjia/faas"
+	"cs.utexas.edu/zjia/faas/types"
 	"github.com/eniac/Beldi/pkg/cayonlib"
 )
 
-func Handler() {
-	cayonlib.RestartAll("gateway")
+type collectorHandler struct {
+	env types.Environment
+}
+
+type collectorHandlerFactory struct{}
+
+func (h *collectorHandler) Call(ctx context.Context, input []byte) ([]byte, error) {
+	env := &cayonlib.Env{
+		LambdaId: "collector",
+		FaasCtx:  ctx,
+		FaasEnv:  h.env,
+	}
+	cayonlib.FireTimers(env)
+	cayonlib.RestartAll(env)
+	return []byte("OK"), nil
+}
+
+func (f *collectorHandlerFactory) New(env types.Environment, funcName string) (types.FuncHandler, error) {
+	return &collectorHandler{env: env}, nil
+}
+
+func (f *collectorHandlerFactory) GrpcNew(env types.Environment, service string) (types.GrpcFuncHandler, error) {
+	return nil, fmt.Errorf("Not implemented")
 }
 
 func main() {
-	lambda.Start(Handler)
+	faas.Serve(&collectorHandlerFactory{})
 }
@@ -78,6 +78,32 @@ type RPCInput struct {
 	Input    interface{}
 }
 
+type ReserveHotelRequest struct {
+	HotelId string `json:"hotelId"`
+	UserId  string `json:"userId"`
+}
+
+type AddHotelRequest struct {
+	HotelId string `json:"hotelId"`
+	Cap     int32  `json:"cap"`
+}
+
+type ReserveFlightRequest struct {
+	FlightId string `json:"flightId"`
+	UserId   string `json:"userId"`
+}
+
+type AddFlightRequest struct {
+	FlightId string `json:"flightId"`
+	Cap      int32  `json:"cap"`
+}
+
+type PlaceOrderRequest struct {
+	FlightId string `json:"flightId"`
+	HotelId  string `json:"hotelId"`
+	UserId   string `json:"userId"`
+}
+
 func Tgeo() string {
 	if cayonlib.TYPE == "BASELINE" {
 		return "bgeo"
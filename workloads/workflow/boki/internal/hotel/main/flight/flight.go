@@ -28,7 +28,7 @@ func BaseReserveFlight(env *cayonlib.Env, flightId string, userId string) bool {
 }
 
 func ReserveFlight(env *cayonlib.Env, flightId string, userId string) bool {
-	ok, item := cayonlib.TPLRead(env, data.Tflight(), flightId)
+	ok, item := cayonlib.TxnRead(env, data.Tflight(), flightId)
 	if !ok {
 		return false
 	}
@@ -37,11 +37,40 @@ func ReserveFlight(env *cayonlib.Env, flightId string, userId string) bool {
 	if flight.Cap == 0 {
 		return false
 	}
-	ok = cayonlib.TPLWrite(env, data.Tflight(), flightId,
+	ok = cayonlib.TxnWrite(env, data.Tflight(), flightId,
 		aws.JSONValue{"V.Cap": flight.Cap})
 	return ok
 }
 
+// HoldFlight takes one seat of flightId without a transaction, CancelFlight
+// gives it back. They are the step and compensation of the saga frontend.
+// Both update Cap atomically: a write can also fail because a concurrent
+// one was ordered after it, then it is retried as a new step.
+func HoldFlight(env *cayonlib.Env, flightId string, userId string) bool {
+	for {
+		if cayonlib.CondWrite(env, data.Tflight(), flightId, map[expression.NameBuilder]expression.OperandBuilder{
+			expression.Name("V.Cap"): expression.Name("V.Cap").Minus(expression.Value(1)),
+		}, expression.Name("V.Cap").GreaterThan(expression.Value(0))) {
+			return true
+		}
+		item := cayonlib.Read(env, data.Tflight(), flightId)
+		var flight Flight
+		cayonlib.CHECK(mapstructure.Decode(item, &flight))
+		if flight.Cap <= 0 {
+			return false
+		}
+	}
+}
+
+func CancelFlight(env *cayonlib.Env, flightId string, userId string) error {
+	if !cayonlib.RetryWrite(env, data.Tflight(), flightId, map[expression.NameBuilder]expression.OperandBuilder{
+		expression.Name("V.Cap"): expression.Name("V.Cap").Plus(expression.Value(1)),
+	}) {
+		return cayonlib.NewAppError("Contention", "Cannot give back a seat of flight %s", flightId)
+	}
+	return nil
+}
+
 func AddFlight(env *cayonlib.Env, flightId string, cap int32) {
 	cayonlib.Write(env, data.Tflight(), flightId, map[expression.NameBuilder]expression.OperandBuilder{
 		expression.Name("V"): expression.Value(Flight{
@@ -6,68 +6,76 @@ import (
 )
 
 func SendRequest(env *cayonlib.Env, userId string, flightId string, hotelId string) string {
+	order := data.PlaceOrderRequest{
+		FlightId: flightId,
+		HotelId:  hotelId,
+		UserId:   userId,
+	}
+	var reserved bool
 	if cayonlib.TYPE == "BASELINE" {
-		input := map[string]string{
-			"hotelId": hotelId,
-			"userId":  userId,
-		}
-		cayonlib.SyncInvoke(env, data.Thotel(), data.RPCInput{
-			Function: "BaseReserveHotel",
-			Input:    input,
-		})
-		input = map[string]string{
-			"flightId": flightId,
-			"userId":   userId,
-		}
-		cayonlib.SyncInvoke(env, data.Tflight(), data.RPCInput{
-			Function: "BaseReserveFlight",
-			Input:    input,
-		})
-		input = map[string]string{
-			"flightId": flightId,
-			"hotelId":  hotelId,
-			"userId":   userId,
-		}
-		cayonlib.AsyncInvoke(env, data.Torder(), data.RPCInput{
-			Function: "PlaceOrder",
-			Input:    input,
-		})
+		cayonlib.Call(env, data.Thotel(), "BaseReserveHotel", data.ReserveHotelRequest{
+			HotelId: hotelId,
+			UserId:  userId,
+		}, &reserved)
+		cayonlib.Call(env, data.Tflight(), "BaseReserveFlight", data.ReserveFlightRequest{
+			FlightId: flightId,
+			UserId:   userId,
+		}, &reserved)
+		cayonlib.CallAsync(env, data.Torder(), "PlaceOrder", order)
 		return ""
 	}
 	cayonlib.BeginTxn(env)
-	input := map[string]string{
-		"hotelId": hotelId,
-		"userId":  userId,
+	cayonlib.Call(env, data.Thotel(), "ReserveHotel", data.ReserveHotelRequest{
+		HotelId: hotelId,
+		UserId:  userId,
+	}, &reserved)
+	if !reserved {
+		cayonlib.AbortTxn(env)
+		return "Place Order Fails"
 	}
-	res, _ := cayonlib.SyncInvoke(env, data.Thotel(), data.RPCInput{
-		Function: "ReserveHotel",
-		Input:    input,
-	})
-	if !res.(bool) {
+	cayonlib.Call(env, data.Tflight(), "ReserveFlight", data.ReserveFlightRequest{
+		FlightId: flightId,
+		UserId:   userId,
+	}, &reserved)
+	if !reserved {
 		cayonlib.AbortTxn(env)
 		return "Place Order Fails"
 	}
-	input = map[string]string{
-		"flightId": flightId,
-		"userId":   userId,
+	if !cayonlib.CommitTxn(env) {
+		return "Place Order Fails"
 	}
-	res, _ = cayonlib.SyncInvoke(env, data.Tflight(), data.RPCInput{
-		Function: "ReserveFlight",
-		Input:    input,
-	})
-	if !res.(bool) {
-		cayonlib.AbortTxn(env)
+	cayonlib.CallAsync(env, data.Torder(), "PlaceOrder", order)
+	return "Place Order Success"
+}
+
+// SagaSendRequest places the order as a saga, holding no locks across hotel
+// and flight. The hotel hold is cancelled if the flight cannot be held.
+func SagaSendRequest(env *cayonlib.Env, userId string, flightId string, hotelId string) string {
+	hotelReq := data.ReserveHotelRequest{
+		HotelId: hotelId,
+		UserId:  userId,
+	}
+	saga := cayonlib.BeginSaga(env)
+	var held bool
+	if err := saga.Call(data.Thotel(), "HoldHotel", hotelReq, &held); err != nil || !held {
 		return "Place Order Fails"
 	}
-	input = map[string]string{
-		"flightId": flightId,
-		"hotelId":  hotelId,
-		"userId":   userId,
+	saga.AddCompensation(data.Thotel(), "CancelHotel", hotelReq)
+	if err := saga.Call(data.Tflight(), "HoldFlight", data.ReserveFlightRequest{
+		FlightId: flightId,
+		UserId:   userId,
+	}, &held); err != nil {
+		// Call compensated already
+		return "Place Order Fails"
+	}
+	if !held {
+		cayonlib.CHECK(saga.Compensate())
+		return "Place Order Fails"
 	}
-	cayonlib.CommitTxn(env)
-	cayonlib.AsyncInvoke(env, data.Torder(), data.RPCInput{
-		Function: "PlaceOrder",
-		Input:    input,
+	cayonlib.CallAsync(env, data.Torder(), "PlaceOrder", data.PlaceOrderRequest{
+		FlightId: flightId,
+		HotelId:  hotelId,
+		UserId:   userId,
 	})
 	return "Place Order Success"
 }
@@ -5,28 +5,28 @@ import (
 	"github.com/eniac/Beldi/internal/hotel/main/data"
 	"github.com/eniac/Beldi/internal/hotel/main/flight"
 	"github.com/eniac/Beldi/pkg/cayonlib"
-	"github.com/mitchellh/mapstructure"
 
 	"cs.utexas.edu/zjia/faas"
 )
 
-func Handler(env *cayonlib.Env) interface{} {
-	var rpcInput data.RPCInput
-	cayonlib.CHECK(mapstructure.Decode(env.Input, &rpcInput))
-	req := rpcInput.Input.(map[string]interface{})
-	switch rpcInput.Function {
-	case "ReserveFlight":
-		return flight.ReserveFlight(env, req["flightId"].(string), req["userId"].(string))
-	case "BaseReserveFlight":
-		return flight.BaseReserveFlight(env, req["flightId"].(string), req["userId"].(string))
-	case "AddFlight":
-		flight.AddFlight(env, req["flightId"].(string), int32(req["cap"].(float64)))
-		return 0
-	}
-	panic("no such function")
-}
+var service = cayonlib.NewService().
+	Register("ReserveFlight", func(env *cayonlib.Env, req data.ReserveFlightRequest) bool {
+		return flight.ReserveFlight(env, req.FlightId, req.UserId)
+	}).
+	Register("BaseReserveFlight", func(env *cayonlib.Env, req data.ReserveFlightRequest) bool {
+		return flight.BaseReserveFlight(env, req.FlightId, req.UserId)
+	}).
+	Register("HoldFlight", func(env *cayonlib.Env, req data.ReserveFlightRequest) bool {
+		return flight.HoldFlight(env, req.FlightId, req.UserId)
+	}).
+	Register("CancelFlight", func(env *cayonlib.Env, req data.ReserveFlightRequest) error {
+		return flight.CancelFlight(env, req.FlightId, req.UserId)
+	}).
+	Register("AddFlight", func(env *cayonlib.Env, req data.AddFlightRequest) {
+		flight.AddFlight(env, req.FlightId, req.Cap)
+	})
 
 func main() {
 	// lambda.Start(cayonlib.Wrapper(Handler))
-	faas.Serve(cayonlib.CreateFuncHandlerFactory(Handler))
+	faas.Serve(cayonlib.CreateFuncHandlerFactory(service.Handler))
 }
@@ -2,15 +2,22 @@ package main
 
 import (
 	// "github.com/aws/aws-lambda-go/lambda"
+	"github.com/eniac/Beldi/internal/hotel/main/data"
 	"github.com/eniac/Beldi/internal/hotel/main/frontend"
 	"github.com/eniac/Beldi/pkg/cayonlib"
 
 	"cs.utexas.edu/zjia/faas"
 )
 
+var SAGA = "DISABLE"
+
 func Handler(env *cayonlib.Env) interface{} {
-	req := env.Input.(map[string]interface{})
-	return frontend.SendRequest(env, req["userId"].(string), req["flightId"].(string), req["hotelId"].(string))
+	var req data.PlaceOrderRequest
+	cayonlib.DecodeValue(env.Input, &req)
+	if SAGA == "ENABLE" {
+		return frontend.SagaSendRequest(env, req.UserId, req.FlightId, req.HotelId)
+	}
+	return frontend.SendRequest(env, req.UserId, req.FlightId, req.HotelId)
 }
 
 func main() {
@@ -5,20 +5,16 @@ import (
 	"github.com/eniac/Beldi/internal/hotel/main/data"
 	"github.com/eniac/Beldi/internal/hotel/main/order"
 	"github.com/eniac/Beldi/pkg/cayonlib"
-	"github.com/mitchellh/mapstructure"
 
 	"cs.utexas.edu/zjia/faas"
 )
 
-func Handler(env *cayonlib.Env) interface{} {
-	var rpcInput data.RPCInput
-	cayonlib.CHECK(mapstructure.Decode(env.Input, &rpcInput))
-	req := rpcInput.Input.(map[string]interface{})
-	order.PlaceOrder(env, req["userId"].(string), req["flightId"].(string), req["hotelId"].(string))
-	return 0
-}
+var service = cayonlib.NewService().
+	Register("PlaceOrder", func(env *cayonlib.Env, req data.PlaceOrderRequest) {
+		order.PlaceOrder(env, req.UserId, req.FlightId, req.HotelId)
+	})
 
 func main() {
 	// lambda.Start(cayonlib.Wrapper(Handler))
-	faas.Serve(cayonlib.CreateFuncHandlerFactory(Handler))
+	faas.Serve(cayonlib.CreateFuncHandlerFactory(service.Handler))
 }
@@ -28,7 +28,7 @@ func BaseReserveHotel(env *cayonlib.Env, hotelId string, userId string) bool {
 }
 
 func ReserveHotel(env *cayonlib.Env, hotelId string, userId string) bool {
-	ok, item := cayonlib.TPLRead(env, data.Thotel(), hotelId)
+	ok, item := cayonlib.TxnRead(env, data.Thotel(), hotelId)
 	if !ok {
 		return false
 	}
@@ -37,11 +37,40 @@ func ReserveHotel(env *cayonlib.Env, hotelId string, userId string) bool {
 	if hotel.Cap == 0 {
 		return false
 	}
-	ok = cayonlib.TPLWrite(env, data.Thotel(), hotelId,
+	ok = cayonlib.TxnWrite(env, data.Thotel(), hotelId,
 		aws.JSONValue{"V.Cap": hotel.Cap})
 	return ok
 }
 
+// HoldHotel takes one seat of hotelId without a transaction, CancelHotel
+// gives it back. They are the step and compensation of the saga frontend.
+// Both update Cap atomically: a write can also fail because a concurrent
+// one was ordered after it, then it is retried as a new step.
+func HoldHotel(env *cayonlib.Env, hotelId string, userId string) bool {
+	for {
+		if cayonlib.CondWrite(env, data.Thotel(), hotelId, map[expression.NameBuilder]expression.OperandBuilder{
+			expression.Name("V.Cap"): expression.Name("V.Cap").Minus(expression.Value(1)),
+		}, expression.Name("V.Cap").GreaterThan(expression.Value(0))) {
+			return true
+		}
+		item := cayonlib.Read(env, data.Thotel(), hotelId)
+		var hotel Hotel
+		cayonlib.CHECK(mapstructure.Decode(item, &hotel))
+		if hotel.Cap <= 0 {
+			return false
+		}
+	}
+}
+
+func CancelHotel(env *cayonlib.Env, hotelId string, userId string) error {
+	if !cayonlib.RetryWrite(env, data.Thotel(), hotelId, map[expression.NameBuilder]expression.OperandBuilder{
+		expression.Name("V.Cap"): expression.Name("V.Cap").Plus(expression.Value(1)),
+	}) {
+		return cayonlib.NewAppError("Contention", "Cannot give back a seat of hotel %s", hotelId)
+	}
+	return nil
+}
+
 func AddHotel(env *cayonlib.Env, hotelId string, cap int32) {
 	cayonlib.Write(env, data.Thotel(), hotelId, map[expression.NameBuilder]expression.OperandBuilder{
 		expression.Name("V"): expression.Value(Hotel{
@@ -7,11 +7,30 @@ import (
 	"sort"
 )
 
+// GetRates reads the rate plans of all hotels in one snapshot step.
+// Instances that began before read each hotel in a Read step of its own,
+// and keep doing so when they are replayed.
 func GetRates(env *cayonlib.Env, req Request) Result {
+	if len(req.HotelIds) == 0 {
+		return Result{}
+	}
+	var results []interface{}
+	if logged := env.Fsm.GetStepLog(env.StepNumber); logged != nil && logged.Data["type"] == "Read" {
+		for _, i := range req.HotelIds {
+			results = append(results, cayonlib.Read(env, data.Trate(), i))
+		}
+	} else {
+		keys := make([]cayonlib.SnapshotKey, 0, len(req.HotelIds))
+		for _, i := range req.HotelIds {
+			keys = append(keys, cayonlib.SnapshotKey{Table: data.Trate(), Key: i})
+		}
+		var err error
+		results, err = cayonlib.SnapshotRead(env, keys)
+		cayonlib.CHECK(err)
+	}
 	var plans RatePlans
-	for _, i := range req.HotelIds {
+	for _, res := range results {
 		plan := data.RatePlan{}
-		res := cayonlib.Read(env, data.Trate(), i)
 		err := mapstructure.Decode(res, &plan)
 		cayonlib.CHECK(err)
 		if plan.HotelId != "" {
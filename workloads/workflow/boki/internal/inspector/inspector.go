@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"cs.utexas.edu/zjia/faas"
+	"cs.utexas.edu/zjia/faas/types"
+	"github.com/eniac/Beldi/pkg/cayonlib"
+)
+
+type inspectRequest struct {
+	InstanceId string
+}
+
+type inspectorHandler struct {
+	env types.Environment
+}
+
+type inspectorHandlerFactory struct{}
+
+func (h *inspectorHandler) Call(ctx context.Context, input []byte) ([]byte, error) {
+	var req inspectRequest
+	if err := json.Unmarshal(input, &req); err != nil {
+		return nil, err
+	}
+	env := &cayonlib.Env{
+		LambdaId: "inspector",
+		FaasCtx:  ctx,
+		FaasEnv:  h.env,
+	}
+	return json.Marshal(cayonlib.InspectInstance(env, req.InstanceId))
+}
+
+func (f *inspectorHandlerFactory) New(env types.Environment, funcName string) (types.FuncHandler, error) {
+	return &inspectorHandler{env: env}, nil
+}
+
+func (f *inspectorHandlerFactory) GrpcNew(env types.Environment, service string) (types.GrpcFuncHandler, error) {
+	return nil, fmt.Errorf("Not implemented")
+}
+
+func main() {
+	faas.Serve(&inspectorHandlerFactory{})
+}
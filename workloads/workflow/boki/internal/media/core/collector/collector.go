@@ -1,25 +1,39 @@
 package main
 
 import (
-	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/eniac/Beldi/pkg/beldilib"
-	"sync"
+	"context"
+	"fmt"
+
+	"cs.utexas.edu/zjia/faas"
+	"cs.utexas.edu/zjia/faas/types"
+	"github.com/eniac/Beldi/pkg/cayonlib"
 )
 
-func Handler() {
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go func() {
-		defer wg.Done()
-		beldilib.RestartAll("Frontend")
-	}()
-	go func() {
-		defer wg.Done()
-		beldilib.RestartAll("ComposeReview")
-	}()
-	wg.Wait()
+type collectorHandler struct {
+	env types.Environment
+}
+
+type collectorHandlerFactory struct{}
+
+func (h *collectorHandler) Call(ctx context.Context, input []byte) ([]byte, error) {
+	env := &cayonlib.Env{
+		LambdaId: "collector",
+		FaasCtx:  ctx,
+		FaasEnv:  h.env,
+	}
+	cayonlib.FireTimers(env)
+	cayonlib.RestartAll(env)
+	return []byte("OK"), nil
+}
+
+func (f *collectorHandlerFactory) New(env types.Environment, funcName string) (types.FuncHandler, error) {
+	return &collectorHandler{env: env}, nil
+}
+
+func (f *collectorHandlerFactory) GrpcNew(env types.Environment, service string) (types.GrpcFuncHandler, error) {
+	return nil, fmt.Errorf("Not implemented")
 }
 
 func main() {
-	lambda.Start(Handler)
+	faas.Serve(&collectorHandlerFactory{})
 }
@@ -104,25 +104,16 @@ func TryComposeAndUpload(env *cayonlib.Env, reqId string) {
 			}()
 			var review Review
 			cayonlib.CHECK(mapstructure.Decode(res, &review))
-			cayonlib.AsyncInvoke(env, TReviewStorage(), RPCInput{
-				Function: "StoreReview",
-				Input:    review,
+			cayonlib.CallAsync(env, TReviewStorage(), "StoreReview", review)
+			cayonlib.CallAsync(env, TUserReview(), "UploadUserReview", UserReviewRequest{
+				UserId:    review.UserId,
+				ReviewId:  review.ReviewId,
+				Timestamp: review.Timestamp,
 			})
-			cayonlib.AsyncInvoke(env, TUserReview(), RPCInput{
-				Function: "UploadUserReview",
-				Input: aws.JSONValue{
-					"userId":    review.UserId,
-					"reviewId":  review.ReviewId,
-					"timestamp": review.Timestamp,
-				},
-			})
-			cayonlib.AsyncInvoke(env, TMovieReview(), RPCInput{
-				Function: "UploadMovieReview",
-				Input: aws.JSONValue{
-					"movieId":   review.MovieId,
-					"reviewId":  review.ReviewId,
-					"timestamp": review.Timestamp,
-				},
+			cayonlib.CallAsync(env, TMovieReview(), "UploadMovieReview", MovieReviewRequest{
+				MovieId:   review.MovieId,
+				ReviewId:  review.ReviewId,
+				Timestamp: review.Timestamp,
 			})
 			wg.Wait()
 		}
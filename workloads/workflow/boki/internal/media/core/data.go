@@ -58,9 +58,108 @@ type Page struct {
 	Plot      string
 }
 
-type RPCInput struct {
-	Function string
-	Input    interface{}
+// Requests of the operations of the media services. Their json names are
+// the keys of the maps the operations took before, so invocations logged
+// then decode into them.
+
+type ReqRequest struct {
+	ReqId string `json:"reqId"`
+}
+
+type UniqueIdRequest struct {
+	ReqId    string `json:"reqId"`
+	ReviewId string `json:"reviewId"`
+}
+
+type TextRequest struct {
+	ReqId string `json:"reqId"`
+	Text  string `json:"text"`
+}
+
+type RatingRequest struct {
+	ReqId  string `json:"reqId"`
+	Rating int32  `json:"rating"`
+}
+
+type UserIdRequest struct {
+	ReqId  string `json:"reqId"`
+	UserId string `json:"userId"`
+}
+
+type MovieIdRequest struct {
+	ReqId   string `json:"reqId"`
+	MovieId string `json:"movieId"`
+}
+
+type UploadMovieRequest struct {
+	ReqId  string `json:"reqId"`
+	Title  string `json:"title"`
+	Rating int32  `json:"rating"`
+}
+
+type RegisterMovieIdRequest struct {
+	Title   string `json:"title"`
+	MovieId string `json:"movieId"`
+}
+
+type WriteMovieInfoRequest struct {
+	Info MovieInfo `json:"info"`
+}
+
+type MovieRequest struct {
+	MovieId string `json:"movieId"`
+}
+
+type UpdateRatingRequest struct {
+	MovieId              string `json:"movieId"`
+	SumUncommittedRating int32  `json:"sumUncommittedRating"`
+	NumUncommittedRating int32  `json:"numUncommittedRating"`
+}
+
+type MovieReviewRequest struct {
+	MovieId   string `json:"movieId"`
+	ReviewId  string `json:"reviewId"`
+	Timestamp string `json:"timestamp"`
+}
+
+type UserReviewRequest struct {
+	UserId    string `json:"userId"`
+	ReviewId  string `json:"reviewId"`
+	Timestamp string `json:"timestamp"`
+}
+
+type UserRequest struct {
+	UserId string `json:"userId"`
+}
+
+type PageRequest struct {
+	MovieId string `json:"MovieId"`
+}
+
+type WritePlotRequest struct {
+	PlotId string `json:"plotId"`
+	Plot   string `json:"plot"`
+}
+
+type PlotRequest struct {
+	PlotId string `json:"plotId"`
+}
+
+type RegisterUserRequest struct {
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+}
+
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type UploadUserRequest struct {
+	ReqId    string `json:"reqId"`
+	Username string `json:"username"`
 }
 
 func TCastInfo() string {
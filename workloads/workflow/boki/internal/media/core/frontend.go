@@ -1,10 +1,7 @@
 package core
 
 import (
-	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/eniac/Beldi/pkg/cayonlib"
-	"sync"
 )
 
 type ComposeInput struct {
@@ -15,48 +12,35 @@ type ComposeInput struct {
 	Text     string
 }
 
-func Compose(env *cayonlib.Env, input ComposeInput) {
+// Compose uploads a review, the four uploads in parallel. It returns the
+// *AppError the first of them failed with.
+func Compose(env *cayonlib.Env, input ComposeInput) error {
 	reqId := env.InstanceId
-	res, _ := cayonlib.SyncInvoke(env, TComposeReview(), RPCInput{
-		Function: "UploadReq",
-		Input:    aws.JSONValue{"reqId": reqId},
-	})
-	if res.(float64) != 0 {
-		fmt.Println(fmt.Sprintf("DEBUG: result is %s", res))
-	}
-	invoke1 := cayonlib.ProposeInvoke(env, TUniqueId())
-	invoke2 := cayonlib.ProposeInvoke(env, TUser())
-	invoke3 := cayonlib.ProposeInvoke(env, TMovieId())
-	invoke4 := cayonlib.ProposeInvoke(env, TText())
-	var wg sync.WaitGroup
-	wg.Add(4)
-	go func() {
-		defer wg.Done()
-		cayonlib.AssignedSyncInvoke(env, TUniqueId(), RPCInput{
+	cayonlib.Call(env, TComposeReview(), "UploadReq", ReqRequest{ReqId: reqId}, nil)
+	return cayonlib.Parallel(env,
+		&cayonlib.ParallelCall{
+			Callee:   TUniqueId(),
 			Function: "UploadUniqueId2",
-			Input:    aws.JSONValue{"reqId": reqId},
-		}, invoke1)
-	}()
-	go func() {
-		defer wg.Done()
-		cayonlib.AssignedSyncInvoke(env, TUser(), RPCInput{
+			Input:    ReqRequest{ReqId: reqId},
+		},
+		&cayonlib.ParallelCall{
+			Callee:   TUser(),
 			Function: "UploadUser",
-			Input:    aws.JSONValue{"reqId": reqId, "username": input.Username},
-		}, invoke2)
-	}()
-	go func() {
-		defer wg.Done()
-		cayonlib.AssignedSyncInvoke(env, TMovieId(), RPCInput{
+			Input:    UploadUserRequest{ReqId: reqId, Username: input.Username},
+		},
+		&cayonlib.ParallelCall{
+			Callee:   TMovieId(),
 			Function: "UploadMovie",
-			Input:    aws.JSONValue{"reqId": reqId, "title": input.Title, "rating": input.Rating},
-		}, invoke3)
-	}()
-	go func() {
-		defer wg.Done()
-		cayonlib.AssignedSyncInvoke(env, TText(), RPCInput{
+			Input: UploadMovieRequest{
+				ReqId:  reqId,
+				Title:  input.Title,
+				Rating: int32(input.Rating),
+			},
+		},
+		&cayonlib.ParallelCall{
+			Callee:   TText(),
 			Function: "UploadText2",
-			Input:    aws.JSONValue{"reqId": reqId, "text": input.Text},
-		}, invoke4)
-	}()
-	wg.Wait()
+			Input:    TextRequest{ReqId: reqId, Text: input.Text},
+		},
+	)
 }
@@ -4,39 +4,31 @@ import (
 	// "github.com/aws/aws-lambda-go/lambda"
 	"github.com/eniac/Beldi/internal/media/core"
 	"github.com/eniac/Beldi/pkg/cayonlib"
-	"github.com/mitchellh/mapstructure"
 
 	"cs.utexas.edu/zjia/faas"
 )
 
-func Handler(env *cayonlib.Env) interface{} {
-	var rpcInput core.RPCInput
-	cayonlib.CHECK(mapstructure.Decode(env.Input, &rpcInput))
-	req := rpcInput.Input.(map[string]interface{})
-	switch rpcInput.Function {
-	case "UploadReq":
-		core.UploadReq(env, req["reqId"].(string))
-		return 0
-	case "UploadUniqueId":
-		core.UploadUniqueId(env, req["reqId"].(string), req["reviewId"].(string))
-		return 0
-	case "UploadText":
-		core.UploadText(env, req["reqId"].(string), req["text"].(string))
-		return 0
-	case "UploadRating":
-		core.UploadRating(env, req["reqId"].(string), int32(req["rating"].(float64)))
-		return 0
-	case "UploadUserId":
-		core.UploadUserId(env, req["reqId"].(string), req["userId"].(string))
-		return 0
-	case "UploadMovieId":
-		core.UploadMovieId(env, req["reqId"].(string), req["movieId"].(string))
-		return 0
-	}
-	panic("no such function")
-}
+var service = cayonlib.NewService().
+	Register("UploadReq", func(env *cayonlib.Env, req core.ReqRequest) {
+		core.UploadReq(env, req.ReqId)
+	}).
+	Register("UploadUniqueId", func(env *cayonlib.Env, req core.UniqueIdRequest) {
+		core.UploadUniqueId(env, req.ReqId, req.ReviewId)
+	}).
+	Register("UploadText", func(env *cayonlib.Env, req core.TextRequest) {
+		core.UploadText(env, req.ReqId, req.Text)
+	}).
+	Register("UploadRating", func(env *cayonlib.Env, req core.RatingRequest) {
+		core.UploadRating(env, req.ReqId, req.Rating)
+	}).
+	Register("UploadUserId", func(env *cayonlib.Env, req core.UserIdRequest) {
+		core.UploadUserId(env, req.ReqId, req.UserId)
+	}).
+	Register("UploadMovieId", func(env *cayonlib.Env, req core.MovieIdRequest) {
+		core.UploadMovieId(env, req.ReqId, req.MovieId)
+	})
 
 func main() {
 	// lambda.Start(cayonlib.Wrapper(Handler))
-	faas.Serve(cayonlib.CreateFuncHandlerFactory(Handler))
+	faas.Serve(cayonlib.CreateFuncHandlerFactory(service.Handler))
 }
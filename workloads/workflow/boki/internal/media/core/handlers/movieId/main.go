@@ -4,27 +4,19 @@ import (
 	// "github.com/aws/aws-lambda-go/lambda"
 	"github.com/eniac/Beldi/internal/media/core"
 	"github.com/eniac/Beldi/pkg/cayonlib"
-	"github.com/mitchellh/mapstructure"
 
 	"cs.utexas.edu/zjia/faas"
 )
 
-func Handler(env *cayonlib.Env) interface{} {
-	var rpcInput core.RPCInput
-	cayonlib.CHECK(mapstructure.Decode(env.Input, &rpcInput))
-	req := rpcInput.Input.(map[string]interface{})
-	switch rpcInput.Function {
-	case "UploadMovie":
-		core.UploadMovie(env, req["reqId"].(string), req["title"].(string), int32(req["rating"].(float64)))
-		return 0
-	case "RegisterMovieId":
-		core.RegisterMovieId(env, req["title"].(string), req["movieId"].(string))
-		return 0
-	}
-	panic("no such function")
-}
+var service = cayonlib.NewService().
+	Register("UploadMovie", func(env *cayonlib.Env, req core.UploadMovieRequest) {
+		core.UploadMovie(env, req.ReqId, req.Title, req.Rating)
+	}).
+	Register("RegisterMovieId", func(env *cayonlib.Env, req core.RegisterMovieIdRequest) {
+		core.RegisterMovieId(env, req.Title, req.MovieId)
+	})
 
 func main() {
 	// lambda.Start(cayonlib.Wrapper(Handler))
-	faas.Serve(cayonlib.CreateFuncHandlerFactory(Handler))
+	faas.Serve(cayonlib.CreateFuncHandlerFactory(service.Handler))
 }
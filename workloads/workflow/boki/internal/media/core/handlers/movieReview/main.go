@@ -4,27 +4,19 @@ import (
 	// "github.com/aws/aws-lambda-go/lambda"
 	"github.com/eniac/Beldi/internal/media/core"
 	"github.com/eniac/Beldi/pkg/cayonlib"
-	"github.com/mitchellh/mapstructure"
 
 	"cs.utexas.edu/zjia/faas"
 )
 
-func Handler(env *cayonlib.Env) interface{} {
-	var rpcInput core.RPCInput
-	cayonlib.CHECK(mapstructure.Decode(env.Input, &rpcInput))
-	req := rpcInput.Input.(map[string]interface{})
-	switch rpcInput.Function {
-	case "UploadMovieReview":
-		core.UploadMovieReview(env, req["movieId"].(string),
-			req["reviewId"].(string), req["timestamp"].(string))
-		return 0
-	case "ReadMovieReviews":
-		return core.ReadMovieReviews(env, req["movieId"].(string))
-	}
-	panic("no such function")
-}
+var service = cayonlib.NewService().
+	Register("UploadMovieReview", func(env *cayonlib.Env, req core.MovieReviewRequest) {
+		core.UploadMovieReview(env, req.MovieId, req.ReviewId, req.Timestamp)
+	}).
+	Register("ReadMovieReviews", func(env *cayonlib.Env, req core.MovieRequest) []core.Review {
+		return core.ReadMovieReviews(env, req.MovieId)
+	})
 
 func main() {
 	// lambda.Start(cayonlib.Wrapper(Handler))
-	faas.Serve(cayonlib.CreateFuncHandlerFactory(Handler))
+	faas.Serve(cayonlib.CreateFuncHandlerFactory(service.Handler))
 }
@@ -4,26 +4,19 @@ import (
 	// "github.com/aws/aws-lambda-go/lambda"
 	"github.com/eniac/Beldi/internal/media/core"
 	"github.com/eniac/Beldi/pkg/cayonlib"
-	"github.com/mitchellh/mapstructure"
 
 	"cs.utexas.edu/zjia/faas"
 )
 
-func Handler(env *cayonlib.Env) interface{} {
-	var rpcInput core.RPCInput
-	cayonlib.CHECK(mapstructure.Decode(env.Input, &rpcInput))
-	req := rpcInput.Input.(map[string]interface{})
-	switch rpcInput.Function {
-	case "WritePlot":
-		core.WritePlot(env, req["plotId"].(string), req["plot"].(string))
-		return 0
-	case "ReadPlot":
-		return core.ReadPlot(env, req["plotId"].(string))
-	}
-	panic("no such function")
-}
+var service = cayonlib.NewService().
+	Register("WritePlot", func(env *cayonlib.Env, req core.WritePlotRequest) {
+		core.WritePlot(env, req.PlotId, req.Plot)
+	}).
+	Register("ReadPlot", func(env *cayonlib.Env, req core.PlotRequest) string {
+		return core.ReadPlot(env, req.PlotId)
+	})
 
 func main() {
 	// lambda.Start(cayonlib.Wrapper(Handler))
-	faas.Serve(cayonlib.CreateFuncHandlerFactory(Handler))
+	faas.Serve(cayonlib.CreateFuncHandlerFactory(service.Handler))
 }
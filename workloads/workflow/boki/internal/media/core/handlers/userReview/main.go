@@ -4,27 +4,19 @@ import (
 	// "github.com/aws/aws-lambda-go/lambda"
 	"github.com/eniac/Beldi/internal/media/core"
 	"github.com/eniac/Beldi/pkg/cayonlib"
-	"github.com/mitchellh/mapstructure"
 
 	"cs.utexas.edu/zjia/faas"
 )
 
-func Handler(env *cayonlib.Env) interface{} {
-	var rpcInput core.RPCInput
-	cayonlib.CHECK(mapstructure.Decode(env.Input, &rpcInput))
-	req := rpcInput.Input.(map[string]interface{})
-	switch rpcInput.Function {
-	case "UploadUserReview":
-		core.UploadUserReview(env, req["userId"].(string),
-			req["reviewId"].(string), req["timestamp"].(string))
-		return 0
-	case "ReadUserReviews":
-		return core.ReadUserReviews(env, req["userId"].(string))
-	}
-	panic("no such function")
-}
+var service = cayonlib.NewService().
+	Register("UploadUserReview", func(env *cayonlib.Env, req core.UserReviewRequest) {
+		core.UploadUserReview(env, req.UserId, req.ReviewId, req.Timestamp)
+	}).
+	Register("ReadUserReviews", func(env *cayonlib.Env, req core.UserRequest) []core.Review {
+		return core.ReadUserReviews(env, req.UserId)
+	})
 
 func main() {
 	// lambda.Start(cayonlib.Wrapper(Handler))
-	faas.Serve(cayonlib.CreateFuncHandlerFactory(Handler))
+	faas.Serve(cayonlib.CreateFuncHandlerFactory(service.Handler))
 }
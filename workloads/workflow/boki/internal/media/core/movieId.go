@@ -14,20 +14,8 @@ func UploadMovie(env *cayonlib.Env, reqId string, title string, rating int32) {
 	}
 	val := item.(map[string]interface{})
 	if movieId, exist := val["movieId"].(string); exist {
-		cayonlib.AsyncInvoke(env, TComposeReview(), RPCInput{
-			Function: "UploadMovieId",
-			Input: aws.JSONValue{
-				"movieId": movieId,
-				"reqId":   reqId,
-			},
-		})
-		cayonlib.AsyncInvoke(env, TRating(), RPCInput{
-			Function: "UploadRating2",
-			Input: aws.JSONValue{
-				"reqId":  reqId,
-				"rating": rating,
-			},
-		})
+		cayonlib.CallAsync(env, TComposeReview(), "UploadMovieId", MovieIdRequest{ReqId: reqId, MovieId: movieId})
+		cayonlib.CallAsync(env, TRating(), "UploadRating2", RatingRequest{ReqId: reqId, Rating: rating})
 	}
 }
 
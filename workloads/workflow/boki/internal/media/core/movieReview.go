@@ -34,10 +34,6 @@ func ReadMovieReviews(env *cayonlib.Env, movieId string) []Review {
 		reviewIds = append(reviewIds, review.ReviewId)
 	}
 	var res []Review
-	output, _ := cayonlib.SyncInvoke(env, TReviewStorage(), RPCInput{
-		Function: "ReadReviews",
-		Input:    reviewIds,
-	})
-	cayonlib.CHECK(mapstructure.Decode(output, &res))
+	cayonlib.Call(env, TReviewStorage(), "ReadReviews", reviewIds, &res)
 	return res
 }
@@ -1,10 +1,7 @@
 package core
 
 import (
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/eniac/Beldi/pkg/cayonlib"
-	"github.com/mitchellh/mapstructure"
-	"sync"
 )
 
 func ReadPage(env *cayonlib.Env, movieId string) Page {
@@ -12,48 +9,41 @@ func ReadPage(env *cayonlib.Env, movieId string) Page {
 	var reviews []Review
 	var castInfos []CastInfo
 	var plot string
-	invoke1 := cayonlib.ProposeInvoke(env, TMovieInfo())
-	invoke2 := cayonlib.ProposeInvoke(env, TCastInfo())
-	invoke3 := cayonlib.ProposeInvoke(env, TPlot())
-	invoke4 := cayonlib.ProposeInvoke(env, TMovieReview())
-	var wg sync.WaitGroup
-	wg.Add(4)
-	go func() {
-		defer wg.Done()
-		res, _ := cayonlib.AssignedSyncInvoke(env, TMovieInfo(), RPCInput{
+	cayonlib.CHECK(cayonlib.Parallel(env,
+		&cayonlib.ParallelCall{
+			Callee:   TMovieInfo(),
 			Function: "ReadMovieInfo",
-			Input:    aws.JSONValue{"movieId": movieId},
-		}, invoke1)
-		cayonlib.CHECK(mapstructure.Decode(res, &movieInfo))
-		var ids []string
-		for _, cast := range movieInfo.Casts {
-			ids = append(ids, cast.CastInfoId)
-		}
-		go func() {
-			defer wg.Done()
-			res, _ := cayonlib.AssignedSyncInvoke(env, TCastInfo(), RPCInput{
-				Function: "ReadCastInfo",
-				Input:    ids,
-			}, invoke2)
-			cayonlib.CHECK(mapstructure.Decode(res, &castInfos))
-		}()
-		go func() {
-			defer wg.Done()
-			res, _ := cayonlib.AssignedSyncInvoke(env, TPlot(), RPCInput{
-				Function: "ReadPlot",
-				Input:    aws.JSONValue{"plotId": movieInfo.PlotId},
-			}, invoke3)
-			cayonlib.CHECK(mapstructure.Decode(res, &plot))
-		}()
-	}()
-	go func() {
-		defer wg.Done()
-		res, _ := cayonlib.AssignedSyncInvoke(env, TMovieReview(), RPCInput{
+			Input:    MovieRequest{MovieId: movieId},
+			Result:   &movieInfo,
+			Then: []*cayonlib.ParallelCall{
+				{
+					Callee:   TCastInfo(),
+					Function: "ReadCastInfo",
+					MakeInput: func() interface{} {
+						var ids []string
+						for _, cast := range movieInfo.Casts {
+							ids = append(ids, cast.CastInfoId)
+						}
+						return ids
+					},
+					Result: &castInfos,
+				},
+				{
+					Callee:   TPlot(),
+					Function: "ReadPlot",
+					MakeInput: func() interface{} {
+						return PlotRequest{PlotId: movieInfo.PlotId}
+					},
+					Result: &plot,
+				},
+			},
+		},
+		&cayonlib.ParallelCall{
+			Callee:   TMovieReview(),
 			Function: "ReadMovieReviews",
-			Input:    aws.JSONValue{"movieId": movieId},
-		}, invoke4)
-		cayonlib.CHECK(mapstructure.Decode(res, &reviews))
-	}()
-	wg.Wait()
+			Input:    MovieRequest{MovieId: movieId},
+			Result:   &reviews,
+		},
+	))
 	return Page{CastInfos: castInfos, Reviews: reviews, MovieInfo: movieInfo, Plot: plot}
 }
@@ -3,19 +3,16 @@ package core
 import (
 	"crypto/sha512"
 	"encoding/hex"
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/eniac/Beldi/pkg/cayonlib"
-	"github.com/lithammer/shortuuid"
 	"github.com/mitchellh/mapstructure"
-	"time"
 )
 
 func RegisterUserWithUserId(env *cayonlib.Env, firstName string, lastName string, username string, password string,
 	userId string) {
 	hasher := sha512.New()
-	salt := shortuuid.New()
+	salt := cayonlib.UUID(env)
 	hasher.Write([]byte(password + salt))
 	passwordHash := hex.EncodeToString(hasher.Sum(nil))
 	user := User{
@@ -32,10 +29,10 @@ func RegisterUserWithUserId(env *cayonlib.Env, firstName string, lastName string
 }
 
 func RegisterUser(env *cayonlib.Env, firstName string, lastName string, username string, password string) {
-	RegisterUserWithUserId(env, firstName, lastName, username, password, shortuuid.New())
+	RegisterUserWithUserId(env, firstName, lastName, username, password, cayonlib.UUID(env))
 }
 
-func Login(env *cayonlib.Env, username string, password string) string {
+func Login(env *cayonlib.Env, username string, password string) (string, error) {
 	item := cayonlib.Read(env, TUser(), username)
 	var user User
 	cayonlib.CHECK(mapstructure.Decode(item, &user))
@@ -45,14 +42,14 @@ func Login(env *cayonlib.Env, username string, password string) string {
 	if passwordHash == user.Password {
 		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 			"user_id":   user.UserId,
-			"timestamp": time.Now().Format("20060102150405"),
+			"timestamp": cayonlib.Now(env).Format("20060102150405"),
 			"TTL":       "60000",
 		})
 		tokenString, err := token.SignedString("secret")
 		cayonlib.CHECK(err)
-		return tokenString
+		return tokenString, nil
 	} else {
-		panic("Password not correct")
+		return "", cayonlib.NewAppError("Unauthorized", "Password not correct")
 	}
 }
 
@@ -60,11 +57,5 @@ func UploadUser(env *cayonlib.Env, reqId string, username string) {
 	item := cayonlib.Read(env, TUser(), username)
 	var user User
 	cayonlib.CHECK(mapstructure.Decode(item, &user))
-	cayonlib.AsyncInvoke(env, TComposeReview(), RPCInput{
-		Function: "UploadUserId",
-		Input: aws.JSONValue{
-			"reqId":  reqId,
-			"userId": user.UserId,
-		},
-	})
+	cayonlib.CallAsync(env, TComposeReview(), "UploadUserId", UserIdRequest{ReqId: reqId, UserId: user.UserId})
 }
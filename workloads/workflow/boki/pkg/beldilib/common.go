@@ -3,6 +3,7 @@ package beldilib
 import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	// "github.com/aws/aws-sdk-go/service/lambda"
 	"strconv"
 )
@@ -18,15 +19,25 @@ var sess = session.Must(session.NewSessionWithOptions(session.Options{
 //	Region:                        aws.String("us-east-1"),
 //	CredentialsChainVerboseErrors: aws.Bool(true)})
 
-var DBClient = dynamodb.New(sess)
+// DBClient can be replaced, e.g. with memdb.New(), to run without AWS
+var DBClient dynamodbiface.DynamoDBAPI = dynamodb.New(sess)
 
+// DLOGSIZE is the default LOGSIZE of a row, tables override it with
+// SetDAALConfig
 var DLOGSIZE = "1000"
 
-func GLOGSIZE() int {
-	r, _ := strconv.Atoi(DLOGSIZE)
+var defaultLogSize = parseLogSize(DLOGSIZE)
+
+func parseLogSize(s string) int {
+	r, err := strconv.Atoi(s)
+	CHECK(err)
 	return r
 }
 
+func GLOGSIZE() int {
+	return defaultLogSize
+}
+
 var T = int64(60)
 
 var TYPE = "BELDI"
@@ -26,6 +26,7 @@ type InputWrapper struct {
 	InstanceId  string      `mapstructure:"InstanceId"`
 	Input       interface{} `mapstructure:"Input"`
 	TxnId       string      `mapstructure:"TxnId"`
+	Coordinator string      `mapstructure:"Coordinator"`
 	Instruction string      `mapstructure:"Instruction"`
 	Async       bool        `mapstructure:"Async"`
 }
@@ -107,6 +108,7 @@ func PrepareEnv(iw *InputWrapper, lambdaId string) *Env {
 		StepNumber:  0,
 		Input:       iw.Input,
 		TxnId:       iw.TxnId,
+		Coordinator: iw.Coordinator,
 		Instruction: iw.Instruction,
 	}
 }
@@ -119,6 +121,7 @@ func SyncInvoke(env *Env, callee string, input interface{}) (interface{}, string
 			CallerName:  "",
 			Async:       false,
 			TxnId:       env.TxnId,
+			Coordinator: env.Coordinator,
 			Instruction: env.Instruction,
 		}
 		if iw.Instruction == "EXECUTE" {
@@ -151,10 +154,11 @@ func SyncInvoke(env *Env, callee string, input interface{}) (interface{}, string
 		InstanceId:  shortuuid.New(),
 		Input:       input,
 		TxnId:       env.TxnId,
+		Coordinator: env.Coordinator,
 		Instruction: env.Instruction,
 	}
 	pk := aws.JSONValue{"InstanceId": env.InstanceId, "StepNumber": env.StepNumber}
-	ok := LibPut(env.LogTable, pk, aws.JSONValue{"Callee": iw.InstanceId})
+	ok := LibPut(env.LogTable, pk, aws.JSONValue{"Callee": iw.InstanceId, "CalleeName": callee})
 	if !ok {
 		item := LibRead(env.LogTable, pk, []string{"Callee", "RET"})
 		if val, exist := item["Callee"].(string); exist {
@@ -204,10 +208,11 @@ func AssignedSyncInvoke(env *Env, callee string, input interface{}, stepNumber i
 		InstanceId:  shortuuid.New(),
 		Input:       input,
 		TxnId:       env.TxnId,
+		Coordinator: env.Coordinator,
 		Instruction: env.Instruction,
 	}
 	pk := aws.JSONValue{"InstanceId": env.InstanceId, "StepNumber": stepNumber}
-	ok := LibPut(env.LogTable, pk, aws.JSONValue{"Callee": iw.InstanceId})
+	ok := LibPut(env.LogTable, pk, aws.JSONValue{"Callee": iw.InstanceId, "CalleeName": callee})
 	if !ok {
 		item := LibRead(env.LogTable, pk, []string{"Callee", "RET"})
 		if val, exist := item["Callee"].(string); exist {
@@ -272,7 +277,7 @@ func AsyncInvoke(env *Env, callee string, input interface{}) string {
 	}
 
 	pk := aws.JSONValue{"InstanceId": env.InstanceId, "StepNumber": env.StepNumber}
-	ok := LibPut(env.LogTable, pk, aws.JSONValue{"Callee": iw.InstanceId})
+	ok := LibPut(env.LogTable, pk, aws.JSONValue{"Callee": iw.InstanceId, "CalleeName": callee})
 	if !ok {
 		item := LibRead(env.LogTable, pk, []string{"Callee", "RET"})
 		if val, exist := item["Callee"].(string); exist {
@@ -288,7 +293,8 @@ func AsyncInvoke(env *Env, callee string, input interface{}) string {
 	}
 
 	ok = LibPut(fmt.Sprintf("%s-collector", callee), aws.JSONValue{"InstanceId": iw.InstanceId},
-		aws.JSONValue{"DONE": false, "ASYNC": true, "INPUT": iw.Input, "ST": time.Now().Unix()})
+		aws.JSONValue{"DONE": false, "ASYNC": true, "INPUT": iw.Input, "ST": time.Now().Unix(),
+			writesTracked: true})
 
 	if !ok {
 		env.StepNumber += 1
@@ -311,6 +317,32 @@ func AsyncInvoke(env *Env, callee string, input interface{}) string {
 	return iw.InstanceId
 }
 
+// TPLPrepare returns the vote of env and its callees on the transaction.
+// env votes NO if the transaction is already aborted or env has rolled back
+// its part of it. Every callee is asked even after a NO, so that a replay
+// takes the same steps.
+func TPLPrepare(env *Env) bool {
+	vote := getTxnDecision(env) != "ABORT"
+	item := EOSRead(env, env.LocalTable, env.TxnId, []string{"CALLEES"})
+	var callees []string
+	if v, ok := item["CALLEES"]; ok {
+		CHECK(mapstructure.Decode(v, &callees))
+	} else {
+		vote = false
+	}
+	for _, callee := range callees {
+		if callee == " " {
+			continue
+		}
+		output, _ := SyncInvoke(env, callee, aws.JSONValue{})
+		if yes, ok := output.(bool); !ok || !yes {
+			log.Printf("[WARN] Callee %s votes NO on transaction %s", callee, env.TxnId)
+			vote = false
+		}
+	}
+	return vote
+}
+
 func TPLCommit(env *Env) {
 	item := EOSRead(env, env.LocalTable, env.TxnId, []string{})
 	var callees []string
@@ -341,7 +373,8 @@ func TPLCommit(env *Env) {
 }
 
 func TPLAbort(env *Env) {
-	item := EOSRead(env, env.LocalTable, env.TxnId, []string{"CALLEES"})
+	// The locked keys are the other attributes of the item
+	item := EOSRead(env, env.LocalTable, env.TxnId, []string{})
 	var callees []string
 	for k, v := range item {
 		if k == "CALLEES" {
@@ -370,7 +403,8 @@ func wrapperInternal(f func(*Env) interface{}, iw *InputWrapper, env *Env) (Outp
 	if TYPE != "BASELINE" {
 		if iw.Async == false || iw.CallerName == "" {
 			LibPut(env.IntentTable, aws.JSONValue{"InstanceId": env.InstanceId},
-				aws.JSONValue{"DONE": false, "ASYNC": iw.Async, "INPUT": iw.Input, "ST": time.Now().Unix()})
+				aws.JSONValue{"DONE": false, "ASYNC": iw.Async, "INPUT": iw.Input, "ST": time.Now().Unix(),
+					writesTracked: true})
 		} else {
 			LibWrite(env.IntentTable, aws.JSONValue{"InstanceId": env.InstanceId},
 				map[expression.NameBuilder]expression.OperandBuilder{
@@ -392,11 +426,18 @@ func wrapperInternal(f func(*Env) interface{}, iw *InputWrapper, env *Env) (Outp
 	}
 
 	var output interface{}
-	if env.Instruction == "COMMIT" {
-		TPLCommit(env)
-		output = 0
-	} else if env.Instruction == "ABORT" {
-		TPLAbort(env)
+	if env.Instruction == "PREPARE" {
+		output = TPLPrepare(env)
+	} else if env.Instruction == "COMMIT" || env.Instruction == "ABORT" {
+		// The decision record wins over the instruction
+		if decision := getTxnDecision(env); decision != "" {
+			env.Instruction = decision
+		}
+		if env.Instruction == "COMMIT" {
+			TPLCommit(env)
+		} else {
+			TPLAbort(env)
+		}
 		output = 0
 	} else if env.Instruction == "EXECUTE" {
 		EOSWrite(env, env.LocalTable, env.TxnId, map[expression.NameBuilder]expression.OperandBuilder{
@@ -419,6 +460,7 @@ func wrapperInternal(f func(*Env) interface{}, iw *InputWrapper, env *Env) (Outp
 			map[expression.NameBuilder]expression.OperandBuilder{
 				expression.Name("DONE"): expression.Value(true),
 				expression.Name("TS"):   expression.Value(time.Now().Unix()),
+				expression.Name("GCQ"):  expression.Value(doneQueue(env.InstanceId)),
 				//expression.Name("RET"):  expression.Value(output),
 			})
 	}
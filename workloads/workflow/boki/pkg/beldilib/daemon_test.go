@@ -0,0 +1,122 @@
+package beldilib
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/eniac/Beldi/pkg/localfaas"
+)
+
+// newWriterEnv runs writer, which writes its instance id into key k
+func newWriterEnv(t *testing.T) *localfaas.Environment {
+	return newTestEnv(t, map[string]func(env *Env) interface{}{
+		"writer": func(env *Env) interface{} {
+			EOSWrite(env, "writer", "k", map[expression.NameBuilder]expression.OperandBuilder{
+				expression.Name("V"): expression.Value(env.InstanceId),
+			})
+			return 0
+		},
+	})
+}
+
+// freeAddr returns a local address nothing listens on
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestRateLimiterSpacesOps(t *testing.T) {
+	if newRateLimiter(0) != nil {
+		t.Fatalf("Expected no limit for 0 ops per second")
+	}
+	l := newRateLimiter(100)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		l.wait()
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("6 ops at 100 per second took %s", elapsed)
+	}
+}
+
+func TestGCDaemonReportsStatus(t *testing.T) {
+	collectNow(t)
+	fe := newWriterEnv(t)
+	invoke(t, fe, "writer", "a", nil)
+
+	addr := freeAddr(t)
+	d := NewGCDaemon(GCConfig{
+		Services:   []string{"writer"},
+		Interval:   time.Hour,
+		OpsPerSec:  1000,
+		StatusAddr: addr,
+	})
+	d.Start()
+	var stats map[string]GCStats
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if res, err := http.Get("http://" + addr + "/status"); err == nil {
+			err = json.NewDecoder(res.Body).Decode(&stats)
+			res.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats["writer"].Rounds > 0 {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("No round reported, have %+v", stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Stop interrupts the pause between rounds
+	stopped := make(chan struct{})
+	go func() {
+		d.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Stop did not return")
+	}
+
+	s := stats["writer"]
+	if s.Rounds != 1 || s.IntentsCleared != 1 || s.LogsRemoved != 1 || s.LastRound == 0 {
+		t.Fatalf("Expected one round clearing a, have %+v", s)
+	}
+}
+
+func TestGCStopsBetweenPhases(t *testing.T) {
+	collectNow(t)
+	fe := newWriterEnv(t)
+	invoke(t, fe, "writer", "a", nil)
+
+	stop := make(chan struct{})
+	close(stop)
+	if newGCRun(&GCStats{}, 0, nil, stop).round("writer", false) {
+		t.Fatalf("Expected the stopped round to end early")
+	}
+	item := LibRead("writer-collector", aws.JSONValue{"InstanceId": "a"}, []string{"GCPHASE"})
+	if item["GCPHASE"] != float64(1) {
+		t.Fatalf("Expected the round to stop after phase 1, have %v", item)
+	}
+
+	// The next round resumes with phase 2
+	stats := &GCStats{}
+	if !newGCRun(stats, 0, nil, nil).round("writer", false) {
+		t.Fatalf("Expected the round to finish")
+	}
+	if stats.ReadLogsCleared != 0 || stats.LogsRemoved != 1 || stats.IntentsCleared != 1 {
+		t.Fatalf("Expected phases 2 and 3 only, have %+v", stats.snapshot())
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"log"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/mitchellh/mapstructure"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -16,13 +15,14 @@ import (
 
 /**
 The structure of a row looks like
-| K | ROWHASH | V | LOGS | LOGSIZE | GCSIZE | NEXTROW
+| K | ROWHASH | V | LOGS | LOGSIZE | GCSIZE | NEXTROW | TAIL
 K and ROWHASH together act as Primary Key
 K and V are the columns that developers/users operate on
+TAIL is a hint to the last row, kept on HEAD only
 All others are invisible to users
 */
 
-var RESERVED = []string{"K", "ROWHASH", "LOGS", "LOGSIZE", "GCSIZE", "NEXTROW"}
+var RESERVED = []string{"K", "ROWHASH", "LOGS", "LOGSIZE", "GCSIZE", "NEXTROW", "TAIL"}
 
 func LibRead(tablename string, key aws.JSONValue, projection []string) aws.JSONValue {
 	Key, err := dynamodbattribute.MarshalMap(key)
@@ -140,67 +140,15 @@ func LibCondWrite(tablename string, key string, update map[expression.NameBuilde
 
 func LibScanWithLast(tablename string, projection []string, filter expression.ConditionBuilder,
 	last map[string]*dynamodb.AttributeValue) []aws.JSONValue {
-	var res *dynamodb.ScanOutput
-	var err error
-	if last == nil {
-		if len(projection) == 0 {
-			expr, err := expression.NewBuilder().WithFilter(filter).Build()
-			CHECK(err)
-			res, err = DBClient.Scan(&dynamodb.ScanInput{
-				TableName:                 aws.String(tablename),
-				ExpressionAttributeNames:  expr.Names(),
-				ExpressionAttributeValues: expr.Values(),
-				FilterExpression:          expr.Filter(),
-				ConsistentRead:            aws.Bool(true),
-			})
-		} else {
-			expr, err := expression.NewBuilder().WithFilter(filter).WithProjection(BuildProjection(projection)).Build()
-			CHECK(err)
-			res, err = DBClient.Scan(&dynamodb.ScanInput{
-				TableName:                 aws.String(tablename),
-				ExpressionAttributeNames:  expr.Names(),
-				ExpressionAttributeValues: expr.Values(),
-				FilterExpression:          expr.Filter(),
-				ProjectionExpression:      expr.Projection(),
-				ConsistentRead:            aws.Bool(true),
-			})
-		}
-	} else {
-		if len(projection) == 0 {
-			expr, err := expression.NewBuilder().WithFilter(filter).Build()
-			CHECK(err)
-			res, err = DBClient.Scan(&dynamodb.ScanInput{
-				TableName:                 aws.String(tablename),
-				ExpressionAttributeNames:  expr.Names(),
-				ExpressionAttributeValues: expr.Values(),
-				FilterExpression:          expr.Filter(),
-				ConsistentRead:            aws.Bool(true),
-				ExclusiveStartKey:         last,
-			})
-		} else {
-			expr, err := expression.NewBuilder().WithFilter(filter).WithProjection(BuildProjection(projection)).Build()
-			CHECK(err)
-			res, err = DBClient.Scan(&dynamodb.ScanInput{
-				TableName:                 aws.String(tablename),
-				ExpressionAttributeNames:  expr.Names(),
-				ExpressionAttributeValues: expr.Values(),
-				FilterExpression:          expr.Filter(),
-				ProjectionExpression:      expr.Projection(),
-				ConsistentRead:            aws.Bool(true),
-				ExclusiveStartKey:         last,
-			})
+	var items []aws.JSONValue
+	for {
+		page, next := LibScanPage(tablename, projection, filter, last, 0)
+		items = append(items, page...)
+		if next == nil {
+			return items
 		}
+		last = next
 	}
-	CHECK(err)
-	var item []aws.JSONValue
-	err = dynamodbattribute.UnmarshalListOfMaps(res.Items, &item)
-	CHECK(err)
-	if res.LastEvaluatedKey == nil || len(res.LastEvaluatedKey) == 0 {
-		return item
-	}
-	fmt.Println("DEBUG: Exceed Scan limit")
-	item = append(item, LibScanWithLast(tablename, projection, filter, res.LastEvaluatedKey)...)
-	return item
 }
 
 func LibScan(tablename string, projection []string, filter expression.ConditionBuilder) []aws.JSONValue {
@@ -274,7 +222,7 @@ func EOSReadWithRow(env *Env, tablename string, key string, projection []string,
 
 func EOSRead(env *Env, tablename string, key string, projection []string) aws.JSONValue {
 	// ReadLog is not in DAAL, Need Optimization Here
-	last := LastRow(tablename, key)
+	last := TailRow(tablename, key)
 	if last == "" {
 		last = "HEAD"
 	}
@@ -292,17 +240,10 @@ func LibReadLatest(tablename string, key string, projection []string, row string
 
 func EOSScan(env *Env, tablename string, projection []string) []aws.JSONValue {
 	var res []aws.JSONValue
-	keys := LibScan(tablename, []string{"K"}, expression.Name("ROWHASH").Equal(expression.Value("HEAD")))
-	for _, key := range keys {
-		res = append(res, LibReadLatest(tablename, key["K"].(string), projection, "HEAD"))
+	it := NewScanIterator(env, tablename, projection)
+	for !it.Done() {
+		res = append(res, it.Next()...)
 	}
-	logKey := aws.JSONValue{"InstanceId": env.InstanceId, "StepNumber": env.StepNumber}
-	env.StepNumber += 1
-	if LibPut(env.LogTable, logKey, aws.JSONValue{"VS": res}) {
-		return res
-	}
-	item := LibRead(env.LogTable, logKey, []string{"VS"})
-	CHECK(mapstructure.Decode(item["VS"], &res))
 	return res
 }
 
@@ -357,6 +298,8 @@ func InsertOrGetNewRow(tablename string, key string, row string) string {
 	})
 	if err == nil {
 		//fmt.Printf("Swap row success!!!!\n")
+		cacheTail(tablename, key, newRowHash)
+		writeTailHint(tablename, key, newRowHash)
 		return newRowHash
 	} else {
 		//fmt.Printf("Swap row failure!!!!\n")
@@ -369,6 +312,7 @@ func InsertOrGetNewRow(tablename string, key string, row string) string {
 		res := LibRead(tablename, pk, []string{"NEXTROW"})
 		if nextRow, exists := res["NEXTROW"].(string); exists {
 			//fmt.Printf("Fetch a nextrow\n")
+			cacheTail(tablename, key, nextRow)
 			return nextRow
 		} else {
 			panic("never reach here")
@@ -410,7 +354,7 @@ func EOSWriteWithRow(env *Env, tablename string, key string,
 	cidPath := fmt.Sprintf("LOGS.%s", cid)
 
 	cond1 := expression.AttributeNotExists(expression.Name(cidPath))           // CID not in logs
-	cond2 := expression.Name("LOGSIZE").LessThan(expression.Value(GLOGSIZE())) // |logs| < N
+	cond2 := expression.Name("LOGSIZE").LessThan(expression.Value(GetDAALConfig(tablename).LogSize)) // |logs| < N
 
 	// CID not in logs /\ |logs| < N /\ not exist NextRow
 	updateBuilder := expression.UpdateBuilder{}
@@ -433,6 +377,7 @@ func EOSWriteWithRow(env *Env, tablename string, key string,
 		UpdateExpression:          expr.Update(),
 	})
 	if err == nil {
+		cacheTail(tablename, key, row)
 		env.StepNumber += 1
 		return
 	}
@@ -577,10 +522,16 @@ func QueryCondCheck(env *Env, tablename string, key string, idx []string) (bool,
 
 func EOSWrite(env *Env, tablename string, key string,
 	update map[expression.NameBuilder]expression.OperandBuilder) {
-	done, _, last := QuickCheckReturnLast(env, tablename, key, false)
-	if done {
-		env.StepNumber += 1
-		return
+	var last string
+	if registerWrite(env, tablename, key) {
+		last = TailRow(tablename, key)
+	} else {
+		done, _, row := QuickCheckReturnLast(env, tablename, key, false)
+		if done {
+			env.StepNumber += 1
+			return
+		}
+		last = row
 	}
 	if last == "" {
 		InsertHead(tablename, key)
@@ -605,7 +556,7 @@ func EOSCondWriteWithRow(env *Env, tablename string, key string,
 	cidPath := fmt.Sprintf("LOGS.%s", cid)
 
 	cond1 := expression.AttributeNotExists(expression.Name(cidPath))           // CID not in logs
-	cond2 := expression.Name("LOGSIZE").LessThan(expression.Value(GLOGSIZE())) // |logs| < N
+	cond2 := expression.Name("LOGSIZE").LessThan(expression.Value(GetDAALConfig(tablename).LogSize)) // |logs| < N
 
 	// CID not in logs /\ |logs| < N /\ not exist NextRow
 	updateBuilder := expression.UpdateBuilder{}
@@ -633,6 +584,7 @@ func EOSCondWriteWithRow(env *Env, tablename string, key string,
 		UpdateExpression:          expr.Update(),
 	})
 	if err == nil {
+		cacheTail(tablename, key, row)
 		env.StepNumber += 1
 		return true
 	}
@@ -651,6 +603,7 @@ func EOSCondWriteWithRow(env *Env, tablename string, key string,
 		UpdateExpression:          expr.Update(),
 	})
 	if err == nil {
+		cacheTail(tablename, key, row)
 		env.StepNumber += 1
 		return false
 	}
@@ -675,10 +628,16 @@ func EOSCondWriteWithRow(env *Env, tablename string, key string,
 func EOSCondWrite(env *Env, tablename string, key string,
 	update map[expression.NameBuilder]expression.OperandBuilder,
 	cond expression.ConditionBuilder) bool {
-	done, res, last := QuickCheckReturnLast(env, tablename, key, true)
-	if done {
-		env.StepNumber += 1
-		return res
+	var last string
+	if registerWrite(env, tablename, key) {
+		last = TailRow(tablename, key)
+	} else {
+		done, res, row := QuickCheckReturnLast(env, tablename, key, true)
+		if done {
+			env.StepNumber += 1
+			return res
+		}
+		last = row
 	}
 	if last == "" {
 		InsertHead(tablename, key)
@@ -730,18 +689,13 @@ func Scan(env *Env, tablename string) interface{} {
 		return res
 	}
 	var res []interface{}
-	items := LibScan(tablename, []string{"V"},
-		expression.AttributeNotExists(expression.Name("NEXTROW")))
-	for _, item := range items {
-		res = append(res, item["V"])
-	}
-	logKey := aws.JSONValue{"InstanceId": env.InstanceId, "StepNumber": env.StepNumber}
-	env.StepNumber += 1
-	if LibPut(env.LogTable, logKey, aws.JSONValue{"VS": res}) {
-		return res
+	it := NewScanIterator(env, tablename, []string{"V"})
+	for !it.Done() {
+		for _, item := range it.Next() {
+			res = append(res, item["V"])
+		}
 	}
-	item := LibRead(env.LogTable, logKey, []string{"VS"})
-	return item["VS"]
+	return res
 }
 
 func TRead(env *Env, tablename string, key string) aws.JSONValue {
@@ -903,6 +857,36 @@ func LibQuery(tablename string, cond expression.KeyConditionBuilder, projection
 	return items
 }
 
+// LibQueryIndex queries a global secondary index, which only supports
+// eventually consistent reads
+func LibQueryIndex(tablename string, index string, cond expression.KeyConditionBuilder,
+	projection []string) []aws.JSONValue {
+	expr, err := expression.NewBuilder().WithProjection(BuildProjection(projection)).WithKeyCondition(cond).Build()
+	CHECK(err)
+	var items []aws.JSONValue
+	var last map[string]*dynamodb.AttributeValue
+	for {
+		res, err := DBClient.Query(&dynamodb.QueryInput{
+			TableName:                 aws.String(tablename),
+			IndexName:                 aws.String(index),
+			KeyConditionExpression:    expr.KeyCondition(),
+			ProjectionExpression:      expr.Projection(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			ExclusiveStartKey:         last,
+		})
+		CHECK(err)
+		var page []aws.JSONValue
+		err = dynamodbattribute.UnmarshalListOfMaps(res.Items, &page)
+		CHECK(err)
+		items = append(items, page...)
+		if len(res.LastEvaluatedKey) == 0 {
+			return items
+		}
+		last = res.LastEvaluatedKey
+	}
+}
+
 func LastRow(tablename string, key string) string {
 	projection := []string{"ROWHASH", "NEXTROW"}
 	cond := expression.Key("K").Equal(expression.Value(key))
@@ -944,42 +928,12 @@ func LastRow(tablename string, key string) string {
 
 func TQuery(env *Env, tablename string, key string) interface{} {
 	projection := []string{"ROWHASH", "V", "NEXTROW"}
-	cond := expression.Key("K").Equal(expression.Value(key))
-	expr, err := expression.NewBuilder().WithProjection(BuildProjection(projection)).WithKeyCondition(cond).Build()
-	CHECK(err)
-	res, err := DBClient.Query(&dynamodb.QueryInput{
-		TableName:                 aws.String(tablename),
-		KeyConditionExpression:    expr.KeyCondition(),
-		ProjectionExpression:      expr.Projection(),
-		ExpressionAttributeNames:  expr.Names(),
-		ExpressionAttributeValues: expr.Values(),
-		//ConsistentRead:            aws.Bool(true),
-	})
-	CHECK(err)
-	var items []aws.JSONValue
-	err = dynamodbattribute.UnmarshalListOfMaps(res.Items, &items)
-	CHECK(err)
-	idx := make(map[string]aws.JSONValue)
-	for _, item := range items {
-		row := item["ROWHASH"].(string)
-		tmp := aws.JSONValue{}
-		if next, ok := item["NEXTROW"]; ok {
-			tmp["NEXTROW"] = next
-		}
-		if v, ok := item["V"]; ok {
-			tmp["V"] = v
-		}
-		idx[row] = tmp
-	}
-	cur := "HEAD"
 	var v map[string]interface{} = nil
-	for {
-		v = idx[cur]
-		if next, ok := v["NEXTROW"]; ok {
-			cur = next.(string)
-			continue
-		} else {
-			break
+	if last := TailRow(tablename, key); last != "" {
+		item := LibRead(tablename, aws.JSONValue{"K": key, "ROWHASH": last}, []string{"V"})
+		v = aws.JSONValue{}
+		if val, ok := item["V"]; ok {
+			v["V"] = val
 		}
 	}
 	logKey := aws.JSONValue{"InstanceId": env.InstanceId, "StepNumber": env.StepNumber}
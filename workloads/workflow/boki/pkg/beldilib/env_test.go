@@ -0,0 +1,48 @@
+package beldilib
+
+import (
+	"context"
+	"testing"
+
+	"cs.utexas.edu/zjia/faas-memdb"
+	"github.com/eniac/Beldi/pkg/localfaas"
+)
+
+// newTestEnv returns a local environment running fns, each with its
+// tables, on a fresh memdb and with no cached tails
+func newTestEnv(t *testing.T, fns map[string]func(env *Env) interface{}) *localfaas.Environment {
+	dbClient := DBClient
+	DBClient = memdb.New()
+	tailCacheMutex.Lock()
+	tailCache = map[string]string{}
+	tailCacheMutex.Unlock()
+	t.Cleanup(func() {
+		DBClient = dbClient
+	})
+	fe := localfaas.NewEnvironment()
+	for name, fn := range fns {
+		CreateLambdaTables(name)
+		CreateMainTable(name + "-local")
+		fe.Register(name, CreateFuncHandlerFactory(fn))
+	}
+	return fe
+}
+
+// invoke runs instanceId of funcName and waits for everything it started
+func invoke(t *testing.T, fe *localfaas.Environment, funcName string, instanceId string, input interface{}) OutputWrapper {
+	t.Helper()
+	iw := InputWrapper{
+		InstanceId: instanceId,
+		Input:      input,
+	}
+	res, err := fe.InvokeFunc(context.Background(), funcName, iw.Serialize())
+	if err != nil {
+		t.Fatalf("Invoke %s of %s: %v", instanceId, funcName, err)
+	}
+	for _, err := range fe.Wait() {
+		t.Fatalf("Invoke %s of %s: %v", instanceId, funcName, err)
+	}
+	var ow OutputWrapper
+	ow.Deserialize(res)
+	return ow
+}
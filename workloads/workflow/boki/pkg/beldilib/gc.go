@@ -2,31 +2,93 @@ package beldilib
 
 import (
 	"fmt"
+	"hash/fnv"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var DEBUG = false
 
-func ClearRow(tablename string, key string, prevRow string, currentRow string, ts int64) {
+// gcRun bounds the goroutines and DB operations of a GC round and counts
+// its work. A round stops between phases once stop is closed.
+type gcRun struct {
+	stats   *GCStats
+	sem     chan struct{}
+	limiter *rateLimiter
+	stop    <-chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newGCRun(stats *GCStats, concurrency int, limiter *rateLimiter, stop <-chan struct{}) *gcRun {
+	if concurrency <= 0 {
+		concurrency = 64
+	}
+	return &gcRun{
+		stats:   stats,
+		sem:     make(chan struct{}, concurrency),
+		limiter: limiter,
+		stop:    stop,
+	}
+}
+
+func (r *gcRun) spawn(f func()) {
+	r.wg.Add(1)
+	r.sem <- struct{}{}
+	go func() {
+		defer func() {
+			<-r.sem
+			r.wg.Done()
+		}()
+		f()
+	}()
+}
+
+func (r *gcRun) wait() {
+	r.wg.Wait()
+}
+
+// op waits for the rate limit before a DB operation
+func (r *gcRun) op() {
+	r.limiter.wait()
+}
+
+func (r *gcRun) stopped() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *gcRun) ClearRow(tablename string, key string, prevRow string, currentRow string, ts int64) {
 	currentPk := aws.JSONValue{"K": key, "ROWHASH": currentRow}
 	prevPk := aws.JSONValue{"K": key, "ROWHASH": prevRow}
-	res := LibRead(tablename, currentPk, []string{"NEXTROW", "GCSIZE"})
+	r.op()
+	res := LibRead(tablename, currentPk, []string{"NEXTROW", "GCSIZE", "LOGSIZE"})
+	atomic.AddInt64(&r.stats.RowsScanned, 1)
 	if nextRow, exists := res["NEXTROW"].(string); exists {
-		if int(res["GCSIZE"].(float64)) == GLOGSIZE() {
+		// a row keeps the LOGSIZE it filled up with, whatever the config is now
+		if res["GCSIZE"].(float64) >= res["LOGSIZE"].(float64) {
+			r.op()
 			LibWrite(tablename, prevPk, map[expression.NameBuilder]expression.OperandBuilder{
 				expression.Name("NEXTROW"): expression.Value(nextRow),
 			})
+			r.op()
 			LibWrite(tablename, currentPk, map[expression.NameBuilder]expression.OperandBuilder{
-				expression.Name("TS"): expression.Value(ts),
+				expression.Name("TS"):  expression.Value(ts),
+				expression.Name("GCQ"): expression.Value("DANGLE"),
 			})
-			ClearRow(tablename, key, prevRow, nextRow, ts)
+			atomic.AddInt64(&r.stats.RowsMarked, 1)
+			r.ClearRow(tablename, key, prevRow, nextRow, ts)
 		} else {
-			ClearRow(tablename, key, currentRow, nextRow, ts)
+			r.ClearRow(tablename, key, currentRow, nextRow, ts)
 		}
 	} else {
 		// never remove the last row
@@ -34,40 +96,128 @@ func ClearRow(tablename string, key string, prevRow string, currentRow string, t
 	}
 }
 
-func ScanIntent(lambdaId string) []aws.JSONValue {
-	filter := expression.Name("DONE").Equal(expression.Value(true)).
-		And(expression.AttributeExists(expression.Name("TS")).
-			And(expression.Name("TS").LessThan(expression.Value(time.Now().Unix() - T))))
-	intentTable := fmt.Sprintf("%s-collector", lambdaId)
-	return LibScan(intentTable, []string{"InstanceId"}, filter)
+// GC is driven by the sparse index gcidx of the collector and data tables:
+// finished intents get GCQ=DONE#<shard> and rows unlinked by ClearRow
+// GCQ=DANGLE, so a round reads the garbage only. The price is paid by the
+// workload: an EOSWrite costs one more UpdateItem, registerWrite. Tables
+// and instances from before gcidx are collected after BackfillGC.
+const gcIndex = "gcidx"
+
+// Finished intents are spread over gcShards values of GCQ by the hash of
+// their instance id. Each value is an index partition, whose write rate
+// bounds the rate intents can finish at. A round queries every shard, and
+// GCQ=DONE, which intents finished before the shards hold.
+const gcShards = 8
+
+func doneQueue(instanceId string) string {
+	h := fnv.New32a()
+	h.Write([]byte(instanceId))
+	return fmt.Sprintf("DONE#%d", h.Sum32()%gcShards)
+}
+
+func doneQueues() []string {
+	queues := []string{"DONE"}
+	for shard := 0; shard < gcShards; shard++ {
+		queues = append(queues, fmt.Sprintf("DONE#%d", shard))
+	}
+	return queues
+}
+
+// An intent records the keys its instance wrote in WRITES, as
+// table/key, since their rows hold the write logs of the instance
+const writeSep = "/"
+
+type stringSet []string
+
+func (s stringSet) MarshalDynamoDBAttributeValue(av *dynamodb.AttributeValue) error {
+	for _, v := range s {
+		av.SS = append(av.SS, aws.String(v))
+	}
+	return nil
+}
+
+// Intents created since WRITES exists are marked with writesTracked, for
+// older ones WRITES can miss keys written before
+const writesTracked = "WTRACKED"
+
+// registerWrite returns true if the instance never wrote key before, so
+// no row of key can hold a log of it. That costs an UpdateItem on the
+// intent per EOSWrite, which saves the chain query for first writes.
+func registerWrite(env *Env, tablename string, key string) bool {
+	Key, err := dynamodbattribute.MarshalMap(aws.JSONValue{"InstanceId": env.InstanceId})
+	CHECK(err)
+	write := tablename + writeSep + key
+	update := expression.Add(expression.Name("WRITES"), expression.Value(stringSet{write})).
+		Set(expression.Name(writesTracked),
+			expression.Name(writesTracked).IfNotExists(expression.Value(false)))
+	expr, err := expression.NewBuilder().WithUpdate(update).Build()
+	CHECK(err)
+	res, err := DBClient.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                 aws.String(env.IntentTable),
+		Key:                       Key,
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+		ReturnValues:              aws.String("UPDATED_OLD"),
+	})
+	CHECK(err)
+	if tracked, exists := res.Attributes[writesTracked]; !exists || !aws.BoolValue(tracked.BOOL) {
+		return false
+	}
+	if old, exists := res.Attributes["WRITES"]; exists {
+		for _, v := range old.SS {
+			if aws.StringValue(v) == write {
+				return false
+			}
+		}
+	}
+	return true
 }
 
-func QueryHeads(lambdaId string) []aws.JSONValue {
-	filter := expression.Name("ROWHASH").Equal(expression.Value("HEAD"))
-	return LibScan(lambdaId, []string{"K", "NEXTROW"}, filter)
+func QueryDone(lambdaId string) []aws.JSONValue {
+	return newGCRun(&GCStats{}, 0, nil, nil).queryDone(lambdaId)
 }
 
-func ScanDangle(lambdaId string) []aws.JSONValue {
-	filter := expression.AttributeExists(expression.Name("TS")).
-		And(expression.Name("TS").LessThan(expression.Value(time.Now().Unix() - T)))
-	return LibScan(lambdaId, []string{"K", "ROWHASH"}, filter)
+// queryDone returns the intents of all shards that finished more than
+// IntentTimeout ago
+func (r *gcRun) queryDone(lambdaId string) []aws.JSONValue {
+	deadline := time.Now().Unix() - GetDAALConfig(lambdaId).IntentTimeout
+	intentTable := fmt.Sprintf("%s-collector", lambdaId)
+	items := make([]aws.JSONValue, 0)
+	for _, queue := range doneQueues() {
+		cond := expression.Key("GCQ").Equal(expression.Value(queue)).
+			And(expression.Key("TS").LessThan(expression.Value(deadline)))
+		r.op()
+		items = append(items, LibQueryIndex(intentTable, gcIndex, cond, []string{"InstanceId", "TS"})...)
+		atomic.AddInt64(&r.stats.IndexQueries, 1)
+	}
+	return items
+}
+
+func QueryDangle(lambdaId string) []aws.JSONValue {
+	timeout := GetDAALConfig(lambdaId).DanglingTimeout
+	cond := expression.Key("GCQ").Equal(expression.Value("DANGLE")).
+		And(expression.Key("TS").LessThan(expression.Value(time.Now().Unix() - timeout)))
+	return LibQueryIndex(lambdaId, gcIndex, cond, []string{"K", "ROWHASH"})
 }
 
-func ClearReadLog(wg *sync.WaitGroup, lambdaId string, instanceId string) {
+func (r *gcRun) ClearReadLog(lambdaId string, instanceId string) {
 	projection := []string{"InstanceId", "StepNumber"}
 	cond := expression.Key("InstanceId").Equal(expression.Value(instanceId))
 	logTable := fmt.Sprintf("%s-log", lambdaId)
+	r.op()
 	items := LibQuery(logTable, cond, projection)
 	for _, item := range items {
-		wg.Add(1)
-		go func(item_ aws.JSONValue) {
-			defer wg.Done()
+		item_ := item
+		r.spawn(func() {
+			r.op()
 			LibDelete(logTable, item_)
-		}(item)
+			atomic.AddInt64(&r.stats.ReadLogsCleared, 1)
+		})
 	}
 }
 
-func ClearRowDAAL(row aws.JSONValue, idx map[string]bool, lambdaId string) {
+func (r *gcRun) ClearRowDAAL(row aws.JSONValue, idx map[string]bool, lambdaId string) {
 	_, Key := GeneratePK(row["K"].(string), row["ROWHASH"].(string))
 	update := expression.UpdateBuilder{}
 	count := 0
@@ -77,31 +227,32 @@ func ClearRowDAAL(row aws.JSONValue, idx map[string]bool, lambdaId string) {
 	}
 	for cid, _ := range logs {
 		cidPath := fmt.Sprintf("LOGS.%s", cid)
-		for instanceId, _ := range idx {
-			if strings.HasPrefix(cid, instanceId) {
-				update = update.Remove(expression.Name(cidPath))
-				count += 1
-				if count >= 250 {
-					update = update.
-						Set(expression.Name("GCSIZE"), expression.Name("GCSIZE").Plus(expression.Value(count)))
-					expr, err := expression.NewBuilder().WithUpdate(update).
-						WithCondition(expression.AttributeExists(expression.Name(cidPath))).Build()
-					CHECK(err)
-					_, err = DBClient.UpdateItem(&dynamodb.UpdateItemInput{
-						TableName:                 aws.String(lambdaId),
-						Key:                       Key,
-						ConditionExpression:       expr.Condition(),
-						ExpressionAttributeNames:  expr.Names(),
-						ExpressionAttributeValues: expr.Values(),
-						UpdateExpression:          expr.Update(),
-					})
-					if err != nil {
-						AssertConditionFailure(err)
-					}
-					update = expression.UpdateBuilder{}
-					count = 0
+		// cid is <instanceId>-<step>, idx holds <instanceId>-
+		if sep := strings.LastIndex(cid, "-"); sep >= 0 && idx[cid[:sep+1]] {
+			update = update.Remove(expression.Name(cidPath))
+			count += 1
+			if count >= 250 {
+				update = update.
+					Set(expression.Name("GCSIZE"), expression.Name("GCSIZE").Plus(expression.Value(count)))
+				expr, err := expression.NewBuilder().WithUpdate(update).
+					WithCondition(expression.AttributeExists(expression.Name(cidPath))).Build()
+				CHECK(err)
+				r.op()
+				_, err = DBClient.UpdateItem(&dynamodb.UpdateItemInput{
+					TableName:                 aws.String(lambdaId),
+					Key:                       Key,
+					ConditionExpression:       expr.Condition(),
+					ExpressionAttributeNames:  expr.Names(),
+					ExpressionAttributeValues: expr.Values(),
+					UpdateExpression:          expr.Update(),
+				})
+				if err != nil {
+					AssertConditionFailure(err)
+				} else {
+					atomic.AddInt64(&r.stats.LogsRemoved, int64(count))
 				}
-				break
+				update = expression.UpdateBuilder{}
+				count = 0
 			}
 		}
 	}
@@ -110,6 +261,7 @@ func ClearRowDAAL(row aws.JSONValue, idx map[string]bool, lambdaId string) {
 			Set(expression.Name("GCSIZE"), expression.Name("GCSIZE").Plus(expression.Value(count)))
 		expr, err := expression.NewBuilder().WithUpdate(update).Build()
 		CHECK(err)
+		r.op()
 		_, err = DBClient.UpdateItem(&dynamodb.UpdateItemInput{
 			TableName:                 aws.String(lambdaId),
 			Key:                       Key,
@@ -118,132 +270,196 @@ func ClearRowDAAL(row aws.JSONValue, idx map[string]bool, lambdaId string) {
 			UpdateExpression:          expr.Update(),
 		})
 		CHECK(err)
+		atomic.AddInt64(&r.stats.LogsRemoved, int64(count))
 	}
 }
 
-func ClearDAAL(wg *sync.WaitGroup, lambdaId string, idx map[string]bool) {
-	filter := expression.Value(true).Equal(expression.Value(true))
-	rows := LibScan(lambdaId, []string{"K", "ROWHASH", "LOGS", "GCSIZE"}, filter)
-	for _, row := range rows {
-		wg.Add(1)
-		go func(row_ aws.JSONValue) {
-			defer wg.Done()
-			ClearRowDAAL(row_, idx, lambdaId)
-		}(row)
+// ClearWrites removes the write logs of the instances in writes, which
+// maps every table/key written to the instances that wrote it. The rows of
+// a key are read once for all of them, then the rows that became empty
+// are unlinked.
+func (r *gcRun) ClearWrites(writes map[string][]string) {
+	ts := time.Now().Unix()
+	for write, ids := range writes {
+		tmp := strings.SplitN(write, writeSep, 2)
+		tablename, key := tmp[0], tmp[1]
+		idx := make(map[string]bool, len(ids))
+		for _, instanceId := range ids {
+			idx[instanceId+"-"] = true
+		}
+		r.spawn(func() {
+			row := "HEAD"
+			for {
+				r.op()
+				item := LibRead(tablename, aws.JSONValue{"K": key, "ROWHASH": row},
+					[]string{"K", "ROWHASH", "LOGS", "NEXTROW"})
+				if len(item) == 0 {
+					break
+				}
+				atomic.AddInt64(&r.stats.RowsScanned, 1)
+				r.ClearRowDAAL(item, idx, tablename)
+				nextRow, exists := item["NEXTROW"].(string)
+				if !exists {
+					break
+				}
+				row = nextRow
+			}
+			r.op()
+			head := LibRead(tablename, aws.JSONValue{"K": key, "ROWHASH": "HEAD"}, []string{"NEXTROW"})
+			if nextRow, exists := head["NEXTROW"].(string); exists {
+				r.ClearRow(tablename, key, "HEAD", nextRow, ts)
+			}
+		})
 	}
 }
 
-func ClearIntent(wg *sync.WaitGroup, lambdaId string, ids []string) {
+func (r *gcRun) ClearIntent(lambdaId string, ids []string) {
 	intentTable := fmt.Sprintf("%s-collector", lambdaId)
 	for _, instanceId := range ids {
-		wg.Add(1)
-		go func(id_ string) {
-			defer wg.Done()
+		id_ := instanceId
+		r.spawn(func() {
+			r.op()
 			LibDelete(intentTable, aws.JSONValue{"InstanceId": id_})
-		}(instanceId)
-	}
-}
-
-func MarkDangling(wg *sync.WaitGroup, lambdaId string) {
-	heads := QueryHeads(lambdaId)
-	ts := time.Now().Unix()
-	for _, head := range heads {
-		wg.Add(1)
-		go func(head_ aws.JSONValue) {
-			defer wg.Done()
-			if nextRow, exists := head_["NEXTROW"].(string); exists {
-				ClearRow(lambdaId, head_["K"].(string), "HEAD", nextRow, ts)
-			}
-		}(head)
+			atomic.AddInt64(&r.stats.IntentsCleared, 1)
+		})
 	}
 }
 
-func ClearDangling(wg *sync.WaitGroup, lambdaId string) {
-	items := ScanDangle(lambdaId)
+func (r *gcRun) ClearDangling(lambdaId string) {
+	r.op()
+	items := QueryDangle(lambdaId)
 	for _, item := range items {
-		wg.Add(1)
-		go func(item_ aws.JSONValue) {
-			defer wg.Done()
+		item_ := item
+		r.spawn(func() {
+			r.op()
 			LibDelete(lambdaId, item_)
-		}(item)
+			atomic.AddInt64(&r.stats.RowsDeleted, 1)
+		})
 	}
 }
 
-func GC(lambdaId string) {
-	var wg sync.WaitGroup
+// checkpoint records in GCPHASE of the intents that phase is done, a GC
+// restarted after a crash skips the phases done
+func (r *gcRun) checkpoint(lambdaId string, ids []string, phase int) {
+	intentTable := fmt.Sprintf("%s-collector", lambdaId)
+	for _, instanceId := range ids {
+		id_ := instanceId
+		r.spawn(func() {
+			r.op()
+			LibWrite(intentTable, aws.JSONValue{"InstanceId": id_}, map[expression.NameBuilder]expression.OperandBuilder{
+				expression.Name("GCPHASE"): expression.Value(phase),
+			})
+		})
+	}
+	r.wait()
+}
 
+// round runs the four phases of GC: read logs, write logs, intents and
+// dangling rows. It returns false if it stopped early.
+func (r *gcRun) round(lambdaId string, static bool) bool {
 	start := time.Now()
-
-	items := ScanIntent(lambdaId)
-
-	var ids []string
-	idx := make(map[string]bool)
+	items := r.queryDone(lambdaId)
+	intentTable := fmt.Sprintf("%s-collector", lambdaId)
+	ids := make([]string, 0, len(items))
+	phases := make(map[string]float64)
+	lag := int64(0)
+	deadline := start.Unix() - GetDAALConfig(lambdaId).IntentTimeout
 	for _, item := range items {
-		var tmp = item["InstanceId"].(string)
-		ids = append(ids, tmp)
-		idx[tmp] = true
+		instanceId := item["InstanceId"].(string)
+		ids = append(ids, instanceId)
+		if ts, ok := item["TS"].(float64); ok && deadline-int64(ts) > lag {
+			lag = deadline - int64(ts)
+		}
+	}
+	atomic.StoreInt64(&r.stats.Lag, lag)
+	if !static {
+		var mu sync.Mutex
+		for _, instanceId := range ids {
+			id_ := instanceId
+			r.spawn(func() {
+				r.op()
+				item := LibRead(intentTable, aws.JSONValue{"InstanceId": id_}, []string{"GCPHASE"})
+				phase, _ := item["GCPHASE"].(float64)
+				mu.Lock()
+				phases[id_] = phase
+				mu.Unlock()
+			})
+		}
+		r.wait()
 	}
 
+	pending := make([]string, 0)
 	for _, instanceId := range ids {
-		wg.Add(1)
-		go func(id string) {
-			defer wg.Done()
-			ClearReadLog(&wg, lambdaId, id)
-		}(instanceId)
+		if phases[instanceId] < 1 {
+			r.ClearReadLog(lambdaId, instanceId)
+			pending = append(pending, instanceId)
+		}
 	}
-
-	ClearDAAL(&wg, lambdaId, idx)
-
-	wg.Wait()
+	r.wait()
 	if DEBUG {
 		fmt.Printf("1: %s\n", time.Since(start))
 	}
+	if static {
+		r.ClearIntent(lambdaId, ids)
+		r.wait()
+		return true
+	}
+	r.checkpoint(lambdaId, pending, 1)
+	if r.stopped() {
+		return false
+	}
 
 	start = time.Now()
-	ClearIntent(&wg, lambdaId, ids)
-	wg.Wait()
+	pending = pending[:0]
+	writes := make(map[string][]string)
+	for _, instanceId := range ids {
+		if phases[instanceId] >= 2 {
+			continue
+		}
+		pending = append(pending, instanceId)
+		r.op()
+		item := LibRead(intentTable, aws.JSONValue{"InstanceId": instanceId}, []string{"WRITES"})
+		// String sets decode to []string
+		keys, _ := item["WRITES"].([]string)
+		for _, write := range keys {
+			writes[write] = append(writes[write], instanceId)
+		}
+	}
+	r.ClearWrites(writes)
+	r.wait()
+	r.checkpoint(lambdaId, pending, 2)
 	if DEBUG {
 		fmt.Printf("2: %s\n", time.Since(start))
 	}
+	if r.stopped() {
+		return false
+	}
 
 	start = time.Now()
-
-	MarkDangling(&wg, lambdaId)
-	wg.Wait()
+	r.ClearIntent(lambdaId, ids)
+	r.wait()
 	if DEBUG {
 		fmt.Printf("3: %s\n", time.Since(start))
 	}
+	if r.stopped() {
+		return false
+	}
 
 	start = time.Now()
-
-	ClearDangling(&wg, lambdaId)
-	wg.Wait()
+	r.ClearDangling(lambdaId)
+	r.wait()
 	if DEBUG {
 		fmt.Printf("4: %s\n", time.Since(start))
 	}
+	return true
 }
 
-func StaticGC(lambdaId string) {
-	var wg sync.WaitGroup
-	items := ScanIntent(lambdaId)
-
-	var ids []string
-	for _, item := range items {
-		var tmp = item["InstanceId"].(string)
-		ids = append(ids, tmp)
-	}
+func GC(lambdaId string) {
+	newGCRun(&GCStats{}, 0, nil, nil).round(lambdaId, false)
+}
 
-	for _, instanceId := range ids {
-		//fmt.Print("Recyling: ")
-		//fmt.Println(instanceId)
-		wg.Add(1)
-		go func(id string) {
-			defer wg.Done()
-			ClearReadLog(&wg, lambdaId, id)
-		}(instanceId)
-	}
-	wg.Wait()
-
-	ClearIntent(&wg, lambdaId, ids)
-	wg.Wait()
+// StaticGC collects the read logs and intents of a lambda without DAAL
+// tables
+func StaticGC(lambdaId string) {
+	newGCRun(&GCStats{}, 0, nil, nil).round(lambdaId, true)
 }
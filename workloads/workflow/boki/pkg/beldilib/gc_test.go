@@ -0,0 +1,71 @@
+package beldilib
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// collectNow makes GC take intents as soon as they finish
+func collectNow(t *testing.T) {
+	timeout := T
+	T = -1
+	t.Cleanup(func() {
+		T = timeout
+	})
+}
+
+func TestGCRoundClearsSharedKeysOnce(t *testing.T) {
+	collectNow(t)
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"writer": func(env *Env) interface{} {
+			EOSWrite(env, "writer", "k", map[expression.NameBuilder]expression.OperandBuilder{
+				expression.Name("V"): expression.Value(env.InstanceId),
+			})
+			return 0
+		},
+	})
+	invoke(t, fe, "writer", "a", nil)
+	invoke(t, fe, "writer", "b", nil)
+	// As finished before intents were sharded
+	LibWrite("writer-collector", aws.JSONValue{"InstanceId": "b"},
+		map[expression.NameBuilder]expression.OperandBuilder{
+			expression.Name("GCQ"): expression.Value("DONE"),
+		})
+
+	stats := &GCStats{}
+	newGCRun(stats, 0, nil, nil).round("writer", false)
+	if stats.IndexQueries != gcShards+1 {
+		t.Errorf("Expected %d index queries, have %d", gcShards+1, stats.IndexQueries)
+	}
+	if stats.IntentsCleared != 2 {
+		t.Errorf("Expected the intents of a and b cleared, have %d", stats.IntentsCleared)
+	}
+	if stats.LogsRemoved != 2 {
+		t.Errorf("Expected the write logs of a and b removed, have %d", stats.LogsRemoved)
+	}
+	// Both wrote the same key, whose rows are walked once
+	if stats.RowsScanned != 1 {
+		t.Errorf("Expected the row of k scanned once, have %d", stats.RowsScanned)
+	}
+	head := LibRead("writer", aws.JSONValue{"K": "k", "ROWHASH": "HEAD"}, []string{"LOGS"})
+	logs, _ := head["LOGS"].(map[string]interface{})
+	for cid := range logs {
+		if cid != "ignore" {
+			t.Errorf("Write log %s left", cid)
+		}
+	}
+}
+
+func TestDoneQueuesCoverShards(t *testing.T) {
+	queues := make(map[string]bool)
+	for _, queue := range doneQueues() {
+		queues[queue] = true
+	}
+	for _, instanceId := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		if !queues[doneQueue(instanceId)] {
+			t.Errorf("GC does not query %s, the queue of %s", doneQueue(instanceId), instanceId)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package beldilib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+func TestInspectInstance(t *testing.T) {
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"parent": func(env *Env) interface{} {
+			Write(env, "parent", "k", map[expression.NameBuilder]expression.OperandBuilder{
+				expression.Name("V"): expression.Value(env.Input),
+			})
+			output, _ := SyncInvoke(env, "child", "input")
+			return output
+		},
+		"child": func(env *Env) interface{} {
+			return Read(env, "parent", "k")
+		},
+	})
+	if ow := invoke(t, fe, "parent", "parent", "hello"); ow.Output != "hello" {
+		t.Fatalf("Expected hello, have %+v", ow)
+	}
+
+	h := InspectInstance("parent", "parent", []string{"parent"})
+	if !h.Found || !h.Done || h.Input != "hello" {
+		t.Fatalf("Unexpected parent %+v", h)
+	}
+	if len(h.Steps) != 2 || h.Steps[0].Kind != "Write" || h.Steps[0].Key != "k" || h.Steps[1].Kind != "Invoke" {
+		t.Fatalf("Unexpected steps %+v", h.Steps)
+	}
+	invoked := h.Steps[1]
+	if invoked.Callee != "child" || invoked.Result != "hello" || invoked.Child == nil {
+		t.Fatalf("Unexpected invocation %+v", invoked)
+	}
+	child := invoked.Child
+	if !child.Done || child.Input != "input" || len(child.Steps) != 1 || child.Steps[0].Kind != "Read" {
+		t.Fatalf("Unexpected child %+v", child)
+	}
+	var out bytes.Buffer
+	h.Render(&out)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[0], "parent parent DONE") ||
+		!strings.HasPrefix(lines[3], "    child ") {
+		t.Fatalf("Unexpected rendering:\n%s", out.String())
+	}
+	if h := InspectInstance("parent", "missing", nil); h.Found {
+		t.Fatalf("Expected a missing instance, have %+v", h)
+	}
+}
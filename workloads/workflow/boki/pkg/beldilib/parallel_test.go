@@ -0,0 +1,75 @@
+package beldilib
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParallelThen(t *testing.T) {
+	var calls int32
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"double": func(env *Env) interface{} {
+			atomic.AddInt32(&calls, 1)
+			return 2 * env.Input.(float64)
+		},
+		"flow": func(env *Env) interface{} {
+			var a, b, c float64
+			first := &ParallelCall{Callee: "double", Input: 1, Result: &a}
+			first.Then = []*ParallelCall{{
+				Callee:    "double",
+				MakeInput: func() interface{} { return a },
+				Result:    &b,
+			}}
+			second := &ParallelCall{Callee: "double", Input: 10, Result: &c}
+			Parallel(env, first, second)
+			return []float64{a, b, c}
+		},
+	})
+	// The replay finds the results logged for the reserved steps
+	for i := 0; i < 2; i++ {
+		ow := invoke(t, fe, "flow", "then", nil)
+		if expected := []interface{}{2.0, 4.0, 20.0}; !reflect.DeepEqual(ow.Output, expected) {
+			t.Fatalf("Expected %v, have %+v", expected, ow)
+		}
+	}
+	if calls != 3 {
+		t.Fatalf("Expected 3 invocations, have %d", calls)
+	}
+}
+
+func TestParallelPanicsWithFirstFailure(t *testing.T) {
+	var calls int32
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"double": func(env *Env) interface{} {
+			atomic.AddInt32(&calls, 1)
+			return 2 * env.Input.(float64)
+		},
+		"flow": func(env *Env) interface{} {
+			first := &ParallelCall{
+				Callee:    "double",
+				MakeInput: func() interface{} { panic("first") },
+			}
+			first.Then = []*ParallelCall{{Callee: "double", Input: 1}}
+			second := &ParallelCall{
+				Callee:    "double",
+				MakeInput: func() interface{} { panic("second") },
+			}
+			third := &ParallelCall{Callee: "double", Input: 2}
+			Parallel(env, first, second, third)
+			return 0
+		},
+	})
+	iw := InputWrapper{InstanceId: "panic"}
+	_, err := fe.InvokeFunc(context.Background(), "flow", iw.Serialize())
+	fe.Wait()
+	if err == nil || !strings.Contains(err.Error(), "first") {
+		t.Fatalf("Expected a crash with the first failure, have %v", err)
+	}
+	// The Then of the failed call is skipped, the other calls ran
+	if calls != 1 {
+		t.Fatalf("Expected 1 invocation, have %d", calls)
+	}
+}
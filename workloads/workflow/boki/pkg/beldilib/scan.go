@@ -0,0 +1,114 @@
+package beldilib
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/mitchellh/mapstructure"
+)
+
+// ScanPageSize is the number of rows a page of an exactly-once scan reads.
+// Every page is logged as one step, so it has to fit into an item.
+var ScanPageSize = int64(100)
+
+// LibScanPage scans at most limit rows from start, 0 is no limit. It
+// returns the start of the next page, nil after the last one.
+func LibScanPage(tablename string, projection []string, filter expression.ConditionBuilder,
+	start map[string]*dynamodb.AttributeValue, limit int64) ([]aws.JSONValue, map[string]*dynamodb.AttributeValue) {
+	builder := expression.NewBuilder().WithFilter(filter)
+	if len(projection) != 0 {
+		builder = builder.WithProjection(BuildProjection(projection))
+	}
+	expr, err := builder.Build()
+	CHECK(err)
+	input := &dynamodb.ScanInput{
+		TableName:                 aws.String(tablename),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		FilterExpression:          expr.Filter(),
+		ProjectionExpression:      expr.Projection(),
+		ConsistentRead:            aws.Bool(true),
+		ExclusiveStartKey:         start,
+	}
+	if limit > 0 {
+		input.Limit = aws.Int64(limit)
+	}
+	res, err := DBClient.Scan(input)
+	CHECK(err)
+	var items []aws.JSONValue
+	err = dynamodbattribute.UnmarshalListOfMaps(res.Items, &items)
+	CHECK(err)
+	if len(res.LastEvaluatedKey) == 0 {
+		return items, nil
+	}
+	return items, res.LastEvaluatedKey
+}
+
+// ScanIterator reads the latest row of every key of a DAAL table, a page
+// per Next. Each page is a step: it is logged with the token of the next
+// page, so a re-execution walks the same pages.
+//
+//	it := NewScanIterator(env, tablename, []string{"V"})
+//	for !it.Done() {
+//		items := it.Next()
+//	}
+type ScanIterator struct {
+	env        *Env
+	tablename  string
+	projection []string
+	next       aws.JSONValue
+	done       bool
+}
+
+func NewScanIterator(env *Env, tablename string, projection []string) *ScanIterator {
+	return &ScanIterator{env: env, tablename: tablename, projection: projection}
+}
+
+func (it *ScanIterator) Done() bool {
+	return it.done
+}
+
+func (it *ScanIterator) Next() []aws.JSONValue {
+	if it.done {
+		return nil
+	}
+	var start map[string]*dynamodb.AttributeValue
+	if it.next != nil {
+		var err error
+		start, err = dynamodbattribute.MarshalMap(it.next)
+		CHECK(err)
+	}
+	// the last row of a chain is the only one without NEXTROW
+	items, last := LibScanPage(it.tablename, it.projection,
+		expression.AttributeNotExists(expression.Name("NEXTROW")), start, ScanPageSize)
+	if len(it.projection) == 0 {
+		for _, item := range items {
+			for _, column := range RESERVED {
+				if column != "K" {
+					delete(item, column)
+				}
+			}
+		}
+	}
+	var next aws.JSONValue
+	if last != nil {
+		CHECK(dynamodbattribute.UnmarshalMap(last, &next))
+	}
+
+	logKey := aws.JSONValue{"InstanceId": it.env.InstanceId, "StepNumber": it.env.StepNumber}
+	it.env.StepNumber += 1
+	values := aws.JSONValue{"VS": items}
+	if next != nil {
+		values["NEXT"] = next
+	}
+	if !LibPut(it.env.LogTable, logKey, values) {
+		item := LibRead(it.env.LogTable, logKey, []string{"VS", "NEXT"})
+		items = nil
+		CHECK(mapstructure.Decode(item["VS"], &items))
+		next, _ = item["NEXT"].(map[string]interface{})
+	}
+	it.next = next
+	it.done = next == nil
+	return items
+}
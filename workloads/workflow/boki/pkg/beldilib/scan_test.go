@@ -0,0 +1,78 @@
+package beldilib
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+func TestScanIteratorReadsLatestRows(t *testing.T) {
+	logSize := defaultLogSize
+	defaultLogSize = 1
+	pageSize := ScanPageSize
+	ScanPageSize = 2
+	defer func() {
+		defaultLogSize = logSize
+		ScanPageSize = pageSize
+	}()
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"writer": func(env *Env) interface{} {
+			input := env.Input.(map[string]interface{})
+			EOSWrite(env, "writer", input["K"].(string), map[expression.NameBuilder]expression.OperandBuilder{
+				expression.Name("V"): expression.Value(input["V"]),
+			})
+			return 0
+		},
+		"scanner": func(env *Env) interface{} {
+			pages := make([]interface{}, 0)
+			it := NewScanIterator(env, "writer", nil)
+			for !it.Done() {
+				page := make([]string, 0)
+				for _, item := range it.Next() {
+					if len(item) != 2 {
+						t.Errorf("Expected K and V only, have %v", item)
+					}
+					page = append(page, fmt.Sprintf("%s=%s", item["K"], item["V"]))
+				}
+				sort.Strings(page)
+				pages = append(pages, page)
+			}
+			return pages
+		},
+	})
+	write := func(instanceId string, key string, value string) {
+		invoke(t, fe, "writer", instanceId, aws.JSONValue{"K": key, "V": value})
+	}
+	for i := 0; i < 3; i++ {
+		write(fmt.Sprintf("w%d", i), fmt.Sprintf("k%d", i), "old")
+	}
+	// With one log per row, k0 grows a chain of rows
+	write("w3", "k0", "mid")
+	write("w4", "k0", "new")
+
+	pages := invoke(t, fe, "scanner", "scan", nil).Output.([]interface{})
+	values := make([]string, 0)
+	for _, page := range pages {
+		if len(page.([]interface{})) > 2 {
+			t.Fatalf("Expected pages of at most 2, have %v", pages)
+		}
+		for _, v := range page.([]interface{}) {
+			values = append(values, v.(string))
+		}
+	}
+	sort.Strings(values)
+	if expected := []string{"k0=new", "k1=old", "k2=old"}; !reflect.DeepEqual(values, expected) {
+		t.Fatalf("Expected %v, have %v", expected, values)
+	}
+
+	// A replay reads the logged pages, not the table
+	write("w5", "k1", "new")
+	write("w6", "k3", "old")
+	if ow := invoke(t, fe, "scanner", "scan", nil); !reflect.DeepEqual(ow.Output, pages) {
+		t.Fatalf("Expected the replay to return %v, have %+v", pages, ow)
+	}
+}
@@ -0,0 +1,150 @@
+package beldilib
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// queryCounter counts Query calls, by which LastRow walks a whole chain
+type queryCounter struct {
+	dynamodbiface.DynamoDBAPI
+	queries int
+}
+
+func (c *queryCounter) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	c.queries++
+	return c.DynamoDBAPI.Query(input)
+}
+
+func dropTailCache() {
+	tailCacheMutex.Lock()
+	tailCache = map[string]string{}
+	tailCacheMutex.Unlock()
+}
+
+// newChain writes n times to key k of writer with one log per row, so k
+// grows a chain of rows, and returns its tail
+func newChain(t *testing.T, n int) string {
+	logSize := defaultLogSize
+	defaultLogSize = 1
+	t.Cleanup(func() {
+		defaultLogSize = logSize
+	})
+	fe := newWriterEnv(t)
+	for i := 0; i < n; i++ {
+		invoke(t, fe, "writer", fmt.Sprintf("w%d", i), nil)
+	}
+	tail := LastRow("writer", "k")
+	if tail == "" || tail == "HEAD" {
+		t.Fatalf("Expected a chain, have tail %q", tail)
+	}
+	return tail
+}
+
+// countQueries counts the Query calls of f
+func countQueries(f func()) int {
+	counter := &queryCounter{DynamoDBAPI: DBClient}
+	DBClient = counter
+	defer func() {
+		DBClient = counter.DynamoDBAPI
+	}()
+	f()
+	return counter.queries
+}
+
+func tailHint() interface{} {
+	return LibRead("writer", aws.JSONValue{"K": "k", "ROWHASH": "HEAD"}, []string{"TAIL"})["TAIL"]
+}
+
+func TestTailRowUsesHints(t *testing.T) {
+	tail := newChain(t, 2*tailWalkLimit)
+	if tailHint() != tail {
+		t.Fatalf("Expected the writes to leave hint %s, have %v", tail, tailHint())
+	}
+	var row string
+	if n := countQueries(func() { row = TailRow("writer", "k") }); n != 0 || row != tail {
+		t.Fatalf("Expected %s from the cache, have %q after %d queries", tail, row, n)
+	}
+	// Another process finds the tail from TAIL of HEAD
+	dropTailCache()
+	if n := countQueries(func() { row = TailRow("writer", "k") }); n != 0 || row != tail {
+		t.Fatalf("Expected %s from the hint, have %q after %d queries", tail, row, n)
+	}
+}
+
+// chainRows returns the rows of k from HEAD to the tail
+func chainRows() []string {
+	rows := []string{"HEAD"}
+	for {
+		item := LibRead("writer", aws.JSONValue{"K": "k", "ROWHASH": rows[len(rows)-1]}, []string{"NEXTROW"})
+		next, exists := item["NEXTROW"].(string)
+		if !exists {
+			return rows
+		}
+		rows = append(rows, next)
+	}
+}
+
+func TestTailRowFollowsStaleHints(t *testing.T) {
+	tail := newChain(t, 2*tailWalkLimit)
+	rows := chainRows()
+	if len(rows) <= tailWalkLimit+1 {
+		t.Fatalf("Expected a chain longer than the walk limit, have %v", rows)
+	}
+	var row string
+	for _, c := range []struct {
+		hint    string
+		queries int
+	}{
+		// behind by a row, walked forward
+		{rows[len(rows)-2], 0},
+		// behind by more than the walk limit
+		{rows[1], 1},
+		// collected by GC
+		{"collected", 1},
+	} {
+		dropTailCache()
+		writeTailHint("writer", "k", c.hint)
+		if n := countQueries(func() { row = TailRow("writer", "k") }); n != c.queries || row != tail {
+			t.Fatalf("Expected %s after %d queries from hint %s, have %q after %d",
+				tail, c.queries, c.hint, row, n)
+		}
+		if tailHint() != tail {
+			t.Fatalf("Expected hint %s moved to %s, have %v", c.hint, tail, tailHint())
+		}
+	}
+	// A cached tail GC collected falls back to the chain too
+	cacheTail("writer", "k", "collected")
+	if row = TailRow("writer", "k"); row != tail {
+		t.Fatalf("Expected %s from the chain, have %q", tail, row)
+	}
+}
+
+func TestTailCacheIsBounded(t *testing.T) {
+	size := TailCacheSize
+	TailCacheSize = 2
+	defer func() {
+		TailCacheSize = size
+		dropTailCache()
+	}()
+	dropTailCache()
+	for _, key := range []string{"a", "b", "c"} {
+		cacheTail("table", key, "HEAD")
+	}
+	if len(tailCache) != 2 {
+		t.Fatalf("Expected 2 cached tails, have %v", tailCache)
+	}
+	if _, cached := cachedTail("table", "c"); !cached {
+		t.Fatalf("Expected the latest tail cached, have %v", tailCache)
+	}
+	TailCacheSize = 0
+	dropTailCache()
+	cacheTail("table", "a", "HEAD")
+	if len(tailCache) != 0 {
+		t.Fatalf("Expected no cache, have %v", tailCache)
+	}
+}
@@ -44,24 +44,74 @@ func TPLWrite(env *Env, tablename string, key string, value aws.JSONValue) bool
 	}
 }
 
+func decisionKey(txnId string) aws.JSONValue {
+	return aws.JSONValue{"K": fmt.Sprintf("DECISION-%s", txnId), "ROWHASH": "HEAD"}
+}
+
+// getTxnDecision returns the decision recorded in the local table of the
+// coordinator, or "" if the transaction is still undecided. Participants
+// learn the outcome of a transaction from it.
+func getTxnDecision(env *Env) string {
+	if env.Coordinator == "" {
+		return ""
+	}
+	item := LibRead(fmt.Sprintf("%s-local", env.Coordinator), decisionKey(env.TxnId), []string{"DECISION"})
+	if decision, ok := item["DECISION"].(string); ok {
+		return decision
+	}
+	return ""
+}
+
+// decideTxn proposes a decision for the transaction and returns the one
+// that won
+func decideTxn(env *Env, decision string) string {
+	if env.Coordinator == "" {
+		return decision
+	}
+	LibPut(fmt.Sprintf("%s-local", env.Coordinator), decisionKey(env.TxnId), aws.JSONValue{"DECISION": decision})
+	return getTxnDecision(env)
+}
+
+func resetTxn(env *Env) {
+	if env.TxnId == env.InstanceId {
+		LibDelete(env.LocalTable, decisionKey(env.TxnId))
+	}
+	env.TxnId = ""
+	env.Coordinator = ""
+	env.Instruction = ""
+}
+
 func BeginTxn(env *Env) {
 	env.TxnId = env.InstanceId
+	env.Coordinator = env.LambdaId
 	EOSWrite(env, env.LocalTable, env.TxnId, map[expression.NameBuilder]expression.OperandBuilder{
 		expression.Name("CALLEES"): expression.Value([]string{" "}),
 	})
 	env.Instruction = "EXECUTE"
 }
 
-func CommitTxn(env *Env) {
+// CommitTxn runs a prepare round over the callees of the transaction and
+// records the decision before committing or aborting. It returns false if
+// the transaction was aborted.
+func CommitTxn(env *Env) bool {
+	env.Instruction = "PREPARE"
+	vote := "ABORT"
+	if TPLPrepare(env) {
+		vote = "COMMIT"
+	}
+	if decideTxn(env, vote) != "COMMIT" {
+		AbortTxn(env)
+		return false
+	}
 	env.Instruction = "COMMIT"
 	TPLCommit(env)
-	env.TxnId = ""
-	env.Instruction = ""
+	resetTxn(env)
+	return true
 }
 
 func AbortTxn(env *Env) {
+	decideTxn(env, "ABORT")
 	env.Instruction = "ABORT"
 	TPLAbort(env)
-	env.TxnId = ""
-	env.Instruction = ""
+	resetTxn(env)
 }
@@ -0,0 +1,54 @@
+package beldilib
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestTwoPhaseCommitWithCallee(t *testing.T) {
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"caller": func(env *Env) interface{} {
+			BeginTxn(env)
+			TPLWrite(env, "caller", "a", aws.JSONValue{"V": env.Input})
+			SyncInvoke(env, "callee", env.Input)
+			return CommitTxn(env)
+		},
+		"callee": func(env *Env) interface{} {
+			TPLWrite(env, "callee", "b", aws.JSONValue{"V": env.Input})
+			if env.Input == "abort" {
+				// As a breaker of its lock would
+				decideTxn(env, "ABORT")
+			}
+			return 0
+		},
+		"reader": func(env *Env) interface{} {
+			a := EOSRead(env, "caller", "a", []string{"V"})["V"]
+			b := EOSRead(env, "callee", "b", []string{"V"})["V"]
+			return []interface{}{a, b}
+		},
+	})
+	read := func(instanceId string) interface{} {
+		return invoke(t, fe, "reader", instanceId, nil).Output
+	}
+
+	if ow := invoke(t, fe, "caller", "ok", "ok"); ow.Output != true {
+		t.Fatalf("Expected commit, have %+v", ow)
+	}
+	if v := read("r1"); v.([]interface{})[0] != "ok" || v.([]interface{})[1] != "ok" {
+		t.Fatalf("Committed %v", v)
+	}
+	if ow := invoke(t, fe, "caller", "abort", "abort"); ow.Output != false {
+		t.Fatalf("Expected the callee to vote NO, have %+v", ow)
+	}
+	if v := read("r2"); v.([]interface{})[0] != "ok" || v.([]interface{})[1] != "ok" {
+		t.Fatalf("Aborted transaction wrote %v", v)
+	}
+	// The abort released the locks of caller and callee
+	if ow := invoke(t, fe, "caller", "again", "again"); ow.Output != true {
+		t.Fatalf("Expected commit after the abort, have %+v", ow)
+	}
+	if v := read("r3"); v.([]interface{})[0] != "again" || v.([]interface{})[1] != "again" {
+		t.Fatalf("Committed %v", v)
+	}
+}
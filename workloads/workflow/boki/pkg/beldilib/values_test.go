@@ -0,0 +1,26 @@
+package beldilib
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValuesReplay(t *testing.T) {
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"values": func(env *Env) interface{} {
+			return []interface{}{UUID(env), Now(env).Format(time.RFC3339Nano), Rand(env).Int63()}
+		},
+	})
+	first := invoke(t, fe, "values", "first", nil)
+	time.Sleep(time.Millisecond)
+	if replayed := invoke(t, fe, "values", "first", nil); !reflect.DeepEqual(replayed.Output, first.Output) {
+		t.Fatalf("Replay returned %v, the run %v", replayed.Output, first.Output)
+	}
+	second := invoke(t, fe, "values", "second", nil)
+	for i, v := range second.Output.([]interface{}) {
+		if v == first.Output.([]interface{})[i] {
+			t.Fatalf("Instances share value %d: %v", i, v)
+		}
+	}
+}
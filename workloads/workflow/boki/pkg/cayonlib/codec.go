@@ -0,0 +1,166 @@
+package cayonlib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// LogCodec serializes log records. A record starts with the Id of its
+// codec, so a log written by several versions keeps replaying. JSON records
+// are written bare, as before codecs existed: they start with '{'. Ids are
+// never reused: an incompatible change of a format gets a new Id.
+type LogCodec interface {
+	Id() byte
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// CODEC is the name of the codec new records are written with
+var CODEC = "JSON"
+
+var logCodecs = map[byte]LogCodec{}
+var logCodecsByName = map[string]LogCodec{}
+
+// RegisterLogCodec makes records written by codec readable, and codec
+// selectable by CODEC
+func RegisterLogCodec(codec LogCodec) {
+	id := codec.Id()
+	// 0 marks batches, '{' records written before codecs existed
+	if id == batchMarker || id == '{' {
+		panic(fmt.Sprintf("Reserved codec id %d", id))
+	}
+	if _, exists := logCodecs[id]; exists {
+		panic(fmt.Sprintf("Duplicate codec id %d", id))
+	}
+	logCodecs[id] = codec
+	logCodecsByName[codec.Name()] = codec
+}
+
+func init() {
+	RegisterLogCodec(jsonCodec{})
+	RegisterLogCodec(msgpackCodec{})
+}
+
+// jsonCodec is written without its Id, which only reads records written
+// with it
+type jsonCodec struct{}
+
+func (jsonCodec) Id() byte {
+	return 1
+}
+
+func (jsonCodec) Name() string {
+	return "JSON"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// msgpackCodec keeps integers apart from floats. Field names are the json
+// ones, so records decode into the same structs.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Id() byte {
+	return 2
+}
+
+func (msgpackCodec) Name() string {
+	return "MSGPACK"
+}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetOmitEmpty(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+func encodeRecord(v interface{}) []byte {
+	codec, exists := logCodecsByName[CODEC]
+	if !exists {
+		panic(fmt.Sprintf("Unknown codec %s", CODEC))
+	}
+	payload, err := codec.Marshal(v)
+	CHECK(err)
+	if codec.Name() == "JSON" {
+		return payload
+	}
+	return append([]byte{codec.Id()}, payload...)
+}
+
+func decodeRecord(data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return fmt.Errorf("Empty log record")
+	}
+	if data[0] == '{' {
+		return json.Unmarshal(data, v)
+	}
+	codec, exists := logCodecs[data[0]]
+	if !exists {
+		return fmt.Errorf("Unknown codec id %d", data[0])
+	}
+	return codec.Unmarshal(data[1:], v)
+}
+
+// asJSON returns v as the caller of an invocation decodes it. Outputs are
+// logged that way, so that live and replayed outputs have the same types.
+func asJSON(v interface{}) interface{} {
+	if CODEC == "JSON" {
+		return v
+	}
+	raw, err := json.Marshal(v)
+	CHECK(err)
+	var res interface{}
+	CHECK(json.Unmarshal(raw, &res))
+	return res
+}
+
+// LogInt reads an integer of a decoded record, JSON decodes it as float64
+func LogInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return int64(n), true
+	case float32:
+		return int64(n), true
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint:
+		return int64(n), true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint64:
+		return int64(n), true
+	}
+	return 0, false
+}
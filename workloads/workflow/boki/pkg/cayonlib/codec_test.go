@@ -0,0 +1,77 @@
+package cayonlib
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func withCodec(t *testing.T, name string) {
+	codec := CODEC
+	CODEC = name
+	t.Cleanup(func() {
+		CODEC = codec
+	})
+}
+
+func TestEncodeRecord(t *testing.T) {
+	record := aws.JSONValue{"type": "Read", "step": 3}
+	withCodec(t, "JSON")
+	if data := encodeRecord(record); data[0] != '{' {
+		t.Fatalf("JSON records must be written bare, have %q", data)
+	}
+	withCodec(t, "MSGPACK")
+	data := encodeRecord(record)
+	if data[0] != (msgpackCodec{}).Id() {
+		t.Fatalf("Expected the MSGPACK id first, have %q", data)
+	}
+	var decoded aws.JSONValue
+	if err := decodeRecord(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if step, _ := LogInt(decoded["step"]); decoded["type"] != "Read" || step != 3 {
+		t.Fatalf("Decoded %v", decoded)
+	}
+}
+
+func TestDecodeRecord(t *testing.T) {
+	var decoded aws.JSONValue
+	if err := decodeRecord([]byte(`{"step":3}`), &decoded); err != nil || decoded["step"] != 3.0 {
+		t.Fatalf("Failed to decode a bare JSON record: %v %v", decoded, err)
+	}
+	if err := decodeRecord(append([]byte{(jsonCodec{}).Id()}, `{"step":4}`...), &decoded); err != nil || decoded["step"] != 4.0 {
+		t.Fatalf("Failed to decode a prefixed JSON record: %v %v", decoded, err)
+	}
+	if err := decodeRecord([]byte{42, '{', '}'}, &decoded); err == nil {
+		t.Fatalf("Decoded a record of an unknown codec")
+	}
+}
+
+func TestReplayAcrossCodecs(t *testing.T) {
+	var calls int
+	math := NewService().Register("Double", func(env *Env, n int) int {
+		calls++
+		return 2 * n
+	})
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"math": math.Handler,
+		"flow": func(env *Env) interface{} {
+			var n int
+			Call(env, "math", "Double", 21, &n)
+			return n
+		},
+	})
+	withCodec(t, "MSGPACK")
+	first := invoke(t, fe, "flow", "flow", nil)
+	// A log written with MSGPACK replays after switching back to JSON
+	withCodec(t, "JSON")
+	second := replay(t, fe, "flow", "flow", nil)
+	for _, ow := range []OutputWrapper{first, second} {
+		if ow.Output != 42.0 {
+			t.Fatalf("Expected 42, have %+v", ow)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("Expected 1 invocation, have %d", calls)
+	}
+}
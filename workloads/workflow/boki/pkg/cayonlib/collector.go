@@ -0,0 +1,77 @@
+package cayonlib
+
+import (
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+type unfinishedInstance struct {
+	lambdaId string
+	iw       InputWrapper
+	st       int64
+}
+
+// RestartAll re-invokes the top-level and asynchronous instances that
+// started more than T seconds ago and have not finished. Replaying their
+// step logs finishes them exactly once. Synchronous callees are left to
+// their callers. If lambdaIds is not empty, only those functions are
+// restarted.
+func RestartAll(env *Env, lambdaIds ...string) {
+	filter := make(map[string]bool)
+	for _, lambdaId := range lambdaIds {
+		filter[lambdaId] = true
+	}
+	unfinished := make(map[string]*unfinishedInstance)
+	scanIntentLog(env, func(seqNum uint64, record aws.JSONValue) {
+		instanceId := record["InstanceId"].(string)
+		if finished, ok := record["DONE"].(bool); ok && finished {
+			delete(unfinished, instanceId)
+			return
+		}
+		// Suspended instances are resumed by their timers
+		if suspended, ok := record["SUSPENDED"].(bool); ok && suspended {
+			delete(unfinished, instanceId)
+			return
+		}
+		lambdaId, _ := record["LambdaId"].(string)
+		input, ok := record["INPUT"]
+		if lambdaId == "" || !ok || (len(filter) > 0 && !filter[lambdaId]) {
+			return
+		}
+		async, _ := record["ASYNC"].(bool)
+		callerName, _ := record["CallerName"].(string)
+		if callerName != "" && !async {
+			return
+		}
+		instance := &unfinishedInstance{
+			lambdaId: lambdaId,
+			iw: InputWrapper{
+				CallerName: callerName,
+				InstanceId: instanceId,
+				Input:      input,
+				Async:      true,
+			},
+		}
+		if callerName != "" {
+			instance.iw.CallerId = record["CallerId"].(string)
+			callerStep, _ := LogInt(record["CallerStep"])
+			instance.iw.CallerStep = int32(callerStep)
+		}
+		if st, ok := LogInt(record["ST"]); ok {
+			instance.st = st
+		}
+		// Keep the latest start, a restarted instance waits T seconds again
+		unfinished[instanceId] = instance
+	})
+	deadline := time.Now().Unix() - T
+	for instanceId, instance := range unfinished {
+		if instance.st > deadline {
+			continue
+		}
+		log.Printf("[INFO] Restart instance %s of %s", instanceId, instance.lambdaId)
+		err := env.FaasEnv.InvokeFuncAsync(env.FaasCtx, instance.lambdaId, instance.iw.Serialize())
+		CHECK(err)
+	}
+}
@@ -3,6 +3,7 @@ package cayonlib
 import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"os"
 )
 
@@ -10,12 +11,17 @@ var sess = session.Must(session.NewSessionWithOptions(session.Options{
 	SharedConfigState: session.SharedConfigEnable,
 }))
 
-var DBClient = dynamodb.New(sess)
+// DBClient can be replaced, e.g. with memdb.New(), to run without AWS
+var DBClient dynamodbiface.DynamoDBAPI = dynamodb.New(sess)
 
 var T = int64(60)
 
 var TYPE = "BELDI"
 
+// BATCHING=ENABLE defers step results and transaction ops, which are then
+// appended with the next log record of the instance
+var BATCHING = "DISABLE"
+
 func CHECK(err error) {
 	if err != nil {
 		panic(err)
@@ -11,7 +11,6 @@ import (
 	// lambdaSdk "github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/lithammer/shortuuid"
 	"github.com/mitchellh/mapstructure"
-	"github.com/golang/snappy"
 
 	"cs.utexas.edu/zjia/faas/types"
 
@@ -27,6 +26,9 @@ type InputWrapper struct {
 	InstanceId  string      `mapstructure:"InstanceId"`
 	Input       interface{} `mapstructure:"Input"`
 	TxnId       string      `mapstructure:"TxnId"`
+	TxnTs       int64       `mapstructure:"TxnTs"`
+	TxnMode     string      `mapstructure:"TxnMode"`
+	TxnLease    int64       `mapstructure:"TxnLease"`
 	Instruction string      `mapstructure:"Instruction"`
 	Async       bool        `mapstructure:"Async"`
 }
@@ -65,6 +67,7 @@ type InvokeError struct {
 type OutputWrapper struct {
 	Status string
 	Output interface{}
+	Error  *AppError `json:",omitempty"`
 }
 
 func (ow *OutputWrapper) Serialize() []byte {
@@ -105,11 +108,25 @@ func PrepareEnv(iw *InputWrapper, lambdaId string) *Env {
 		StepNumber:  0,
 		Input:       iw.Input,
 		TxnId:       iw.TxnId,
+		TxnTs:       iw.TxnTs,
+		TxnMode:     iw.TxnMode,
+		TxnLease:    iw.TxnLease,
 		Instruction: iw.Instruction,
 	}
 }
 
+// SyncInvoke panics with the *AppError callee failed with, which fails the
+// calling function in turn
 func SyncInvoke(env *Env, callee string, input interface{}) (interface{}, string) {
+	output, instanceId, err := TrySyncInvoke(env, callee, input)
+	if err != nil {
+		panic(err)
+	}
+	return output, instanceId
+}
+
+// TrySyncInvoke returns the *AppError callee failed with
+func TrySyncInvoke(env *Env, callee string, input interface{}) (interface{}, string, error) {
 	newLog, preInvokeLog := ProposeNextStep(env, aws.JSONValue{
 		"type":       "PreInvoke",
 		"instanceId": shortuuid.New(),
@@ -124,7 +141,10 @@ func SyncInvoke(env *Env, callee string, input interface{}) (interface{}, string
 		if resultLog != nil {
 			CheckLogDataField(resultLog, "type", "InvokeResult")
 			log.Printf("[INFO] Seen InvokeResult log for step %d", preInvokeLog.StepNumber)
-			return resultLog.Data["output"], instanceId
+			if appErr := decodeAppError(resultLog.Data["error"]); appErr != nil {
+				return nil, instanceId, appErr
+			}
+			return resultLog.Data["output"], instanceId, nil
 		}
 	}
 
@@ -136,10 +156,15 @@ func SyncInvoke(env *Env, callee string, input interface{}) (interface{}, string
 		InstanceId:  instanceId,
 		Input:       input,
 		TxnId:       env.TxnId,
+		TxnTs:       env.TxnTs,
+		TxnMode:     env.TxnMode,
+		TxnLease:    env.TxnLease,
 		Instruction: env.Instruction,
 	}
 	if iw.Instruction == "EXECUTE" {
-		LibAppendLog(env, TransactionStreamTag(env.LambdaId, env.TxnId), &TxnLogEntry{
+		// Written ahead, so the outcome of the transaction reaches callee
+		// even if the caller crashes while callee holds locks
+		LibAppendLogWithTags(env, []uint64{TransactionStreamTag(env.LambdaId, env.TxnId)}, &TxnLogEntry{
 			LambdaId: env.LambdaId,
 			TxnId:    env.TxnId,
 			Callee:   callee,
@@ -153,17 +178,20 @@ func SyncInvoke(env *Env, callee string, input interface{}) (interface{}, string
 	ow.Deserialize(res)
 	switch ow.Status {
 	case "Success":
-		return ow.Output, iw.InstanceId
+		return ow.Output, iw.InstanceId, nil
+	case "Failure":
+		return nil, iw.InstanceId, ow.Error
 	default:
 		panic("never happens")
 	}
 }
 
-func ProposeInvoke(env *Env, callee string) *IntentLogEntry {
+func ProposeInvoke(env *Env, callee string, input interface{}) *IntentLogEntry {
 	newLog, preInvokeLog := ProposeNextStep(env, aws.JSONValue{
 		"type":       "PreInvoke",
 		"instanceId": shortuuid.New(),
 		"callee":     callee,
+		"input":      input,
 	})
 	if !newLog {
 		CheckLogDataField(preInvokeLog, "type", "PreInvoke")
@@ -174,6 +202,17 @@ func ProposeInvoke(env *Env, callee string) *IntentLogEntry {
 }
 
 func AssignedSyncInvoke(env *Env, callee string, input interface{}, preInvokeLog *IntentLogEntry) (interface{}, string) {
+	output, instanceId, err := TryAssignedSyncInvoke(env, callee, input, preInvokeLog)
+	if err != nil {
+		panic(err)
+	}
+	return output, instanceId
+}
+
+// TryAssignedSyncInvoke returns the *AppError callee failed with, where
+// AssignedSyncInvoke panics with it. Other failures still panic, so a
+// goroutine calling it has to recover, as Parallel does.
+func TryAssignedSyncInvoke(env *Env, callee string, input interface{}, preInvokeLog *IntentLogEntry) (interface{}, string, error) {
 	CheckLogDataField(preInvokeLog, "type", "PreInvoke")
 	CheckLogDataField(preInvokeLog, "callee", callee)
 
@@ -183,7 +222,10 @@ func AssignedSyncInvoke(env *Env, callee string, input interface{}, preInvokeLog
 	if resultLog != nil {
 		CheckLogDataField(resultLog, "type", "InvokeResult")
 		log.Printf("[INFO] Seen InvokeResult log for step %d", preInvokeLog.StepNumber)
-		return resultLog.Data["output"], instanceId
+		if appErr := decodeAppError(resultLog.Data["error"]); appErr != nil {
+			return nil, instanceId, appErr
+		}
+		return resultLog.Data["output"], instanceId, nil
 	}
 
 	iw := InputWrapper{
@@ -194,10 +236,15 @@ func AssignedSyncInvoke(env *Env, callee string, input interface{}, preInvokeLog
 		InstanceId:  instanceId,
 		Input:       input,
 		TxnId:       env.TxnId,
+		TxnTs:       env.TxnTs,
+		TxnMode:     env.TxnMode,
+		TxnLease:    env.TxnLease,
 		Instruction: env.Instruction,
 	}
 	if iw.Instruction == "EXECUTE" {
-		LibAppendLog(env, TransactionStreamTag(env.LambdaId, env.TxnId), &TxnLogEntry{
+		// Written ahead, so the outcome of the transaction reaches callee
+		// even if the caller crashes while callee holds locks
+		LibAppendLogWithTags(env, []uint64{TransactionStreamTag(env.LambdaId, env.TxnId)}, &TxnLogEntry{
 			LambdaId: env.LambdaId,
 			TxnId:    env.TxnId,
 			Callee:   callee,
@@ -211,13 +258,16 @@ func AssignedSyncInvoke(env *Env, callee string, input interface{}, preInvokeLog
 	ow.Deserialize(res)
 	switch ow.Status {
 	case "Success":
-		return ow.Output, iw.InstanceId
+		return ow.Output, iw.InstanceId, nil
+	case "Failure":
+		return nil, iw.InstanceId, ow.Error
 	default:
 		panic("never happens")
 	}
 }
 
-func AsyncInvoke(env *Env, callee string, input interface{}) string {
+// AsyncInvoke returns a Future of the result of callee
+func AsyncInvoke(env *Env, callee string, input interface{}) *Future {
 	newLog, preInvokeLog := ProposeNextStep(env, aws.JSONValue{
 		"type":       "PreInvoke",
 		"instanceId": shortuuid.New(),
@@ -232,7 +282,7 @@ func AsyncInvoke(env *Env, callee string, input interface{}) string {
 		if resultLog != nil {
 			CheckLogDataField(resultLog, "type", "InvokeResult")
 			log.Printf("[INFO] Seen InvokeResult log for step %d", preInvokeLog.StepNumber)
-			return instanceId
+			return &Future{InstanceId: instanceId, stepNumber: preInvokeLog.StepNumber}
 		}
 	}
 
@@ -255,26 +305,49 @@ func AsyncInvoke(env *Env, callee string, input interface{}) string {
 	payload := iw.Serialize()
 	err := env.FaasEnv.InvokeFuncAsync(env.FaasCtx, callee, payload)
 	CHECK(err)
-	return iw.InstanceId
+	return &Future{InstanceId: iw.InstanceId, stepNumber: preInvokeLog.StepNumber}
 }
 
+// getAllTxnLogs returns the callees and written keys of env in the
+// transaction, each once. Replays append the same entries again, and the
+// writes to a key are merged in order.
 func getAllTxnLogs(env *Env) []*TxnLogEntry {
 	tag := TransactionStreamTag(env.LambdaId, env.TxnId)
 	seqNum := uint64(0)
 	results := make([]*TxnLogEntry, 0)
+	seen := make(map[string]*TxnLogEntry)
+	FlushLogs(env)
 	for {
 		logEntry, err := env.FaasEnv.SharedLogReadNext(env.FaasCtx, tag, seqNum)
 		CHECK(err)
 		if logEntry == nil {
 			break
 		}
-		decoded, err := snappy.Decode(nil, logEntry.Data)
-		CHECK(err)
-		var txnLog TxnLogEntry
-		err = json.Unmarshal(decoded, &txnLog)
-		CHECK(err)
-		if txnLog.LambdaId == env.LambdaId && txnLog.TxnId == env.TxnId {
+		for _, decoded := range decodeLogEntry(logEntry, tag) {
+			var txnLog TxnLogEntry
+			err = decodeRecord(decoded, &txnLog)
+			CHECK(err)
+			if txnLog.LambdaId != env.LambdaId || txnLog.TxnId != env.TxnId {
+				continue
+			}
+			id := "callee-" + txnLog.Callee
+			if txnLog.Callee == "" {
+				if len(txnLog.WriteOp) == 0 {
+					continue
+				}
+				id = fmt.Sprintf("write-%s-%s", txnLog.WriteOp["tablename"], txnLog.WriteOp["key"])
+			}
+			if first, ok := seen[id]; ok {
+				if txnLog.Callee == "" {
+					value := first.WriteOp["value"].(map[string]interface{})
+					for kk, vv := range txnLog.WriteOp["value"].(map[string]interface{}) {
+						value[kk] = vv
+					}
+				}
+				continue
+			}
 			txnLog.SeqNum = logEntry.SeqNum
+			seen[id] = &txnLog
 			results = append(results, &txnLog)
 		}
 		seqNum = logEntry.SeqNum + 1
@@ -282,6 +355,43 @@ func getAllTxnLogs(env *Env) []*TxnLogEntry {
 	return results
 }
 
+func holdsLock(env *Env, tablename string, key string) bool {
+	fsm := getOrCreateLockFsm(fmt.Sprintf("%s-%s", tablename, key))
+	fsm.catch(env)
+	storeBackLockFsm(fsm)
+	return fsm.holder() == env.TxnId
+}
+
+// TPLPrepare returns the vote of env and its callees on the transaction.
+// env votes NO if the transaction is already aborted or one of its locks
+// was broken. Every callee is asked even after a NO, so that a replay
+// proposes the same steps.
+func TPLPrepare(env *Env) bool {
+	vote := getTxnDecision(env, env.TxnId) != "ABORT"
+	txnLogs := getAllTxnLogs(env)
+	for _, txnLog := range txnLogs {
+		if txnLog.Callee != "" || len(txnLog.WriteOp) == 0 {
+			continue
+		}
+		tablename := txnLog.WriteOp["tablename"].(string)
+		key := txnLog.WriteOp["key"].(string)
+		if !holdsLock(env, tablename, key) {
+			log.Printf("[WARN] Transaction %s lost lock %s-%s", env.TxnId, tablename, key)
+			vote = false
+		}
+	}
+	for _, txnLog := range txnLogs {
+		if txnLog.Callee != "" {
+			output, _ := SyncInvoke(env, txnLog.Callee, aws.JSONValue{})
+			if yes, ok := output.(bool); !ok || !yes {
+				log.Printf("[WARN] Callee %s votes NO on transaction %s", txnLog.Callee, env.TxnId)
+				vote = false
+			}
+		}
+	}
+	return vote
+}
+
 func TPLCommit(env *Env) {
 	txnLogs := getAllTxnLogs(env)
 	for _, txnLog := range txnLogs {
@@ -328,20 +438,20 @@ func wrapperInternal(f func(*Env) interface{}, iw *InputWrapper, env *Env) (Outp
 		panic("Baseline type not supported")
 	}
 
-	if iw.Async == false || iw.CallerName == "" {
-		LibAppendLog(env, IntentLogTag, aws.JSONValue{
-			"InstanceId": env.InstanceId,
-			"DONE": false,
-			"ASYNC": iw.Async,
-			"INPUT": iw.Input,
-			"ST": time.Now().Unix(),
-		})
-	} else {
-		LibAppendLog(env, IntentLogTag, aws.JSONValue{
-			"InstanceId": env.InstanceId,
-			"ST": time.Now().Unix(),
-		})
+	startLog := aws.JSONValue{
+		"InstanceId": env.InstanceId,
+		"LambdaId": env.LambdaId,
+		"DONE": false,
+		"ASYNC": iw.Async,
+		"INPUT": iw.Input,
+		"ST": time.Now().Unix(),
+	}
+	if iw.CallerName != "" {
+		startLog["CallerName"] = iw.CallerName
+		startLog["CallerId"] = iw.CallerId
+		startLog["CallerStep"] = iw.CallerStep
 	}
+	LibAppendLog(env, IntentLogTag, startLog)
 	//ok := LibPut(env.IntentTable, aws.JSONValue{"InstanceId": env.InstanceId},
 	//	aws.JSONValue{"DONE": false, "ASYNC": iw.Async})
 	//if !ok {
@@ -355,29 +465,72 @@ func wrapperInternal(f func(*Env) interface{}, iw *InputWrapper, env *Env) (Outp
 	//	}
 	//}
 
+	env.wrapper = iw
 	var output interface{}
-	if env.Instruction == "COMMIT" {
-		TPLCommit(env)
-		output = 0
-	} else if env.Instruction == "ABORT" {
-		TPLAbort(env)
+	var appErr *AppError
+	var suspended *suspension
+	if env.Instruction == "PREPARE" {
+		output = TPLPrepare(env)
+	} else if env.Instruction == "COMMIT" || env.Instruction == "ABORT" {
+		// The decision record wins over the instruction
+		if decision := getTxnDecision(env, env.TxnId); decision != "" {
+			env.Instruction = decision
+		}
+		if env.Instruction == "COMMIT" {
+			TPLCommit(env)
+		} else {
+			TPLAbort(env)
+		}
 		output = 0
 	} else {
-		output = f(env)
+		output, appErr, suspended = runFunction(f, env)
+		if StepHook != nil && suspended == nil {
+			StepHook(env, env.StepNumber)
+		}
 	}
 
-	if iw.CallerName != "" {
-		LogStepResult(env, iw.CallerId, iw.CallerStep, aws.JSONValue{
-			"type":   "InvokeResult",
-			"output": output,
+	if suspended != nil {
+		// The timer resumes the instance, which stays unfinished until then
+		log.Printf("[INFO] Suspend instance %s of %s until %d", env.InstanceId, env.LambdaId, suspended.at)
+		LibAppendLog(env, IntentLogTag, aws.JSONValue{
+			"InstanceId": env.InstanceId,
+			"LambdaId":   env.LambdaId,
+			"SUSPENDED":  true,
+			"WAKE":       suspended.at,
 		})
+		return OutputWrapper{
+			Status: "Suspended",
+		}, nil
 	}
-	LibAppendLog(env, IntentLogTag, aws.JSONValue{
-		"InstanceId": env.InstanceId,
-		"DONE":       true,
-		"TS":         time.Now().Unix(),
-	})
 
+	result := aws.JSONValue{
+		"type":   "InvokeResult",
+		"output": asJSON(output),
+	}
+	doneLog := aws.JSONValue{
+		"InstanceId":  env.InstanceId,
+		"LambdaId":    env.LambdaId,
+		"TxnId":       env.TxnId,
+		"Instruction": env.Instruction,
+		"DONE":        true,
+		"TS":          time.Now().Unix(),
+	}
+	if appErr != nil {
+		log.Printf("[WARN] Instance %s of %s failed: %s", env.InstanceId, env.LambdaId, appErr.Error())
+		result["error"] = appErr
+		doneLog["ERROR"] = appErr
+	}
+	if iw.CallerName != "" {
+		LogStepResult(env, iw.CallerId, iw.CallerStep, result)
+	}
+	LibAppendLogWithTags(env, []uint64{IntentLogTag, InstanceDoneStreamTag(env.InstanceId)}, doneLog)
+
+	if appErr != nil {
+		return OutputWrapper{
+			Status: "Failure",
+			Error:  appErr,
+		}, nil
+	}
 	return OutputWrapper{
 		Status: "Success",
 		Output: output,
@@ -400,12 +553,16 @@ func (w *funcHandlerWrapper) Call(ctx context.Context, input []byte) ([]byte, er
 	env := PrepareEnv(iw, w.fnName)
 	env.FaasCtx = ctx
 	env.FaasEnv = w.env
-	env.Fsm = NewIntentFsm(env.InstanceId)
+	if BATCHING == "ENABLE" {
+		env.batch = &logBatch{}
+	}
+	env.Fsm = getOrCreateIntentFsm(env.InstanceId)
 	env.Fsm.Catch(env)
 	ow, err := wrapperInternal(w.handler, iw, env)
 	if err != nil {
 		return nil, err
 	}
+	storeBackIntentFsm(env.Fsm)
 	return ow.Serialize(), nil
 }
 
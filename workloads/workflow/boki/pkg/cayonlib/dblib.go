@@ -2,6 +2,7 @@ package cayonlib
 
 import (
 	"log"
+	"time"
 	// "fmt"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	// "github.com/mitchellh/mapstructure"
@@ -67,81 +68,32 @@ func LibWrite(tablename string, key aws.JSONValue,
 }
 
 func LibScanWithLast(tablename string, projection []string, last map[string]*dynamodb.AttributeValue) []aws.JSONValue {
-	var res *dynamodb.ScanOutput
-	var err error
-	if last == nil {
-		if len(projection) == 0 {
-			expr, err := expression.NewBuilder().Build()
-			CHECK(err)
-			res, err = DBClient.Scan(&dynamodb.ScanInput{
-				TableName:                 aws.String(tablename),
-				ExpressionAttributeNames:  expr.Names(),
-				ExpressionAttributeValues: expr.Values(),
-				FilterExpression:          expr.Filter(),
-				ConsistentRead:            aws.Bool(true),
-			})
-		} else {
-			expr, err := expression.NewBuilder().WithProjection(BuildProjection(projection)).Build()
-			CHECK(err)
-			res, err = DBClient.Scan(&dynamodb.ScanInput{
-				TableName:                 aws.String(tablename),
-				ExpressionAttributeNames:  expr.Names(),
-				ExpressionAttributeValues: expr.Values(),
-				FilterExpression:          expr.Filter(),
-				ProjectionExpression:      expr.Projection(),
-				ConsistentRead:            aws.Bool(true),
-			})
-		}
-	} else {
-		if len(projection) == 0 {
-			expr, err := expression.NewBuilder().Build()
-			CHECK(err)
-			res, err = DBClient.Scan(&dynamodb.ScanInput{
-				TableName:                 aws.String(tablename),
-				ExpressionAttributeNames:  expr.Names(),
-				ExpressionAttributeValues: expr.Values(),
-				FilterExpression:          expr.Filter(),
-				ConsistentRead:            aws.Bool(true),
-				ExclusiveStartKey:         last,
-			})
-		} else {
-			expr, err := expression.NewBuilder().WithProjection(BuildProjection(projection)).Build()
-			CHECK(err)
-			res, err = DBClient.Scan(&dynamodb.ScanInput{
-				TableName:                 aws.String(tablename),
-				ExpressionAttributeNames:  expr.Names(),
-				ExpressionAttributeValues: expr.Values(),
-				FilterExpression:          expr.Filter(),
-				ProjectionExpression:      expr.Projection(),
-				ConsistentRead:            aws.Bool(true),
-				ExclusiveStartKey:         last,
-			})
+	var items []aws.JSONValue
+	for {
+		page, next := LibScanPage(tablename, projection, last, 0)
+		items = append(items, page...)
+		if next == nil {
+			return items
 		}
+		last = next
 	}
-	CHECK(err)
-	var item []aws.JSONValue
-	err = dynamodbattribute.UnmarshalListOfMaps(res.Items, &item)
-	CHECK(err)
-	if res.LastEvaluatedKey == nil || len(res.LastEvaluatedKey) == 0 {
-		return item
-	}
-	log.Printf("[DEBUG] Exceed Scan limit")
-	item = append(item, LibScanWithLast(tablename, projection, res.LastEvaluatedKey)...)
-	return item
 }
 
 func LibScan(tablename string, projection []string) []aws.JSONValue {
 	return LibScanWithLast(tablename, projection, nil)
 }
 
+// CondWrite applies update if cond holds, as one step. It returns whether
+// the update was applied.
 func CondWrite(env *Env, tablename string, key string,
 		update map[expression.NameBuilder]expression.OperandBuilder,
-		cond expression.ConditionBuilder) {
+		cond expression.ConditionBuilder) bool {
+	keyTag := KeyStreamTag(tablename, key)
 	newLog, preWriteLog := ProposeNextStep(env, aws.JSONValue{
 		"type":  "PreWrite",
 		"key":   key,
 		"table": tablename,
-	})
+	}, keyTag)
 	if !newLog {
 		CheckLogDataField(preWriteLog, "type", "PreWrite")
 		CheckLogDataField(preWriteLog, "table", tablename)
@@ -153,7 +105,8 @@ func CondWrite(env *Env, tablename string, key string,
 			CheckLogDataField(resultLog, "table", tablename)
 			CheckLogDataField(resultLog, "key", key)
 			log.Printf("[INFO] Seen PostWrite log for step %d", preWriteLog.StepNumber)
-			return
+			applied, _ := resultLog.Data["applied"].(bool)
+			return applied
 		}
 	}
 
@@ -182,21 +135,52 @@ func CondWrite(env *Env, tablename string, key string,
 		ExpressionAttributeValues: expr.Values(),
 		UpdateExpression:          expr.Update(),
 	})
+	applied := true
 	if err != nil {
 		AssertConditionFailure(err)
+		// A replayed write finds its own VERSION, any other one failed cond
+		// or was ordered before a write already applied
+		item := LibRead(tablename, aws.JSONValue{"K": key}, []string{"VERSION"})
+		version, _ := LogInt(item["VERSION"])
+		applied = uint64(version) == preWriteLog.SeqNum
 	}
 
 	LogStepResult(env, env.InstanceId, preWriteLog.StepNumber, aws.JSONValue{
 		"type":    "PostWrite",
 		"key":     key,
 		"table":   tablename,
-	})
+		"applied": applied,
+		"version": preWriteLog.SeqNum,
+	}, keyTag)
+	return applied
 }
 
 func Write(env *Env, tablename string, key string, update map[expression.NameBuilder]expression.OperandBuilder) {
 	CondWrite(env, tablename, key, update, expression.ConditionBuilder{})
 }
 
+// RetryWrite retries a dropped write up to WriteRetries times, waiting
+// writeRetryInterval at first and twice as long after each attempt
+var WriteRetries = 8
+var writeRetryInterval = 10 * time.Millisecond
+
+// RetryWrite is Write for an update that must not be lost, e.g. an
+// increment. A write is dropped if a concurrent one was ordered after it,
+// so it is retried as a new step. It returns whether the update applied.
+func RetryWrite(env *Env, tablename string, key string, update map[expression.NameBuilder]expression.OperandBuilder) bool {
+	interval := writeRetryInterval
+	for attempt := 0; ; attempt++ {
+		if CondWrite(env, tablename, key, update, expression.ConditionBuilder{}) {
+			return true
+		}
+		if attempt == WriteRetries {
+			return false
+		}
+		time.Sleep(interval)
+		interval *= 2
+	}
+}
+
 func Read(env *Env, tablename string, key string) interface{} {
 	step := env.StepNumber
 	newLog := false
@@ -229,30 +213,12 @@ func Read(env *Env, tablename string, key string) interface{} {
 }
 
 func Scan(env *Env, tablename string) interface{} {
-	step := env.StepNumber
-	newLog := false
-	intentLog := env.Fsm.GetStepLog(step)
-	if intentLog != nil {
-		env.StepNumber += 1
-	} else {
-		// log.Printf("[INFO] Scan data from DB")
-		items := LibScan(tablename, []string{"V"})
-		var res []interface{}
-		for _, item := range items {
-			res = append(res, item["V"])
-		}
-		newLog, intentLog = ProposeNextStep(env, aws.JSONValue{
-			"type": "Scan",
-			"table": tablename,
-			"result": res,
-		})
+	var res []interface{}
+	it := NewScanIterator(env, tablename)
+	for !it.Done() {
+		res = append(res, it.Next()...)
 	}
-	if !newLog {
-		CheckLogDataField(intentLog, "type", "Scan")
-		CheckLogDataField(intentLog, "table", tablename)
-		log.Printf("[INFO] Seen Scan log for step %d", intentLog.StepNumber)
-	}
-	return intentLog.Data["result"]
+	return res
 }
 
 func BuildProjection(names []string) expression.ProjectionBuilder {
@@ -16,9 +16,14 @@ type Env struct {
 	StepNumber  int32
 	Input       interface{}
 	TxnId       string
+	TxnTs       int64
+	TxnMode     string
+	TxnLease    int64
 	Instruction string
 	Baseline    bool
 	FaasCtx     context.Context
 	FaasEnv     types.Environment
 	Fsm         *IntentFsm
+	wrapper     *InputWrapper
+	batch       *logBatch
 }
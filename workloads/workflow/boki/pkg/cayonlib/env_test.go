@@ -0,0 +1,74 @@
+package cayonlib
+
+import (
+	"context"
+	"testing"
+
+	"cs.utexas.edu/zjia/faas-memdb"
+	"github.com/eniac/Beldi/pkg/localfaas"
+)
+
+// newTestEnv returns a local environment running fns, on a fresh memdb
+func newTestEnv(t *testing.T, fns map[string]func(env *Env) interface{}) *localfaas.Environment {
+	dbClient := DBClient
+	DBClient = memdb.New()
+	ResetIntentFsmCache()
+	t.Cleanup(func() {
+		DBClient = dbClient
+	})
+	fe := localfaas.NewEnvironment()
+	for name, fn := range fns {
+		fe.Register(name, CreateFuncHandlerFactory(fn))
+	}
+	return fe
+}
+
+// invoke runs instanceId of funcName and waits for everything it started
+func invoke(t *testing.T, fe *localfaas.Environment, funcName string, instanceId string, input interface{}) OutputWrapper {
+	t.Helper()
+	iw := InputWrapper{
+		InstanceId: instanceId,
+		Input:      input,
+	}
+	res, err := fe.InvokeFunc(context.Background(), funcName, iw.Serialize())
+	if err != nil {
+		t.Fatalf("Invoke %s of %s: %v", instanceId, funcName, err)
+	}
+	for _, err := range fe.Wait() {
+		t.Fatalf("Invoke %s of %s: %v", instanceId, funcName, err)
+	}
+	var ow OutputWrapper
+	ow.Deserialize(res)
+	return ow
+}
+
+// replay invokes instanceId again from its log only
+func replay(t *testing.T, fe *localfaas.Environment, funcName string, instanceId string, input interface{}) OutputWrapper {
+	t.Helper()
+	ResetIntentFsmCache()
+	return invoke(t, fe, funcName, instanceId, input)
+}
+
+// crash invokes instanceId of funcName expecting it to crash, and returns
+// the crash error
+func crash(t *testing.T, fe *localfaas.Environment, funcName string, instanceId string, input interface{}) error {
+	t.Helper()
+	ResetIntentFsmCache()
+	iw := InputWrapper{
+		InstanceId: instanceId,
+		Input:      input,
+	}
+	res, err := fe.InvokeFunc(context.Background(), funcName, iw.Serialize())
+	fe.Wait()
+	if err == nil {
+		t.Fatalf("Invoke %s of %s did not crash: %s", instanceId, funcName, res)
+	}
+	return err
+}
+
+func expectFailure(t *testing.T, ow OutputWrapper, code string) {
+	t.Helper()
+	if ow.Status != "Failure" || ow.Error == nil || ow.Error.Code != code {
+		t.Fatalf("Expected failure %s, have %+v", code, ow)
+	}
+}
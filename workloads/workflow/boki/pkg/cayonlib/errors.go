@@ -0,0 +1,89 @@
+package cayonlib
+
+import (
+	"fmt"
+)
+
+// AppError is an error a workflow function returns to its caller. It is
+// logged as the result of the invocation, so a replay returns it again.
+type AppError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *AppError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func NewAppError(code string, format string, args ...interface{}) *AppError {
+	return &AppError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Fail stops the running function, which then returns the error to its
+// caller
+func Fail(code string, format string, args ...interface{}) {
+	panic(NewAppError(code, format, args...))
+}
+
+// LogMismatchError is the panic of a replay that takes another path than
+// the logged run. It crashes the function instead of failing it, so the
+// non-determinism is not logged as its result and the instance can be
+// replayed again once fixed.
+type LogMismatchError struct {
+	Message string
+}
+
+func (e *LogMismatchError) Error() string {
+	return "LogMismatch: " + e.Message
+}
+
+func logMismatch(format string, args ...interface{}) {
+	panic(&LogMismatchError{Message: fmt.Sprintf(format, args...)})
+}
+
+func toAppError(err error) *AppError {
+	if appErr, ok := err.(*AppError); ok {
+		return appErr
+	}
+	return &AppError{Code: "Error", Message: err.Error()}
+}
+
+func decodeAppError(raw interface{}) *AppError {
+	if raw == nil {
+		return nil
+	}
+	var appErr AppError
+	DecodeValue(raw, &appErr)
+	return &appErr
+}
+
+// runFunction runs f, which fails if it returns an error or panics with an
+// *AppError, and is suspended if it panics with a *suspension. Other panics,
+// a *LogMismatchError among them, crash the function as before.
+func runFunction(f func(*Env) interface{}, env *Env) (output interface{}, appErr *AppError, suspended *suspension) {
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := r.(*AppError); ok {
+				output, appErr = nil, err
+				return
+			}
+			if s, ok := r.(*suspension); ok {
+				output, suspended = nil, s
+				return
+			}
+			panic(r)
+		}
+	}()
+	output = f(env)
+	if appErr, ok := output.(*AppError); ok {
+		// A nil *AppError is no error, though it is a non-nil error value
+		if appErr == nil {
+			return nil, nil, nil
+		}
+		return nil, appErr, nil
+	}
+	if err, ok := output.(error); ok && err != nil {
+		return nil, toAppError(err), nil
+	}
+	return output, nil, nil
+}
@@ -0,0 +1,47 @@
+package cayonlib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestNilAppErrorSucceeds(t *testing.T) {
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"nil": func(env *Env) interface{} {
+			var err *AppError
+			return err
+		},
+		"failing": func(env *Env) interface{} {
+			return NewAppError("Failing", "failed")
+		},
+	})
+	if ow := invoke(t, fe, "nil", "nil", nil); ow.Status != "Success" || ow.Error != nil {
+		t.Fatalf("Expected success, have %+v", ow)
+	}
+	expectFailure(t, invoke(t, fe, "failing", "failing", nil), "Failing")
+}
+
+func TestLogMismatchCrashes(t *testing.T) {
+	stepType := "Before"
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"steps": func(env *Env) interface{} {
+			newLog, intentLog := ProposeNextStep(env, aws.JSONValue{"type": stepType})
+			if !newLog {
+				CheckLogDataField(intentLog, "type", stepType)
+			}
+			return stepType
+		},
+	})
+	invoke(t, fe, "steps", "steps", nil)
+	stepType = "After"
+	if err := crash(t, fe, "steps", "steps", nil); !strings.Contains(err.Error(), "LogMismatch") {
+		t.Fatalf("Expected a LogMismatch crash, have %v", err)
+	}
+	// The mismatch is no result of the instance, which replays once fixed
+	stepType = "Before"
+	if ow := replay(t, fe, "steps", "steps", nil); ow.Status != "Success" || ow.Output != "Before" {
+		t.Fatalf("Expected Before, have %+v", ow)
+	}
+}
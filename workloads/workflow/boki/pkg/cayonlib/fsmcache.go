@@ -0,0 +1,105 @@
+package cayonlib
+
+import (
+	"container/list"
+	"log"
+	"sync"
+)
+
+// IntentFsmCacheSize bounds the number of intent FSMs kept by this process,
+// 0 disables the cache
+var IntentFsmCacheSize = 1024
+
+type IntentFsmCacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Size      int    `json:"size"`
+}
+
+func (s IntentFsmCacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Re-invocations of an instance resume Catch from the tail of its cached
+// FSM. An FSM is taken out of the cache while an invocation uses it, so
+// concurrent invocations of one instance never share it.
+var intentFsms = list.New()
+var intentFsmIndex = map[string]*list.Element{}
+var intentFsmStats = IntentFsmCacheStats{}
+var intentFsmsMutex = sync.Mutex{}
+
+func getOrCreateIntentFsm(instanceId string) *IntentFsm {
+	intentFsmsMutex.Lock()
+	defer intentFsmsMutex.Unlock()
+	var fsm *IntentFsm
+	if e, exists := intentFsmIndex[instanceId]; exists {
+		intentFsms.Remove(e)
+		delete(intentFsmIndex, instanceId)
+		intentFsmStats.Hits++
+		fsm = e.Value.(*IntentFsm)
+	} else {
+		intentFsmStats.Misses++
+		fsm = NewIntentFsm(instanceId)
+	}
+	if n := intentFsmStats.Hits + intentFsmStats.Misses; n%1000 == 0 {
+		log.Printf("[INFO] Intent FSM cache: %d lookups, hit rate %.3f", n, intentFsmStats.HitRate())
+	}
+	return fsm
+}
+
+func storeBackIntentFsm(fsm *IntentFsm) {
+	if IntentFsmCacheSize <= 0 {
+		return
+	}
+	intentFsmsMutex.Lock()
+	defer intentFsmsMutex.Unlock()
+	if e, exists := intentFsmIndex[fsm.instanceId]; exists {
+		current := e.Value.(*IntentFsm)
+		if current.tail != nil && (fsm.tail == nil || current.tail.SeqNum >= fsm.tail.SeqNum) {
+			intentFsms.MoveToFront(e)
+			return
+		}
+		e.Value = fsm
+		intentFsms.MoveToFront(e)
+		return
+	}
+	intentFsmIndex[fsm.instanceId] = intentFsms.PushFront(fsm)
+	for intentFsms.Len() > IntentFsmCacheSize {
+		e := intentFsms.Back()
+		intentFsms.Remove(e)
+		delete(intentFsmIndex, e.Value.(*IntentFsm).instanceId)
+		intentFsmStats.Evictions++
+	}
+}
+
+// dropIntentFsm forgets the FSM of an instance whose stream was trimmed
+func dropIntentFsm(instanceId string) {
+	intentFsmsMutex.Lock()
+	defer intentFsmsMutex.Unlock()
+	if e, exists := intentFsmIndex[instanceId]; exists {
+		intentFsms.Remove(e)
+		delete(intentFsmIndex, instanceId)
+	}
+}
+
+func GetIntentFsmCacheStats() IntentFsmCacheStats {
+	intentFsmsMutex.Lock()
+	defer intentFsmsMutex.Unlock()
+	stats := intentFsmStats
+	stats.Size = intentFsms.Len()
+	return stats
+}
+
+// ResetIntentFsmCache empties the cache, for tests running several shared
+// logs in one process
+func ResetIntentFsmCache() {
+	intentFsmsMutex.Lock()
+	defer intentFsmsMutex.Unlock()
+	intentFsms.Init()
+	intentFsmIndex = map[string]*list.Element{}
+	intentFsmStats = IntentFsmCacheStats{}
+}
@@ -0,0 +1,85 @@
+package cayonlib
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Future is the result of an asynchronous invocation, which the callee logs
+// as the result of the invoking step
+type Future struct {
+	InstanceId string
+	stepNumber int32
+}
+
+// Await polls for the result every awaitRetryInterval at first, doubling
+// the interval up to awaitMaxRetryInterval
+var awaitRetryInterval = 10 * time.Millisecond
+var awaitMaxRetryInterval = time.Second
+
+// Await waits up to timeout for the result of the invocation. Whether the
+// result arrived in time is logged as a step, so a replay times out iff the
+// original run did. It returns the *AppError the callee failed with, or one
+// with code Timeout.
+func (f *Future) Await(env *Env, timeout time.Duration) (interface{}, error) {
+	step := env.StepNumber
+	newLog := false
+	intentLog := env.Fsm.GetStepLog(step)
+	if intentLog != nil {
+		env.StepNumber += 1
+	} else {
+		deadline := time.Now().Add(timeout)
+		resultLog := FetchStepResultLog(env, f.stepNumber, true /* catch */)
+		interval := awaitRetryInterval
+		for resultLog == nil {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				break
+			}
+			if interval > remaining {
+				interval = remaining
+			}
+			time.Sleep(interval)
+			if interval *= 2; interval > awaitMaxRetryInterval {
+				interval = awaitMaxRetryInterval
+			}
+			resultLog = FetchStepResultLog(env, f.stepNumber, true /* catch */)
+		}
+		newLog, intentLog = ProposeNextStep(env, aws.JSONValue{
+			"type":  "Await",
+			"step":  f.stepNumber,
+			"ready": resultLog != nil,
+		})
+	}
+	if !newLog {
+		CheckLogDataField(intentLog, "type", "Await")
+		log.Printf("[INFO] Seen Await log for step %d", intentLog.StepNumber)
+	}
+	if ready, _ := intentLog.Data["ready"].(bool); !ready {
+		return nil, NewAppError("Timeout", "Instance %s did not finish in %v", f.InstanceId, timeout)
+	}
+	resultLog := FetchStepResultLog(env, f.stepNumber, true /* catch */)
+	if resultLog == nil {
+		panic(fmt.Sprintf("Cannot find result log for step %d", f.stepNumber))
+	}
+	CheckLogDataField(resultLog, "type", "InvokeResult")
+	if appErr := decodeAppError(resultLog.Data["error"]); appErr != nil {
+		return nil, appErr
+	}
+	return resultLog.Data["output"], nil
+}
+
+// AwaitInto is Await decoding the result into resp, which is a pointer or nil
+func (f *Future) AwaitInto(env *Env, timeout time.Duration, resp interface{}) error {
+	output, err := f.Await(env, timeout)
+	if err != nil {
+		return err
+	}
+	if resp != nil {
+		DecodeValue(output, resp)
+	}
+	return nil
+}
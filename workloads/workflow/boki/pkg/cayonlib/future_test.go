@@ -0,0 +1,65 @@
+package cayonlib
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAwait(t *testing.T) {
+	var calls int32
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"callee": func(env *Env) interface{} {
+			atomic.AddInt32(&calls, 1)
+			if env.Input == "fail" {
+				return NewAppError("Failing", "failed")
+			}
+			return env.Input
+		},
+		"caller": func(env *Env) interface{} {
+			ok := AsyncInvoke(env, "callee", "hello")
+			failing := AsyncInvoke(env, "callee", "fail")
+			output, err := ok.Await(env, time.Second)
+			CHECK(err)
+			if _, err := failing.Await(env, time.Second); err == nil || err.(*AppError).Code != "Failing" {
+				t.Errorf("Expected failure Failing, have %v", err)
+			}
+			return output
+		},
+	})
+	if ow := invoke(t, fe, "caller", "caller", nil); ow.Status != "Success" || ow.Output != "hello" {
+		t.Fatalf("Expected hello, have %+v", ow)
+	}
+	if ow := replay(t, fe, "caller", "caller", nil); ow.Status != "Success" || ow.Output != "hello" {
+		t.Fatalf("Expected hello on replay, have %+v", ow)
+	}
+	if calls != 2 {
+		t.Fatalf("Expected 2 calls, have %d", calls)
+	}
+}
+
+func TestAwaitTimeoutReplays(t *testing.T) {
+	release := make(chan struct{})
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"callee": func(env *Env) interface{} {
+			<-release
+			return 0
+		},
+		"caller": func(env *Env) interface{} {
+			future := AsyncInvoke(env, "callee", nil)
+			_, err := future.Await(env, 20*time.Millisecond)
+			if release != nil {
+				close(release)
+				release = nil
+			}
+			return err
+		},
+	})
+	expectFailure(t, invoke(t, fe, "caller", "caller", nil), "Timeout")
+	// The callee finished since, the replay still times out
+	start := time.Now()
+	expectFailure(t, replay(t, fe, "caller", "caller", nil), "Timeout")
+	if elapsed := time.Since(start); elapsed >= 20*time.Millisecond {
+		t.Fatalf("Replay waited again for %v", elapsed)
+	}
+}
@@ -0,0 +1,260 @@
+package cayonlib
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Trimmer is implemented by environments whose shared log supports trimming.
+// The Go API of Boki has no trim yet, only localfaas implements it, so GC
+// runs in tests only. On Boki, lock FSMs stay bounded through the states
+// they save in aux data instead, see LockCheckpointInterval.
+type Trimmer interface {
+	SharedLogTrim(ctx context.Context, tag uint64, seqNum uint64) error
+}
+
+func LibTrimLog(env *Env, tag uint64, seqNum uint64) bool {
+	trimmer, ok := env.FaasEnv.(Trimmer)
+	if !ok {
+		return false
+	}
+	CHECK(trimmer.SharedLogTrim(env.FaasCtx, tag, seqNum))
+	return true
+}
+
+func readLogs(env *Env, tag uint64, seqNum uint64, f func(seqNum uint64, data []byte)) {
+	FlushLogs(env)
+	for {
+		logEntry, err := env.FaasEnv.SharedLogReadNext(env.FaasCtx, tag, seqNum)
+		CHECK(err)
+		if logEntry == nil {
+			break
+		}
+		for _, decoded := range decodeLogEntry(logEntry, tag) {
+			f(logEntry.SeqNum, decoded)
+		}
+		seqNum = logEntry.SeqNum + 1
+	}
+}
+
+func scanIntentLog(env *Env, f func(seqNum uint64, record aws.JSONValue)) {
+	readLogs(env, IntentLogTag, 0, func(seqNum uint64, data []byte) {
+		var record aws.JSONValue
+		CHECK(decodeRecord(data, &record))
+		f(seqNum, record)
+	})
+}
+
+func trimStream(env *Env, tag uint64) {
+	tail, err := env.FaasEnv.SharedLogCheckTail(env.FaasCtx, tag)
+	CHECK(err)
+	if tail != nil {
+		LibTrimLog(env, tag, tail.SeqNum+1)
+	}
+}
+
+type doneRecord struct {
+	seqNum      uint64
+	instanceId  string
+	lambdaId    string
+	txnId       string
+	instruction string
+	ts          int64
+}
+
+// instanceId -> seqnum of the DONE record already collected by this process
+var gcCollected = map[string]uint64{}
+var gcMutex = sync.Mutex{}
+
+func checkpointLock(env *Env, lockId string) {
+	fsm := getOrCreateLockFsm(lockId)
+	fsm.catch(env)
+	defer storeBackLockFsm(fsm)
+	if fsm.tail == nil {
+		return
+	}
+	tag := LockStreamTag(lockId)
+	if fsm.checkpoint != 0 && fsm.tail.SeqNum == fsm.checkpoint {
+		LibTrimLog(env, tag, fsm.checkpoint)
+		return
+	}
+	checkpoint := *fsm.tail
+	checkpoint.StepNumber = fsm.stepNumber
+	checkpoint.Checkpoint = true
+	seqNum := LibAppendLog(env, tag, &checkpoint)
+	fsm.catch(env)
+	if fsm.checkpoint == seqNum {
+		LibTrimLog(env, tag, seqNum)
+	} else {
+		log.Printf("[WARN] Checkpoint of lock %s raced with a lock op", lockId)
+	}
+}
+
+func collectTxn(env *Env, lambdaId string, txnId string, visited map[string]bool) []string {
+	if visited[lambdaId] {
+		return nil
+	}
+	visited[lambdaId] = true
+	tag := TransactionStreamTag(lambdaId, txnId)
+	lockIds := make([]string, 0)
+	callees := make([]string, 0)
+	readLogs(env, tag, 0, func(seqNum uint64, data []byte) {
+		var txnLog TxnLogEntry
+		CHECK(decodeRecord(data, &txnLog))
+		if txnLog.LambdaId != lambdaId || txnLog.TxnId != txnId {
+			return
+		}
+		if txnLog.Callee != "" {
+			callees = append(callees, txnLog.Callee)
+		} else if len(txnLog.WriteOp) > 0 {
+			lockId := fmt.Sprintf("%s-%s", txnLog.WriteOp["tablename"].(string), txnLog.WriteOp["key"].(string))
+			lockIds = append(lockIds, lockId)
+		}
+	})
+	// Participants of a finished transaction are done as well
+	for _, callee := range callees {
+		lockIds = append(lockIds, collectTxn(env, callee, txnId, visited)...)
+	}
+	trimStream(env, tag)
+	return lockIds
+}
+
+// writtenKeys returns the keys written by the steps of an instance
+func writtenKeys(env *Env, instanceId string) []SnapshotKey {
+	keys := make([]SnapshotKey, 0)
+	seen := make(map[SnapshotKey]bool)
+	add := func(table interface{}, key interface{}) {
+		k := SnapshotKey{}
+		k.Table, _ = table.(string)
+		k.Key, _ = key.(string)
+		if k.Table != "" && !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	readLogs(env, IntentStepStreamTag(instanceId), 0, func(seqNum uint64, data []byte) {
+		var intentLog IntentLogEntry
+		CHECK(decodeRecord(data, &intentLog))
+		switch intentLog.Data["type"] {
+		case "PreWrite":
+			add(intentLog.Data["table"], intentLog.Data["key"])
+		case "OCCCommit":
+			writes, _ := intentLog.Data["writes"].([]interface{})
+			for _, write := range writes {
+				if w, ok := write.(map[string]interface{}); ok {
+					add(w["table"], w["key"])
+				}
+			}
+		}
+	})
+	return keys
+}
+
+// trimKeyStream trims the records of key that SnapshotRead no longer reads:
+// it starts after the VERSION of key, and records of the collected
+// instance are older than before
+func trimKeyStream(env *Env, key SnapshotKey, before uint64) {
+	item := LibRead(key.Table, aws.JSONValue{"K": key.Key}, []string{"VERSION"})
+	version, ok := LogInt(item["VERSION"])
+	if !ok {
+		return
+	}
+	if uint64(version)+1 < before {
+		before = uint64(version) + 1
+	}
+	LibTrimLog(env, KeyStreamTag(key.Table, key.Key), before)
+}
+
+func collectInstance(env *Env, record *doneRecord) {
+	for _, key := range writtenKeys(env, record.instanceId) {
+		trimKeyStream(env, key, record.seqNum)
+	}
+	trimStream(env, IntentStepStreamTag(record.instanceId))
+	trimStream(env, InstanceDoneStreamTag(record.instanceId))
+	dropIntentFsm(record.instanceId)
+	if record.lambdaId == "" {
+		return
+	}
+	// Coordinators use their instance id as txn id, participants learn
+	// the outcome through a COMMIT or ABORT instance
+	lockIds := collectTxn(env, record.lambdaId, record.instanceId, make(map[string]bool))
+	trimStream(env, TxnDecisionStreamTag(record.instanceId))
+	if record.txnId != "" && (record.instruction == "COMMIT" || record.instruction == "ABORT") {
+		lockIds = append(lockIds, collectTxn(env, record.lambdaId, record.txnId, make(map[string]bool))...)
+	}
+	for _, lockId := range lockIds {
+		checkpointLock(env, lockId)
+	}
+}
+
+// GC trims the intent, transaction, key and lock streams of instances that
+// finished more than T seconds ago, then trims IntentLogTag below the
+// oldest record still needed by unfinished instances. It does nothing but
+// warn unless env.FaasEnv is a Trimmer.
+func GC(env *Env) {
+	if _, ok := env.FaasEnv.(Trimmer); !ok {
+		log.Printf("[WARN] Shared log of this environment cannot be trimmed")
+		return
+	}
+	gcMutex.Lock()
+	defer gcMutex.Unlock()
+
+	safe := uint64(0)
+	started := make(map[string]uint64)
+	done := make([]*doneRecord, 0)
+	scanIntentLog(env, func(seqNum uint64, record aws.JSONValue) {
+		instanceId := record["InstanceId"].(string)
+		if finished, ok := record["DONE"].(bool); ok && finished {
+			delete(started, instanceId)
+			item := &doneRecord{seqNum: seqNum, instanceId: instanceId}
+			item.lambdaId, _ = record["LambdaId"].(string)
+			item.txnId, _ = record["TxnId"].(string)
+			item.instruction, _ = record["Instruction"].(string)
+			item.ts, _ = LogInt(record["TS"])
+			done = append(done, item)
+		} else if _, exists := started[instanceId]; !exists {
+			started[instanceId] = seqNum
+		}
+		safe = seqNum + 1
+	})
+	for _, seqNum := range started {
+		if seqNum < safe {
+			safe = seqNum
+		}
+	}
+
+	deadline := time.Now().Unix() - T
+	collected := 0
+	for _, record := range done {
+		if _, running := started[record.instanceId]; running {
+			continue
+		}
+		if record.ts > deadline {
+			if record.seqNum < safe {
+				safe = record.seqNum
+			}
+			continue
+		}
+		if _, exists := gcCollected[record.instanceId]; exists {
+			continue
+		}
+		collectInstance(env, record)
+		gcCollected[record.instanceId] = record.seqNum
+		collected++
+	}
+
+	if safe > 0 {
+		LibTrimLog(env, IntentLogTag, safe)
+	}
+	for instanceId, seqNum := range gcCollected {
+		if seqNum < safe {
+			delete(gcCollected, instanceId)
+		}
+	}
+	log.Printf("[INFO] GC collected %d instances, intent log trimmed below %d", collected, safe)
+}
@@ -0,0 +1,109 @@
+package cayonlib
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"cs.utexas.edu/zjia/faas/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/eniac/Beldi/pkg/localfaas"
+)
+
+// countingEnv counts the shared log reads
+type countingEnv struct {
+	*localfaas.Environment
+	reads int
+}
+
+func (e *countingEnv) SharedLogReadNext(ctx context.Context, tag uint64, seqNum uint64) (*types.LogEntry, error) {
+	e.reads++
+	return e.Environment.SharedLogReadNext(ctx, tag, seqNum)
+}
+
+func (e *countingEnv) SharedLogReadPrev(ctx context.Context, tag uint64, seqNum uint64) (*types.LogEntry, error) {
+	e.reads++
+	return e.Environment.SharedLogReadPrev(ctx, tag, seqNum)
+}
+
+// dropLockFsms makes the next lock ops start as in a fresh process
+func dropLockFsms() {
+	lockFsmsMutex.Lock()
+	lockFsms = map[string]LockFsm{}
+	lockFsmsMutex.Unlock()
+}
+
+func TestLockFsmResumesFromSavedState(t *testing.T) {
+	fe := &countingEnv{Environment: localfaas.NewEnvironment()}
+	env := &Env{LambdaId: "test", FaasCtx: context.Background(), FaasEnv: fe}
+	dropLockFsms()
+	defer dropLockFsms()
+	n := 5 * LockCheckpointInterval
+	for i := 0; i < n; i++ {
+		env.TxnId = fmt.Sprintf("txn%d", i)
+		if !Lock(env, "table", "key") {
+			t.Fatalf("Failed to lock with %s", env.TxnId)
+		}
+		Unlock(env, "table", "key")
+	}
+	env.TxnId = "last"
+	if !Lock(env, "table", "key") {
+		t.Fatalf("Failed to lock with %s", env.TxnId)
+	}
+
+	dropLockFsms()
+	fe.reads = 0
+	fsm := getOrCreateLockFsm("table-key")
+	fsm.catch(env)
+	if fsm.holder() != "last" || fsm.stepNumber != int32(2*n+1) {
+		t.Fatalf("Expected step %d held by last, have step %d held by %q", 2*n+1, fsm.stepNumber, fsm.holder())
+	}
+	if fe.reads > LockCheckpointInterval+2 {
+		t.Fatalf("Catching up read %d entries of %d", fe.reads, 2*n+1)
+	}
+}
+
+func TestGCTrimsFinishedInstances(t *testing.T) {
+	timeout := T
+	T = 0
+	defer func() {
+		T = timeout
+	}()
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"steps": func(env *Env) interface{} {
+			ProposeNextStep(env, aws.JSONValue{"type": "Noop"})
+			return 0
+		},
+		"sleeper": func(env *Env) interface{} {
+			ProposeNextStep(env, aws.JSONValue{"type": "Noop"})
+			Sleep(env, time.Hour)
+			return 0
+		},
+		"gc": func(env *Env) interface{} {
+			GC(env)
+			return 0
+		},
+	})
+	invoke(t, fe, "steps", "done", nil)
+	iw := InputWrapper{InstanceId: "suspended", Async: true}
+	fe.InvokeFuncAsync(context.Background(), "sleeper", iw.Serialize())
+	fe.Wait()
+	invoke(t, fe, "gc", "gc", nil)
+
+	ctx := context.Background()
+	if entry, _ := fe.SharedLogReadNext(ctx, IntentStepStreamTag("done"), 0); entry != nil {
+		t.Errorf("Steps of a finished instance were kept")
+	}
+	if entry, _ := fe.SharedLogReadNext(ctx, IntentStepStreamTag("suspended"), 0); entry == nil {
+		t.Errorf("Steps of a suspended instance were trimmed")
+	}
+	// The intent log is kept from the start of the suspended instance on
+	var instances []string
+	scanIntentLog(&Env{FaasCtx: ctx, FaasEnv: fe}, func(seqNum uint64, record aws.JSONValue) {
+		instances = append(instances, record["InstanceId"].(string))
+	})
+	if len(instances) == 0 || instances[0] != "suspended" {
+		t.Errorf("Expected the intent log to start with the suspended instance, have %v", instances)
+	}
+}
@@ -5,10 +5,14 @@ import (
 )
 
 const IntentLogTag             uint64 = 1
+const TimerLogTag              uint64 = (1 << 3) + 1
 
 const intentStepStreamLowBits  uint64 = 2
 const lockStreamLowBits        uint64 = 3
 const transactionStreamLowBits uint64 = 4
+const txnDecisionStreamLowBits uint64 = 5
+const keyStreamLowBits         uint64 = 6
+const instanceDoneStreamLowBits uint64 = 7
 
 func IntentStepStreamTag(instanceId string) uint64 {
 	h := xxhash.Sum64String(instanceId)
@@ -36,3 +40,30 @@ func LockStreamTag(lockId string) uint64 {
 	}
 	return tag
 }
+
+func TxnDecisionStreamTag(txnId string) uint64 {
+	h := xxhash.Sum64String(txnId)
+	tag := (h << 3) + txnDecisionStreamLowBits
+	if tag == 0 || (^tag) == 0 {
+		panic("Invalid tag")
+	}
+	return tag
+}
+
+func KeyStreamTag(tablename string, key string) uint64 {
+	h := xxhash.Sum64String(tablename + "-" + key)
+	tag := (h << 3) + keyStreamLowBits
+	if tag == 0 || (^tag) == 0 {
+		panic("Invalid tag")
+	}
+	return tag
+}
+
+func InstanceDoneStreamTag(instanceId string) uint64 {
+	h := xxhash.Sum64String(instanceId)
+	tag := (h << 3) + instanceDoneStreamLowBits
+	if tag == 0 || (^tag) == 0 {
+		panic("Invalid tag")
+	}
+	return tag
+}
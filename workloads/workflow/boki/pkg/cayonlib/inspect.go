@@ -0,0 +1,199 @@
+package cayonlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/mitchellh/mapstructure"
+)
+
+// StepHistory is a logged step of an instance, with its result if any.
+// Child is the history of the instance invoked by the step.
+type StepHistory struct {
+	Step     int32            `json:"step"`
+	Data     aws.JSONValue    `json:"data"`
+	Ts       int64            `json:"ts,omitempty"`
+	Result   aws.JSONValue    `json:"result,omitempty"`
+	ResultTs int64            `json:"resultTs,omitempty"`
+	Child    *InstanceHistory `json:"child,omitempty"`
+}
+
+// InstanceHistory is reconstructed from the intent log and the intent
+// step stream of an instance. Start and End are in seconds.
+type InstanceHistory struct {
+	InstanceId string         `json:"instanceId"`
+	LambdaId   string         `json:"lambdaId,omitempty"`
+	Input      interface{}    `json:"input,omitempty"`
+	Start      int64          `json:"start,omitempty"`
+	End        int64          `json:"end,omitempty"`
+	Done       bool           `json:"done"`
+	Suspended  bool           `json:"suspended,omitempty"`
+	Error      *AppError      `json:"error,omitempty"`
+	Steps      []*StepHistory `json:"steps"`
+}
+
+// childInstance returns the callee and the instance id invoked by a step
+func childInstance(data aws.JSONValue) (string, string) {
+	callee, _ := data["callee"].(string)
+	switch data["type"] {
+	case "PreInvoke":
+		instanceId, _ := data["instanceId"].(string)
+		return callee, instanceId
+	case "ScheduleAt":
+		var iw InputWrapper
+		if mapstructure.Decode(data["wrapper"], &iw) == nil {
+			return callee, iw.InstanceId
+		}
+	}
+	return "", ""
+}
+
+func inspectInstance(env *Env, instanceId string, lambdaId string,
+	records map[string][]aws.JSONValue, visited map[string]bool) *InstanceHistory {
+	h := &InstanceHistory{
+		InstanceId: instanceId,
+		LambdaId:   lambdaId,
+		Steps:      make([]*StepHistory, 0),
+	}
+	if visited[instanceId] {
+		return h
+	}
+	visited[instanceId] = true
+	for _, record := range records[instanceId] {
+		if tmp, ok := record["LambdaId"].(string); ok && tmp != "" {
+			h.LambdaId = tmp
+		}
+		if st, ok := LogInt(record["ST"]); ok {
+			h.Start = st
+			h.Input = record["INPUT"]
+		}
+		if suspended, _ := record["SUSPENDED"].(bool); suspended {
+			h.Suspended = true
+		}
+		if done, _ := record["DONE"].(bool); done {
+			h.Done = true
+			h.Suspended = false
+			h.End, _ = LogInt(record["TS"])
+			h.Error = decodeAppError(record["ERROR"])
+		}
+	}
+
+	steps := make(map[int32]*StepHistory)
+	readLogs(env, IntentStepStreamTag(instanceId), 0, func(seqNum uint64, data []byte) {
+		var intentLog IntentLogEntry
+		CHECK(decodeRecord(data, &intentLog))
+		if intentLog.InstanceId != instanceId {
+			return
+		}
+		step, exists := steps[intentLog.StepNumber]
+		if !exists {
+			step = &StepHistory{Step: intentLog.StepNumber}
+			steps[intentLog.StepNumber] = step
+		}
+		if intentLog.PostStep {
+			if step.Result == nil {
+				step.Result = intentLog.Data
+				step.ResultTs = intentLog.Ts
+			}
+		} else if step.Data == nil {
+			step.Data = intentLog.Data
+			step.Ts = intentLog.Ts
+		}
+	})
+	for _, step := range steps {
+		h.Steps = append(h.Steps, step)
+	}
+	sort.Slice(h.Steps, func(i, j int) bool { return h.Steps[i].Step < h.Steps[j].Step })
+	for _, step := range h.Steps {
+		if callee, childId := childInstance(step.Data); childId != "" {
+			step.Child = inspectInstance(env, childId, callee, records, visited)
+		}
+	}
+	return h
+}
+
+// InspectInstance reconstructs the history of instanceId, following the
+// instances it invoked recursively. Streams already trimmed by GC are lost.
+func InspectInstance(env *Env, instanceId string) *InstanceHistory {
+	records := make(map[string][]aws.JSONValue)
+	scanIntentLog(env, func(seqNum uint64, record aws.JSONValue) {
+		if id, ok := record["InstanceId"].(string); ok {
+			records[id] = append(records[id], record)
+		}
+	})
+	return inspectInstance(env, instanceId, "", records, make(map[string]bool))
+}
+
+func formatFields(data aws.JSONValue, skip ...string) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fields := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if k == "type" || k == "wrapper" {
+			continue
+		}
+		skipped := false
+		for _, s := range skip {
+			skipped = skipped || k == s
+		}
+		if skipped {
+			continue
+		}
+		v, err := json.Marshal(data[k])
+		CHECK(err)
+		s := string(v)
+		if len(s) > 64 {
+			s = s[:61] + "..."
+		}
+		fields = append(fields, fmt.Sprintf("%s=%s", k, s))
+	}
+	return strings.Join(fields, " ")
+}
+
+func (h *InstanceHistory) render(w io.Writer, indent string) {
+	status := "RUNNING"
+	if h.Error != nil {
+		status = "FAILED " + h.Error.Error()
+	} else if h.Done {
+		status = "DONE"
+	} else if h.Suspended {
+		status = "SUSPENDED"
+	} else if h.Start == 0 && len(h.Steps) == 0 {
+		status = "NOT FOUND"
+	}
+	fmt.Fprintf(w, "%s%s %s %s", indent, h.LambdaId, h.InstanceId, status)
+	if h.Start != 0 {
+		fmt.Fprintf(w, " started=%s", time.Unix(h.Start, 0).Format(time.RFC3339))
+		if h.End != 0 {
+			fmt.Fprintf(w, " took=%ds", h.End-h.Start)
+		}
+	}
+	fmt.Fprintln(w)
+	for _, step := range h.Steps {
+		stepType, _ := step.Data["type"].(string)
+		fmt.Fprintf(w, "%s  #%d %s %s", indent, step.Step, stepType, formatFields(step.Data, "instanceId"))
+		if step.Result != nil {
+			fmt.Fprintf(w, " -> %s", formatFields(step.Result))
+			if step.Ts != 0 && step.ResultTs != 0 {
+				fmt.Fprintf(w, " (%dms)", step.ResultTs-step.Ts)
+			}
+		}
+		fmt.Fprintln(w)
+		if step.Child != nil {
+			step.Child.render(w, indent+"    ")
+		}
+	}
+}
+
+// Render writes the call tree of h, one line per instance and step
+func (h *InstanceHistory) Render(w io.Writer) {
+	h.render(w, "")
+}
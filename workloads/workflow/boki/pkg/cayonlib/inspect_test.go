@@ -0,0 +1,67 @@
+package cayonlib
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestInspectInstance(t *testing.T) {
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"parent": func(env *Env) interface{} {
+			ProposeNextStep(env, aws.JSONValue{"type": "Noop"})
+			_, _, err := TrySyncInvoke(env, "child", "input")
+			return err
+		},
+		"child": func(env *Env) interface{} {
+			return NewAppError("Oops", "child failed")
+		},
+		"sleeper": func(env *Env) interface{} {
+			Sleep(env, time.Hour)
+			return 0
+		},
+	})
+	invoke(t, fe, "parent", "parent", "hello")
+	iw := InputWrapper{InstanceId: "sleeper", Async: true}
+	if err := fe.InvokeFuncAsync(context.Background(), "sleeper", iw.Serialize()); err != nil {
+		t.Fatal(err)
+	}
+	fe.Wait()
+
+	env := &Env{FaasCtx: context.Background(), FaasEnv: fe}
+	h := InspectInstance(env, "parent")
+	if !h.Done || h.LambdaId != "parent" || h.Input != "hello" || h.Error == nil || h.Error.Code != "Oops" {
+		t.Fatalf("Unexpected parent %+v", h)
+	}
+	if len(h.Steps) != 2 || h.Steps[0].Data["type"] != "Noop" || h.Steps[1].Data["type"] != "PreInvoke" {
+		t.Fatalf("Unexpected steps %+v", h.Steps)
+	}
+	invoked := h.Steps[1]
+	if invoked.Result["type"] != "InvokeResult" || invoked.Child == nil {
+		t.Fatalf("Unexpected invocation %+v", invoked)
+	}
+	if child := invoked.Child; !child.Done || child.LambdaId != "child" || child.Input != "input" ||
+		child.Error == nil || child.Error.Code != "Oops" {
+		t.Fatalf("Unexpected child %+v", child)
+	}
+	var out bytes.Buffer
+	h.Render(&out)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "parent parent FAILED Oops") ||
+		!strings.HasPrefix(lines[3], "    child ") {
+		t.Fatalf("Unexpected rendering:\n%s", out.String())
+	}
+
+	if h := InspectInstance(env, "sleeper"); h.Done || !h.Suspended {
+		t.Fatalf("Expected a suspended instance, have %+v", h)
+	}
+	out.Reset()
+	InspectInstance(env, "missing").Render(&out)
+	if !strings.Contains(out.String(), "NOT FOUND") {
+		t.Fatalf("Expected a missing instance, have %s", out.String())
+	}
+}
@@ -1,13 +1,13 @@
 package cayonlib
 
 import (
-	"fmt"
-	// "log"
 	"encoding/json"
-	// "cs.utexas.edu/zjia/faas/types"
+	"fmt"
+	"sync"
+
+	"cs.utexas.edu/zjia/faas/types"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/golang/snappy"
-	// "context"
 )
 
 type IntentLogEntry struct {
@@ -16,6 +16,7 @@ type IntentLogEntry struct {
 	StepNumber int32         `json:"step"`
 	PostStep   bool          `json:"postStep"`
 	Data       aws.JSONValue `json:"data"`
+	Ts         int64         `json:"ts,omitempty"`
 }
 
 type IntentFsm struct {
@@ -55,6 +56,7 @@ func (fsm *IntentFsm) applyLog(intentLog *IntentLogEntry) {
 }
 
 func (fsm *IntentFsm) Catch(env *Env) {
+	FlushLogs(env)
 	tag := IntentStepStreamTag(fsm.instanceId)
 	seqNum := uint64(0)
 	if fsm.tail != nil {
@@ -66,15 +68,15 @@ func (fsm *IntentFsm) Catch(env *Env) {
 		if logEntry == nil {
 			break
 		}
-		decoded, err := snappy.Decode(nil, logEntry.Data)
-		CHECK(err)
-		var intentLog IntentLogEntry
-		err = json.Unmarshal(decoded, &intentLog)
-		CHECK(err)
-		if intentLog.InstanceId == fsm.instanceId {
-			// log.Printf("[INFO] Found my log: seqnum=%d, step=%d", logEntry.SeqNum, intentLog.StepNumber)
-			intentLog.SeqNum = logEntry.SeqNum
-			fsm.applyLog(&intentLog)
+		for _, decoded := range decodeLogEntry(logEntry, tag) {
+			var intentLog IntentLogEntry
+			err = decodeRecord(decoded, &intentLog)
+			CHECK(err)
+			if intentLog.InstanceId == fsm.instanceId {
+				// log.Printf("[INFO] Found my log: seqnum=%d, step=%d", logEntry.SeqNum, intentLog.StepNumber)
+				intentLog.SeqNum = logEntry.SeqNum
+				fsm.applyLog(&intentLog)
+			}
 		}
 		seqNum = logEntry.SeqNum + 1
 	}
@@ -96,20 +98,37 @@ func (fsm *IntentFsm) GetPostStepLog(stepNumber int32) *IntentLogEntry {
 	}
 }
 
-func ProposeNextStep(env *Env, data aws.JSONValue) (bool, *IntentLogEntry) {
+// Hooks of the replay checker in package replaycheck, nil otherwise.
+// StepHook runs before a new step is logged, and with the number of steps
+// when the function returns. ReplayHook runs when a logged step is proposed
+// again, with the data proposed this time.
+var StepHook func(env *Env, step int32)
+var ReplayHook func(env *Env, logged *IntentLogEntry, data aws.JSONValue)
+
+// ProposeNextStep appends the intent log of the next step, extraTags also
+// index it in other streams
+func ProposeNextStep(env *Env, data aws.JSONValue, extraTags ...uint64) (bool, *IntentLogEntry) {
 	step := env.StepNumber
 	env.StepNumber += 1
 	intentLog := env.Fsm.GetStepLog(step)
 	if intentLog != nil {
+		if ReplayHook != nil {
+			ReplayHook(env, intentLog, data)
+		}
 		return false, intentLog
 	}
+	if StepHook != nil {
+		StepHook(env, step)
+	}
 	intentLog = &IntentLogEntry{
 		InstanceId: env.InstanceId,
 		StepNumber: step,
 		PostStep:   false,
 		Data:       data,
+		Ts:         nowMs(),
 	}
-	seqNum := LibAppendLog(env, IntentStepStreamTag(env.InstanceId), &intentLog)
+	tags := append([]uint64{IntentStepStreamTag(env.InstanceId)}, extraTags...)
+	seqNum := LibAppendLogWithTags(env, tags, &intentLog)
 	env.Fsm.Catch(env)
 	intentLog = env.Fsm.GetStepLog(step)
 	if intentLog == nil {
@@ -118,13 +137,22 @@ func ProposeNextStep(env *Env, data aws.JSONValue) (bool, *IntentLogEntry) {
 	return seqNum == intentLog.SeqNum, intentLog
 }
 
-func LogStepResult(env *Env, instanceId string, stepNumber int32, data aws.JSONValue) {
-	LibAppendLog(env, IntentStepStreamTag(instanceId), &IntentLogEntry{
+// LogStepResult logs the result of a step. Only the step stream reads it
+// back, so it is deferred, unless extraTags make it visible to others.
+func LogStepResult(env *Env, instanceId string, stepNumber int32, data aws.JSONValue, extraTags ...uint64) {
+	tags := append([]uint64{IntentStepStreamTag(instanceId)}, extraTags...)
+	entry := &IntentLogEntry{
 		InstanceId: instanceId,
 		StepNumber: stepNumber,
 		PostStep:   true,
 		Data:       data,
-	})
+		Ts:         nowMs(),
+	}
+	if len(extraTags) > 0 {
+		LibAppendLogWithTags(env, tags, entry)
+	} else {
+		LibAppendLogDeferred(env, tags, entry)
+	}
 }
 
 func FetchStepResultLog(env *Env, stepNumber int32, catch bool) *IntentLogEntry {
@@ -141,16 +169,133 @@ func FetchStepResultLog(env *Env, stepNumber int32, catch bool) *IntentLogEntry
 }
 
 func LibAppendLog(env *Env, tag uint64, data interface{}) uint64 {
-	serializedData, err := json.Marshal(data)
-	CHECK(err)
-	encoded := snappy.Encode(nil, serializedData)
-	seqNum, err := env.FaasEnv.SharedLogAppend(env.FaasCtx, []uint64{tag}, encoded)
+	return LibAppendLogWithTags(env, []uint64{tag}, data)
+}
+
+func appendEncoded(env *Env, tags []uint64, record []byte) uint64 {
+	encoded := snappy.Encode(nil, record)
+	seqNum, err := env.FaasEnv.SharedLogAppend(env.FaasCtx, tags, encoded)
 	CHECK(err)
 	return seqNum
 }
 
+// LibAppendLogWithTags appends data, together with the records deferred so
+// far in one multi-record entry. The seqnum of that entry is the seqnum of
+// each of its records.
+func LibAppendLogWithTags(env *Env, tags []uint64, data interface{}) uint64 {
+	record := encodeRecord(data)
+	if env.batch != nil {
+		env.batch.mu.Lock()
+		defer env.batch.mu.Unlock()
+		if len(env.batch.records) > 0 {
+			return env.batch.append(env, newBatchRecord(tags, record))
+		}
+	}
+	return appendEncoded(env, tags, record)
+}
+
+// batchMarker starts a multi-record entry, JSON records never start with it
+const batchMarker = byte(0)
+
+// Data of a batch record is a JSON string holding the encoded record, or
+// the record itself if it was written before codecs existed
+type batchRecord struct {
+	Tags []uint64        `json:"tags"`
+	Data json.RawMessage `json:"data"`
+}
+
+func newBatchRecord(tags []uint64, record []byte) batchRecord {
+	data, err := json.Marshal(record)
+	CHECK(err)
+	return batchRecord{Tags: tags, Data: data}
+}
+
+func (r *batchRecord) record() []byte {
+	if len(r.Data) == 0 || r.Data[0] != '"' {
+		return r.Data
+	}
+	var record []byte
+	CHECK(json.Unmarshal(r.Data, &record))
+	return record
+}
+
+// logBatch buffers the records of an instance whose seqnums are not needed,
+// i.e. step results. They are appended with the next record, or before the
+// instance reads the log or returns. Transaction ops are not buffered: a
+// crash must not lose the callee or write a commit or abort has to apply.
+type logBatch struct {
+	mu      sync.Mutex
+	records []batchRecord
+}
+
+func (b *logBatch) append(env *Env, records ...batchRecord) uint64 {
+	records = append(b.records, records...)
+	b.records = nil
+	tags := make([]uint64, 0)
+	seen := make(map[uint64]bool)
+	for _, record := range records {
+		for _, tag := range record.Tags {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	serialized, err := json.Marshal(records)
+	CHECK(err)
+	return appendEncoded(env, tags, append([]byte{batchMarker}, serialized...))
+}
+
+// LibAppendLogDeferred appends data with the next record if batching is
+// enabled, and right away otherwise
+func LibAppendLogDeferred(env *Env, tags []uint64, data interface{}) {
+	if env.batch == nil {
+		LibAppendLogWithTags(env, tags, data)
+		return
+	}
+	record := newBatchRecord(tags, encodeRecord(data))
+	env.batch.mu.Lock()
+	env.batch.records = append(env.batch.records, record)
+	env.batch.mu.Unlock()
+}
+
+// FlushLogs appends the records deferred so far
+func FlushLogs(env *Env) {
+	if env.batch == nil {
+		return
+	}
+	env.batch.mu.Lock()
+	defer env.batch.mu.Unlock()
+	if len(env.batch.records) > 0 {
+		env.batch.append(env)
+	}
+}
+
+// decodeLogEntry returns the records of logEntry read with tag, to be
+// decoded by decodeRecord
+func decodeLogEntry(logEntry *types.LogEntry, tag uint64) [][]byte {
+	decoded, err := snappy.Decode(nil, logEntry.Data)
+	CHECK(err)
+	if len(decoded) == 0 || decoded[0] != batchMarker {
+		return [][]byte{decoded}
+	}
+	var records []batchRecord
+	CHECK(json.Unmarshal(decoded[1:], &records))
+	res := make([][]byte, 0, len(records))
+	for _, record := range records {
+		for _, t := range record.Tags {
+			if t == tag {
+				res = append(res, record.record())
+				break
+			}
+		}
+	}
+	return res
+}
+
 func CheckLogDataField(intentLog *IntentLogEntry, field string, expected string) {
-	if tmp := intentLog.Data[field].(string); tmp != expected {
-		panic(fmt.Sprintf("Field %s mismatch: expected=%s, have=%s", field, expected, tmp))
+	if tmp, _ := intentLog.Data[field].(string); tmp != expected {
+		logMismatch("Field %s mismatch at step %d: expected=%s, have=%s",
+			field, intentLog.StepNumber, expected, tmp)
 	}
 }
@@ -0,0 +1,111 @@
+package cayonlib
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestBatchedRecordsShareAnEntry(t *testing.T) {
+	batching := BATCHING
+	BATCHING = "ENABLE"
+	defer func() {
+		BATCHING = batching
+	}()
+	const tagA, tagB = uint64(1<<10 + 7), uint64(1<<11 + 7)
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"batched": func(env *Env) interface{} {
+			tail := func() uint64 {
+				entry, err := env.FaasEnv.SharedLogCheckTail(env.FaasCtx, 0)
+				CHECK(err)
+				return entry.SeqNum
+			}
+			before := tail()
+			LibAppendLogDeferred(env, []uint64{tagA}, aws.JSONValue{"n": 1})
+			LibAppendLogDeferred(env, []uint64{tagB}, aws.JSONValue{"n": 2})
+			if tail() != before {
+				t.Errorf("Deferred records were appended right away")
+			}
+			seqNum := LibAppendLog(env, tagA, aws.JSONValue{"n": 3})
+			read := func(tag uint64) []int64 {
+				var res []int64
+				readLogs(env, tag, 0, func(s uint64, data []byte) {
+					if s != seqNum {
+						t.Errorf("Record of tag %d at %d, expected %d", tag, s, seqNum)
+					}
+					var record aws.JSONValue
+					CHECK(decodeRecord(data, &record))
+					n, _ := LogInt(record["n"])
+					res = append(res, n)
+				})
+				return res
+			}
+			if a, b := read(tagA), read(tagB); len(a) != 2 || a[0] != 1 || a[1] != 3 || len(b) != 1 || b[0] != 2 {
+				t.Errorf("Expected [1 3] and [2], have %v and %v", a, b)
+			}
+
+			LibAppendLogDeferred(env, []uint64{tagB}, aws.JSONValue{"n": 4})
+			FlushLogs(env)
+			if tail() == seqNum {
+				t.Errorf("FlushLogs did not append the deferred record")
+			}
+			return 0
+		},
+	})
+	if ow := invoke(t, fe, "batched", "batched", nil); ow.Status != "Success" {
+		t.Fatalf("Expected success, have %+v", ow)
+	}
+}
+
+// loggedTxnOps reads the transaction stream of lambdaId without flushing
+// what env deferred
+func loggedTxnOps(env *Env, lambdaId string, txnId string) []*TxnLogEntry {
+	tag := TransactionStreamTag(lambdaId, txnId)
+	res := make([]*TxnLogEntry, 0)
+	for seqNum := uint64(0); ; {
+		logEntry, err := env.FaasEnv.SharedLogReadNext(env.FaasCtx, tag, seqNum)
+		CHECK(err)
+		if logEntry == nil {
+			return res
+		}
+		for _, data := range decodeLogEntry(logEntry, tag) {
+			txnLog := &TxnLogEntry{}
+			CHECK(decodeRecord(data, txnLog))
+			res = append(res, txnLog)
+		}
+		seqNum = logEntry.SeqNum + 1
+	}
+}
+
+func TestTxnOpsAreWrittenAhead(t *testing.T) {
+	batching := BATCHING
+	BATCHING = "ENABLE"
+	defer func() {
+		BATCHING = batching
+	}()
+	dropLockFsms()
+	defer dropLockFsms()
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"caller": func(env *Env) interface{} {
+			BeginTxn(env)
+			if !TPLWrite(env, "table", "key", aws.JSONValue{"V": 1}) {
+				t.Errorf("Failed to lock key")
+			}
+			if ops := loggedTxnOps(env, "caller", env.TxnId); len(ops) != 1 || ops[0].WriteOp["key"] != "key" {
+				t.Errorf("Expected the write in the log, have %+v", ops)
+			}
+			SyncInvoke(env, "callee", nil)
+			AbortTxn(env)
+			return 0
+		},
+		"callee": func(env *Env) interface{} {
+			// The caller logged the invocation before it
+			ops := loggedTxnOps(env, "caller", env.TxnId)
+			if len(ops) != 2 || ops[1].Callee != "callee" {
+				t.Errorf("Expected the callee in the log, have %+v", ops)
+			}
+			return 0
+		},
+	})
+	invoke(t, fe, "caller", "caller", nil)
+}
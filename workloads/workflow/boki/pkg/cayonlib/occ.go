@@ -0,0 +1,298 @@
+package cayonlib
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// OCCRead logs the value and VERSION of key as a step, which is also
+// indexed in the transaction stream for validation at commit
+func OCCRead(env *Env, tablename string, key string) interface{} {
+	tag := TransactionStreamTag(env.LambdaId, env.TxnId)
+	step := env.StepNumber
+	newLog := false
+	intentLog := env.Fsm.GetStepLog(step)
+	if intentLog != nil {
+		env.StepNumber += 1
+	} else {
+		item := LibRead(tablename, aws.JSONValue{"K": key}, []string{"V", "VERSION"})
+		version := uint64(0)
+		if tmp, ok := item["VERSION"].(float64); ok {
+			version = uint64(tmp)
+		}
+		newLog, intentLog = ProposeNextStep(env, aws.JSONValue{
+			"type":     "OCCRead",
+			"key":      key,
+			"table":    tablename,
+			"result":   item["V"],
+			"version":  version,
+			"lambdaId": env.LambdaId,
+			"txnId":    env.TxnId,
+		}, tag)
+	}
+	if !newLog {
+		CheckLogDataField(intentLog, "type", "OCCRead")
+		CheckLogDataField(intentLog, "key", key)
+		CheckLogDataField(intentLog, "table", tablename)
+		log.Printf("[INFO] Seen OCCRead log for step %d", intentLog.StepNumber)
+	}
+	if _, indexed := intentLog.Data["txnId"]; !indexed {
+		// Logged before reads were indexed, with the version recorded apart
+		LibAppendLogWithTags(env, []uint64{tag}, &TxnLogEntry{
+			LambdaId: env.LambdaId,
+			TxnId:    env.TxnId,
+			Callee:   "",
+			ReadOp: aws.JSONValue{
+				"tablename": tablename,
+				"key":       key,
+				"version":   intentLog.Data["version"],
+			},
+		})
+	}
+	return intentLog.Data["result"]
+}
+
+// OCCWrite buffers the write in the transaction stream until commit
+func OCCWrite(env *Env, tablename string, key string, value aws.JSONValue) {
+	LibAppendLogWithTags(env, []uint64{TransactionStreamTag(env.LambdaId, env.TxnId)}, &TxnLogEntry{
+		LambdaId: env.LambdaId,
+		TxnId:    env.TxnId,
+		Callee:   "",
+		WriteOp: aws.JSONValue{
+			"tablename": tablename,
+			"key":       key,
+			"value":     value,
+		},
+	})
+}
+
+type occKey struct {
+	tablename string
+	key       string
+}
+
+type occSet struct {
+	keys     []occKey
+	reads    map[occKey]uint64
+	writes   map[occKey]map[string]interface{}
+	conflict bool
+}
+
+// occMaxKeys is the number of items a DynamoDB transaction takes at most
+const occMaxKeys = 25
+
+func (set *occSet) add(k occKey) {
+	if _, ok := set.reads[k]; ok {
+		return
+	}
+	if _, ok := set.writes[k]; ok {
+		return
+	}
+	set.keys = append(set.keys, k)
+}
+
+func (set *occSet) read(k occKey, version uint64) {
+	set.add(k)
+	if seen, ok := set.reads[k]; ok && seen != version {
+		set.conflict = true
+	}
+	set.reads[k] = version
+}
+
+// gather collects the read and write sets of lambdaId and, recursively,
+// of its callees in the transaction
+func (set *occSet) gather(env *Env, lambdaId string, txnId string, visited map[string]bool) {
+	if visited[lambdaId] {
+		return
+	}
+	visited[lambdaId] = true
+	callees := make([]string, 0)
+	steps := make(map[string]bool)
+	readLogs(env, TransactionStreamTag(lambdaId, txnId), 0, func(seqNum uint64, data []byte) {
+		var txnLog TxnLogEntry
+		CHECK(decodeRecord(data, &txnLog))
+		if txnLog.LambdaId == "" {
+			// An OCCRead step. Only the first proposal of a step won it.
+			var intentLog IntentLogEntry
+			CHECK(decodeRecord(data, &intentLog))
+			step := fmt.Sprintf("%s-%d", intentLog.InstanceId, intentLog.StepNumber)
+			if intentLog.Data["type"] != "OCCRead" || intentLog.Data["lambdaId"] != lambdaId ||
+				intentLog.Data["txnId"] != txnId || steps[step] {
+				return
+			}
+			steps[step] = true
+			version, _ := LogInt(intentLog.Data["version"])
+			set.read(occKey{intentLog.Data["table"].(string), intentLog.Data["key"].(string)}, uint64(version))
+			return
+		}
+		if txnLog.LambdaId != lambdaId || txnLog.TxnId != txnId {
+			return
+		}
+		if txnLog.Callee != "" {
+			callees = append(callees, txnLog.Callee)
+		} else if len(txnLog.ReadOp) > 0 {
+			k := occKey{txnLog.ReadOp["tablename"].(string), txnLog.ReadOp["key"].(string)}
+			version, _ := LogInt(txnLog.ReadOp["version"])
+			set.read(k, uint64(version))
+		} else if len(txnLog.WriteOp) > 0 {
+			k := occKey{txnLog.WriteOp["tablename"].(string), txnLog.WriteOp["key"].(string)}
+			set.add(k)
+			if _, ok := set.writes[k]; !ok {
+				set.writes[k] = make(map[string]interface{})
+			}
+			for kk, vv := range txnLog.WriteOp["value"].(map[string]interface{}) {
+				set.writes[k][kk] = vv
+			}
+		}
+	})
+	for _, callee := range callees {
+		set.gather(env, callee, txnId, visited)
+	}
+}
+
+func (set *occSet) condition(k occKey, commitSeqNum uint64) expression.ConditionBuilder {
+	if version, ok := set.reads[k]; ok {
+		if version == 0 {
+			// Populate writes VERSION 0 into keys no commit wrote yet
+			return expression.Or(
+				expression.AttributeNotExists(expression.Name("VERSION")),
+				expression.Name("VERSION").Equal(expression.Value(0)))
+		}
+		return expression.Name("VERSION").Equal(expression.Value(version))
+	}
+	return expression.Or(
+		expression.AttributeNotExists(expression.Name("VERSION")),
+		expression.Name("VERSION").LessThan(expression.Value(commitSeqNum)))
+}
+
+func (set *occSet) transactItems(commitSeqNum uint64) []*dynamodb.TransactWriteItem {
+	items := make([]*dynamodb.TransactWriteItem, 0, len(set.keys))
+	for _, k := range set.keys {
+		Key, err := dynamodbattribute.MarshalMap(aws.JSONValue{"K": k.key})
+		CHECK(err)
+		cond := set.condition(k, commitSeqNum)
+		fields, isWrite := set.writes[k]
+		if !isWrite {
+			expr, err := expression.NewBuilder().WithCondition(cond).Build()
+			CHECK(err)
+			items = append(items, &dynamodb.TransactWriteItem{
+				ConditionCheck: &dynamodb.ConditionCheck{
+					TableName:                 aws.String(kTablePrefix + k.tablename),
+					Key:                       Key,
+					ConditionExpression:       expr.Condition(),
+					ExpressionAttributeNames:  expr.Names(),
+					ExpressionAttributeValues: expr.Values(),
+				},
+			})
+			continue
+		}
+		updateBuilder := expression.UpdateBuilder{}
+		for kk, vv := range fields {
+			updateBuilder = updateBuilder.Set(expression.Name(kk), expression.Value(vv))
+		}
+		updateBuilder = updateBuilder.
+			Set(expression.Name("VERSION"), expression.Value(commitSeqNum))
+		expr, err := expression.NewBuilder().WithCondition(cond).WithUpdate(updateBuilder).Build()
+		CHECK(err)
+		items = append(items, &dynamodb.TransactWriteItem{
+			Update: &dynamodb.Update{
+				TableName:                 aws.String(kTablePrefix + k.tablename),
+				Key:                       Key,
+				ConditionExpression:       expr.Condition(),
+				ExpressionAttributeNames:  expr.Names(),
+				ExpressionAttributeValues: expr.Values(),
+				UpdateExpression:          expr.Update(),
+			},
+		})
+	}
+	return items
+}
+
+// applied reports whether a commit with commitSeqNum already went through,
+// which is the case for a replayed commit. The commit wrote all its keys or
+// none, and later commits may have overwritten some of them since.
+func (set *occSet) applied(commitSeqNum uint64) bool {
+	for _, k := range set.keys {
+		if _, isWrite := set.writes[k]; isWrite {
+			item := LibRead(k.tablename, aws.JSONValue{"K": k.key}, []string{"VERSION"})
+			if version, _ := item["VERSION"].(float64); uint64(version) == commitSeqNum {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (set *occSet) apply(commitSeqNum uint64) bool {
+	items := set.transactItems(commitSeqNum)
+	if len(items) == 0 {
+		return true
+	}
+	for {
+		_, err := DBClient.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+			TransactItems: items,
+		})
+		if err == nil {
+			return true
+		}
+		if strings.Contains(err.Error(), "ConditionalCheckFailed") {
+			return set.applied(commitSeqNum)
+		}
+		if strings.Contains(err.Error(), "Conflict") {
+			continue
+		}
+		panic(err)
+	}
+}
+
+// OCCCommit validates the versions read by the transaction and installs its
+// buffered writes in one DynamoDB transaction. The commit is a logged step,
+// its seqnum becomes the VERSION of the written keys. A transaction touching
+// more than occMaxKeys keys fails with a TxnTooLarge AppError.
+func OCCCommit(env *Env) bool {
+	set := &occSet{
+		keys:   make([]occKey, 0),
+		reads:  make(map[occKey]uint64),
+		writes: make(map[occKey]map[string]interface{}),
+	}
+	set.gather(env, env.LambdaId, env.TxnId, make(map[string]bool))
+	if len(set.keys) > occMaxKeys {
+		Fail("TxnTooLarge", "OCC transaction %s touches %d keys, at most %d are supported",
+			env.TxnId, len(set.keys), occMaxKeys)
+	}
+	writes := make([]aws.JSONValue, 0)
+	tags := make([]uint64, 0)
+	for _, k := range set.keys {
+		if _, isWrite := set.writes[k]; isWrite {
+			writes = append(writes, aws.JSONValue{"table": k.tablename, "key": k.key})
+			tags = append(tags, KeyStreamTag(k.tablename, k.key))
+		}
+	}
+	newLog, commitLog := ProposeNextStep(env, aws.JSONValue{
+		"type":   "OCCCommit",
+		"writes": writes,
+	}, tags...)
+	if !newLog {
+		CheckLogDataField(commitLog, "type", "OCCCommit")
+		log.Printf("[INFO] Seen OCCCommit log for step %d", commitLog.StepNumber)
+		resultLog := FetchStepResultLog(env, commitLog.StepNumber, false /* catch */)
+		if resultLog != nil {
+			CheckLogDataField(resultLog, "type", "OCCResult")
+			return resultLog.Data["applied"].(bool)
+		}
+	}
+	committed := !set.conflict && set.apply(commitLog.SeqNum)
+	LogStepResult(env, env.InstanceId, commitLog.StepNumber, aws.JSONValue{
+		"type":    "OCCResult",
+		"writes":  writes,
+		"applied": committed,
+		"version": commitLog.SeqNum,
+	}, tags...)
+	return committed
+}
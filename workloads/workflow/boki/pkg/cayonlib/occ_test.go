@@ -0,0 +1,124 @@
+package cayonlib
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+func occRow(key string) aws.JSONValue {
+	return LibRead("occ", aws.JSONValue{"K": key}, []string{"V", "VERSION"})
+}
+
+func TestOCCCommit(t *testing.T) {
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		// copy copies x to y, racing with a commit to x if asked to
+		"copy": func(env *Env) interface{} {
+			BeginTxnWithMode(env, "OCC")
+			x := OCCRead(env, "occ", "x")
+			if env.Input == "race" {
+				LibWrite("occ", aws.JSONValue{"K": "x"}, map[expression.NameBuilder]expression.OperandBuilder{
+					expression.Name("V"):       expression.Value("raced"),
+					expression.Name("VERSION"): expression.Value(1 << 40),
+				})
+			}
+			OCCWrite(env, "occ", "y", aws.JSONValue{"V": x})
+			return CommitTxn(env)
+		},
+		"wide": func(env *Env) interface{} {
+			BeginTxnWithMode(env, "OCC")
+			for i := 0; i <= occMaxKeys; i++ {
+				OCCWrite(env, "occ", fmt.Sprintf("k%d", i), aws.JSONValue{"V": i})
+			}
+			return CommitTxn(env)
+		},
+	})
+	CreateMainTable("occ")
+	Populate("occ", "x", "x0", false)
+
+	if ow := invoke(t, fe, "copy", "ok", nil); ow.Output != true {
+		t.Fatalf("Expected commit, have %+v", ow)
+	}
+	y := occRow("y")
+	if y["V"] != "x0" || y["VERSION"] == nil {
+		t.Fatalf("Unexpected y %v", y)
+	}
+	if ow := replay(t, fe, "copy", "ok", nil); ow.Output != true {
+		t.Fatalf("Expected replayed commit, have %+v", ow)
+	}
+	if ow := invoke(t, fe, "copy", "raced", "race"); ow.Output != false {
+		t.Fatalf("Expected validation to fail, have %+v", ow)
+	}
+	if row := occRow("y"); row["V"] != "x0" || row["VERSION"] != y["VERSION"] {
+		t.Fatalf("Failed commit wrote y %v", row)
+	}
+	expectFailure(t, invoke(t, fe, "wide", "wide", nil), "TxnTooLarge")
+}
+
+func TestOCCReadIndexedOnce(t *testing.T) {
+	var reads []aws.JSONValue
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"reader": func(env *Env) interface{} {
+			BeginTxnWithMode(env, "OCC")
+			OCCRead(env, "occ", "x")
+			if env.Input == "lost" {
+				// A proposal of the step that lost the race to the first one
+				LibAppendLog(env, TransactionStreamTag(env.LambdaId, env.TxnId), &IntentLogEntry{
+					InstanceId: env.InstanceId,
+					StepNumber: env.StepNumber - 1,
+					Data: aws.JSONValue{
+						"type":     "OCCRead",
+						"key":      "x",
+						"table":    "occ",
+						"version":  12345,
+						"lambdaId": env.LambdaId,
+						"txnId":    env.TxnId,
+					},
+				})
+			}
+			set := &occSet{
+				reads:  make(map[occKey]uint64),
+				writes: make(map[occKey]map[string]interface{}),
+			}
+			set.gather(env, env.LambdaId, env.TxnId, make(map[string]bool))
+			reads = append(reads, aws.JSONValue{"keys": len(set.keys), "conflict": set.conflict})
+			return 0
+		},
+	})
+	CreateMainTable("occ")
+	Populate("occ", "x", "x0", false)
+
+	invoke(t, fe, "reader", "once", nil)
+	replay(t, fe, "reader", "once", nil)
+	invoke(t, fe, "reader", "lost", "lost")
+	for _, r := range reads {
+		if r["keys"] != 1 || r["conflict"] != false {
+			t.Fatalf("Expected one read without conflict, have %v", reads)
+		}
+	}
+}
+
+func TestOCCAppliedChecksAllWrites(t *testing.T) {
+	newTestEnv(t, nil)
+	CreateMainTable("occ")
+	set := &occSet{
+		keys:   []occKey{{"occ", "a"}, {"occ", "b"}},
+		writes: map[occKey]map[string]interface{}{{"occ", "a"}: {"V": 1}, {"occ", "b"}: {"V": 1}},
+		reads:  make(map[occKey]uint64),
+	}
+	if !set.apply(7) {
+		t.Fatal("Failed to apply")
+	}
+	// A later commit overwrote a, b still carries the commit
+	LibWrite("occ", aws.JSONValue{"K": "a"}, map[expression.NameBuilder]expression.OperandBuilder{
+		expression.Name("VERSION"): expression.Value(9),
+	})
+	if !set.apply(7) {
+		t.Fatal("Replayed commit is not recognized as applied")
+	}
+	if set.apply(8) {
+		t.Fatal("Conflicting commit applied")
+	}
+}
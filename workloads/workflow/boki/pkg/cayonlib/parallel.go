@@ -0,0 +1,135 @@
+package cayonlib
+
+import (
+	"sync"
+)
+
+// ParallelCall is one invocation of Parallel. If Function is set, Input is
+// the request of that operation of a Service. Output and Err are filled in
+// by Parallel, and the output is decoded into Result unless it is nil.
+//
+// Then are invoked, concurrently, once the call succeeded and its Result is
+// decoded. A call whose input depends on that result sets MakeInput, which
+// is called right before it is invoked, instead of Input.
+type ParallelCall struct {
+	Callee    string
+	Function  string
+	Input     interface{}
+	MakeInput func() interface{}
+	Result    interface{}
+	Output    interface{}
+	Err       error
+	Then      []*ParallelCall
+	failure   interface{}
+}
+
+// Parallel invokes calls concurrently and waits for all of them, including
+// their Then. Their steps are reserved before any of them runs, each call
+// followed by its Then, so a replay numbers them the same. It returns the
+// first error in that order. If calls panicked with anything but an
+// *AppError, Parallel panics with the first of those instead.
+func Parallel(env *Env, calls ...*ParallelCall) error {
+	var reserved []*reservedCall
+	for _, call := range calls {
+		reserved = reserveCall(env, call, reserved)
+	}
+	runCalls(env, calls, reserved)
+	for _, r := range reserved {
+		if r.call.failure != nil {
+			panic(r.call.failure)
+		}
+	}
+	for _, r := range reserved {
+		if r.call.Err != nil {
+			return r.call.Err
+		}
+	}
+	return nil
+}
+
+type reservedCall struct {
+	call         *ParallelCall
+	preInvokeLog *IntentLogEntry
+}
+
+func reserveCall(env *Env, call *ParallelCall, reserved []*reservedCall) []*reservedCall {
+	var input interface{}
+	if call.MakeInput == nil {
+		input = call.Input
+		if call.Function != "" {
+			rpcInput := newRPCInput(call.Function, call.Input, call.Result)
+			checkReplay(env, call.Callee, rpcInput)
+			input = rpcInput
+		}
+	} else if call.Function != "" {
+		// the request is not known yet, only the operation
+		rpcInput := RPCInput{Function: call.Function}
+		checkReplay(env, call.Callee, rpcInput)
+		input = rpcInput
+	}
+	reserved = append(reserved, &reservedCall{call: call, preInvokeLog: ProposeInvoke(env, call.Callee, input)})
+	for _, next := range call.Then {
+		reserved = reserveCall(env, next, reserved)
+	}
+	return reserved
+}
+
+func runCalls(env *Env, calls []*ParallelCall, reserved []*reservedCall) {
+	logs := make(map[*ParallelCall]*IntentLogEntry, len(reserved))
+	for _, r := range reserved {
+		logs[r.call] = r.preInvokeLog
+	}
+	var wg sync.WaitGroup
+	var run func(calls []*ParallelCall)
+	run = func(calls []*ParallelCall) {
+		wg.Add(len(calls))
+		for _, call := range calls {
+			go func(call *ParallelCall) {
+				defer wg.Done()
+				runCall(env, call, logs[call])
+				if call.Err != nil {
+					skipCalls(call.Then, call.Err)
+				} else if call.failure == nil {
+					run(call.Then)
+				}
+			}(call)
+		}
+	}
+	run(calls)
+	wg.Wait()
+}
+
+// runCall invokes call and decodes its result. A panic cannot leave the
+// goroutine: an *AppError is returned as the error of call, anything else
+// is kept for Parallel to panic with.
+func runCall(env *Env, call *ParallelCall, preInvokeLog *IntentLogEntry) {
+	defer func() {
+		if r := recover(); r != nil {
+			if appErr, ok := r.(*AppError); ok {
+				call.Err = appErr
+			} else {
+				call.failure = r
+			}
+		}
+	}()
+	input := call.Input
+	if call.MakeInput != nil {
+		input = call.MakeInput()
+	}
+	if call.Function != "" {
+		input = newRPCInput(call.Function, input, call.Result)
+	}
+	call.Output, _, call.Err = TryAssignedSyncInvoke(env, call.Callee, input, preInvokeLog)
+	if call.Err == nil && call.Result != nil {
+		DecodeValue(call.Output, call.Result)
+	}
+}
+
+// skipCalls fails calls that are not invoked because err failed the call
+// they depend on
+func skipCalls(calls []*ParallelCall, err error) {
+	for _, call := range calls {
+		call.Err = err
+		skipCalls(call.Then, err)
+	}
+}
@@ -0,0 +1,98 @@
+package cayonlib
+
+import (
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/eniac/Beldi/pkg/localfaas"
+)
+
+// newParallelEnv runs flow as "flow" next to a "math" service, whose
+// invocations are counted in calls
+func newParallelEnv(t *testing.T, flow func(env *Env) interface{}) (fe *localfaas.Environment, calls *int32) {
+	calls = new(int32)
+	math := NewService().
+		Register("Double", func(env *Env, n int) int {
+			atomic.AddInt32(calls, 1)
+			return 2 * n
+		}).
+		Register("Fail", func(env *Env, n int) (int, error) {
+			atomic.AddInt32(calls, 1)
+			return 0, NewAppError("Odd", "%d is odd", n)
+		})
+	fe = newTestEnv(t, map[string]func(env *Env) interface{}{
+		"math": math.Handler,
+		"flow": flow,
+	})
+	return fe, calls
+}
+
+func TestParallelThen(t *testing.T) {
+	fe, calls := newParallelEnv(t, func(env *Env) interface{} {
+		var a, b, c int
+		first := &ParallelCall{Callee: "math", Function: "Double", Input: 1, Result: &a}
+		first.Then = []*ParallelCall{{
+			Callee:    "math",
+			Function:  "Double",
+			MakeInput: func() interface{} { return a },
+			Result:    &b,
+		}}
+		second := &ParallelCall{Callee: "math", Function: "Double", Input: 10, Result: &c}
+		if err := Parallel(env, first, second); err != nil {
+			return err
+		}
+		return []int{a, b, c}
+	})
+	for _, ow := range []OutputWrapper{invoke(t, fe, "flow", "then", nil), replay(t, fe, "flow", "then", nil)} {
+		var res []int
+		DecodeValue(ow.Output, &res)
+		if expected := []int{2, 4, 20}; !reflect.DeepEqual(res, expected) {
+			t.Fatalf("Expected %v, have %+v", expected, ow)
+		}
+	}
+	if *calls != 3 {
+		t.Fatalf("Expected 3 invocations, have %d", *calls)
+	}
+}
+
+func TestParallelSkipsThenOfFailedCall(t *testing.T) {
+	fe, calls := newParallelEnv(t, func(env *Env) interface{} {
+		first := &ParallelCall{Callee: "math", Function: "Fail", Input: 1}
+		first.Then = []*ParallelCall{{Callee: "math", Function: "Double", Input: 2}}
+		second := &ParallelCall{Callee: "math", Function: "Double", Input: 10}
+		if err := Parallel(env, first, second); err != nil {
+			return err
+		}
+		return 0
+	})
+	expectFailure(t, invoke(t, fe, "flow", "skip", nil), "Odd")
+	expectFailure(t, replay(t, fe, "flow", "skip", nil), "Odd")
+	if *calls != 2 {
+		t.Fatalf("Expected 2 invocations, have %d", *calls)
+	}
+}
+
+func TestParallelRethrowsPanics(t *testing.T) {
+	fe, calls := newParallelEnv(t, func(env *Env) interface{} {
+		call := &ParallelCall{
+			Callee:    "math",
+			Function:  "Double",
+			MakeInput: func() interface{} { panic("no input") },
+		}
+		call.Then = []*ParallelCall{{Callee: "math", Function: "Double", Input: 2}}
+		failing := &ParallelCall{Callee: "math", Function: "Fail", Input: 1}
+		if err := Parallel(env, failing, call); err != nil {
+			return err
+		}
+		return 0
+	})
+	// A panic crashes the function, even after a call failed first
+	if err := crash(t, fe, "flow", "panic", nil); !strings.Contains(err.Error(), "no input") {
+		t.Fatalf("Expected the panic to crash the function, have %v", err)
+	}
+	if *calls != 1 {
+		t.Fatalf("Expected 1 invocation, have %d", *calls)
+	}
+}
@@ -0,0 +1,248 @@
+package cayonlib
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// RPCInput is the input of an operation of a Service. ReqSchema and
+// RespSchema fingerprint the request and response types of the caller.
+type RPCInput struct {
+	Function   string      `mapstructure:"Function"`
+	Input      interface{} `mapstructure:"Input"`
+	ReqSchema  string      `mapstructure:"ReqSchema" json:",omitempty"`
+	RespSchema string      `mapstructure:"RespSchema" json:",omitempty"`
+}
+
+type operation struct {
+	fn         reflect.Value
+	req        reflect.Type
+	reqSchema  string
+	respSchema string
+	hasResp    bool
+	hasErr     bool
+}
+
+// Service dispatches RPCInput to registered operations, decoding requests
+// into their declared types
+type Service struct {
+	ops map[string]*operation
+}
+
+func NewService() *Service {
+	return &Service{ops: make(map[string]*operation)}
+}
+
+var envType = reflect.TypeOf((*Env)(nil))
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Register adds operation name implemented by fn, which must be of type
+// func(*Env, Req) followed by Resp, (Resp, error), error or no result. A
+// returned error fails the invocation.
+func (s *Service) Register(name string, fn interface{}) *Service {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func || t.NumIn() != 2 || t.In(0) != envType || t.NumOut() > 2 {
+		panic(fmt.Sprintf("Invalid handler for operation %s: %s", name, t))
+	}
+	op := &operation{
+		fn:        v,
+		req:       t.In(1),
+		reqSchema: schemaOf(t.In(1)),
+	}
+	numOut := t.NumOut()
+	if numOut > 0 && t.Out(numOut-1) == errorType {
+		op.hasErr = true
+		numOut--
+	}
+	if numOut == 2 {
+		panic(fmt.Sprintf("Invalid handler for operation %s: %s", name, t))
+	}
+	if numOut == 1 {
+		op.hasResp = true
+		op.respSchema = schemaOf(t.Out(0))
+	}
+	s.ops[name] = op
+	return s
+}
+
+func checkSchema(function string, kind string, expected string, have string) {
+	if expected != "" && have != "" && expected != have {
+		Fail("SchemaMismatch", "%s schema mismatch for %s: expected=%s, have=%s", kind, function, expected, have)
+	}
+}
+
+// Handler is the handler function of the service, to be passed to
+// CreateFuncHandlerFactory
+func (s *Service) Handler(env *Env) interface{} {
+	var input RPCInput
+	CHECK(mapstructure.Decode(env.Input, &input))
+	op, exists := s.ops[input.Function]
+	if !exists {
+		Fail("NoSuchFunction", "no such function %s", input.Function)
+	}
+	checkSchema(input.Function, "Request", op.reqSchema, input.ReqSchema)
+	checkSchema(input.Function, "Response", op.respSchema, input.RespSchema)
+	req := reflect.New(op.req)
+	DecodeValue(input.Input, req.Interface())
+	out := op.fn.Call([]reflect.Value{reflect.ValueOf(env), req.Elem()})
+	if op.hasErr {
+		if err := out[len(out)-1]; !err.IsNil() {
+			return err.Interface()
+		}
+	}
+	if !op.hasResp {
+		return 0
+	}
+	return out[0].Interface()
+}
+
+// DecodeValue decodes a value that went through JSON into output, which
+// must be a pointer. Struct fields are matched by their json names.
+func DecodeValue(input interface{}, output interface{}) {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		TagName: "json",
+		Result:  output,
+	})
+	CHECK(err)
+	CHECK(decoder.Decode(input))
+}
+
+func writeSchema(b *strings.Builder, t reflect.Type, visiting map[reflect.Type]bool) {
+	switch t.Kind() {
+	case reflect.Ptr:
+		writeSchema(b, t.Elem(), visiting)
+	case reflect.Slice, reflect.Array:
+		b.WriteString("[]")
+		writeSchema(b, t.Elem(), visiting)
+	case reflect.Map:
+		b.WriteString("map[")
+		writeSchema(b, t.Key(), visiting)
+		b.WriteString("]")
+		writeSchema(b, t.Elem(), visiting)
+	case reflect.Interface:
+		b.WriteString("any")
+	case reflect.Struct:
+		if visiting[t] {
+			b.WriteString(t.String())
+			return
+		}
+		visiting[t] = true
+		b.WriteString("{")
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			} else if name == "" {
+				name = field.Name
+			}
+			b.WriteString(name)
+			b.WriteString(":")
+			writeSchema(b, field.Type, visiting)
+			b.WriteString(";")
+		}
+		b.WriteString("}")
+		delete(visiting, t)
+	default:
+		b.WriteString(t.Kind().String())
+	}
+}
+
+// schemaOf fingerprints the JSON shape of t, "" for the type of nil, whose
+// shape is not known
+func schemaOf(t reflect.Type) string {
+	if t == nil {
+		return ""
+	}
+	var b strings.Builder
+	writeSchema(&b, t, make(map[reflect.Type]bool))
+	h := fnv.New64a()
+	h.Write([]byte(b.String()))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+func newRPCInput(function string, req interface{}, resp interface{}) RPCInput {
+	input := RPCInput{
+		Function:  function,
+		Input:     req,
+		ReqSchema: schemaOf(reflect.TypeOf(req)),
+	}
+	if resp != nil {
+		t := reflect.TypeOf(resp)
+		if t.Kind() != reflect.Ptr {
+			panic(fmt.Sprintf("Response of %s must be a pointer, have %s", function, t))
+		}
+		input.RespSchema = schemaOf(t.Elem())
+	}
+	return input
+}
+
+// checkReplay crashes if the invocation logged for the next step was made
+// with another operation or schema, i.e. the code changed under the log
+func checkReplay(env *Env, callee string, input RPCInput) {
+	intentLog := env.Fsm.GetStepLog(env.StepNumber)
+	if intentLog == nil {
+		return
+	}
+	CheckLogDataField(intentLog, "type", "PreInvoke")
+	CheckLogDataField(intentLog, "callee", callee)
+	if intentLog.Data["input"] == nil && input.Input == nil {
+		// Reserved by Parallel before the call named its operation
+		return
+	}
+	var logged RPCInput
+	CHECK(mapstructure.Decode(intentLog.Data["input"], &logged))
+	if logged.Function != input.Function {
+		logMismatch("Function mismatch at step %d: expected=%s, have=%s",
+			intentLog.StepNumber, input.Function, logged.Function)
+	}
+	checkLoggedSchema(intentLog, input.Function, "Request", input.ReqSchema, logged.ReqSchema)
+	checkLoggedSchema(intentLog, input.Function, "Response", input.RespSchema, logged.RespSchema)
+}
+
+func checkLoggedSchema(intentLog *IntentLogEntry, function string, kind string, expected string, have string) {
+	if expected != "" && have != "" && expected != have {
+		logMismatch("%s schema mismatch for %s at step %d: expected=%s, have=%s",
+			kind, function, intentLog.StepNumber, expected, have)
+	}
+}
+
+// Call invokes operation function of callee with req and decodes its
+// output into resp, which is a pointer or nil. Like SyncInvoke, it panics
+// with the *AppError callee failed with.
+func Call(env *Env, callee string, function string, req interface{}, resp interface{}) string {
+	instanceId, err := TryCall(env, callee, function, req, resp)
+	if err != nil {
+		panic(err)
+	}
+	return instanceId
+}
+
+// TryCall is Call returning the *AppError callee failed with
+func TryCall(env *Env, callee string, function string, req interface{}, resp interface{}) (string, error) {
+	input := newRPCInput(function, req, resp)
+	checkReplay(env, callee, input)
+	output, instanceId, err := TrySyncInvoke(env, callee, input)
+	if err != nil {
+		return instanceId, err
+	}
+	if resp != nil {
+		DecodeValue(output, resp)
+	}
+	return instanceId, nil
+}
+
+// CallAsync invokes operation function of callee with req asynchronously
+func CallAsync(env *Env, callee string, function string, req interface{}) *Future {
+	input := newRPCInput(function, req, nil)
+	checkReplay(env, callee, input)
+	return AsyncInvoke(env, callee, input)
+}
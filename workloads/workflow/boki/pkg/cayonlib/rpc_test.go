@@ -0,0 +1,128 @@
+package cayonlib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+type addRequest struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+type addResponse struct {
+	Sum int `json:"sum"`
+}
+
+func newAdderService() *Service {
+	return NewService().
+		Register("Add", func(env *Env, req addRequest) addResponse {
+			return addResponse{Sum: req.A + req.B}
+		}).
+		Register("Check", func(env *Env, req addRequest) error {
+			if req.A < 0 {
+				return NewAppError("Negative", "%d is negative", req.A)
+			}
+			return nil
+		}).
+		Register("Ping", func(env *Env, req interface{}) {})
+}
+
+func TestCall(t *testing.T) {
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"adder": newAdderService().Handler,
+		"caller": func(env *Env) interface{} {
+			var resp addResponse
+			Call(env, "adder", "Add", addRequest{A: 1, B: 2}, &resp)
+			Call(env, "adder", "Ping", nil, nil)
+			if _, err := TryCall(env, "adder", "Check", addRequest{A: -1}, nil); err == nil {
+				Fail("Unexpected", "Check accepted a negative number")
+			}
+			return resp.Sum
+		},
+		// Add with a response of another shape
+		"mismatch": func(env *Env) interface{} {
+			var resp struct{ Total int }
+			Call(env, "adder", "Add", addRequest{A: 1, B: 2}, &resp)
+			return resp.Total
+		},
+		"unknown": func(env *Env) interface{} {
+			Call(env, "adder", "Sub", addRequest{A: 1, B: 2}, nil)
+			return 0
+		},
+	})
+	for _, ow := range []OutputWrapper{invoke(t, fe, "caller", "call", nil), replay(t, fe, "caller", "call", nil)} {
+		if ow.Status != "Success" || ow.Output != 3.0 {
+			t.Fatalf("Expected 3, have %+v", ow)
+		}
+	}
+	expectFailure(t, invoke(t, fe, "mismatch", "mismatch", nil), "SchemaMismatch")
+	expectFailure(t, invoke(t, fe, "unknown", "unknown", nil), "NoSuchFunction")
+}
+
+func TestCallReplay(t *testing.T) {
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"adder": newAdderService().Handler,
+		// Invokes Add as it was before the call was typed
+		"untyped": func(env *Env) interface{} {
+			output, _ := SyncInvoke(env, "adder", aws.JSONValue{
+				"Function": "Add",
+				"Input":    aws.JSONValue{"a": 1, "b": 2},
+			})
+			return output
+		},
+		"typed": func(env *Env) interface{} {
+			var resp addResponse
+			Call(env, "adder", "Add", addRequest{A: 1, B: 2}, &resp)
+			return resp.Sum
+		},
+		"changed": func(env *Env) interface{} {
+			Call(env, "adder", "Check", addRequest{A: 1, B: 2}, nil)
+			return 0
+		},
+	})
+	invoke(t, fe, "untyped", "old", nil)
+	// A call logged without schemas replays as a typed call
+	if ow := replay(t, fe, "typed", "old", nil); ow.Status != "Success" || ow.Output != 3.0 {
+		t.Fatalf("Expected 3, have %+v", ow)
+	}
+	invoke(t, fe, "typed", "new", nil)
+	if err := crash(t, fe, "changed", "new", nil); !strings.Contains(err.Error(), "LogMismatch") {
+		t.Fatalf("Expected a LogMismatch crash, have %v", err)
+	}
+}
+
+func TestParallelCallReplay(t *testing.T) {
+	parallel := func(typed bool) func(env *Env) interface{} {
+		return func(env *Env) interface{} {
+			var resp addResponse
+			call := &ParallelCall{
+				Callee: "adder",
+				MakeInput: func() interface{} {
+					return RPCInput{Function: "Add", Input: addRequest{A: 1, B: 2}}
+				},
+				Result: &resp,
+			}
+			if typed {
+				call.Function = "Add"
+				call.MakeInput = func() interface{} {
+					return addRequest{A: 1, B: 2}
+				}
+			}
+			CHECK(Parallel(env, call))
+			return resp.Sum
+		}
+	}
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"adder":   newAdderService().Handler,
+		"untyped": parallel(false),
+		"typed":   parallel(true),
+	})
+	invoke(t, fe, "untyped", "old", nil)
+	// The call was reserved without its operation
+	if ow := replay(t, fe, "typed", "old", nil); ow.Status != "Success" || ow.Output != 3.0 {
+		t.Fatalf("Expected 3, have %+v", ow)
+	}
+}
@@ -0,0 +1,97 @@
+package cayonlib
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+type compensation struct {
+	Callee   string      `json:"callee"`
+	Function string      `json:"function"`
+	Input    interface{} `json:"input"`
+}
+
+// Saga is an alternative to 2PL for flows across services: no locks are
+// held, instead every step registers an invocation that undoes it. The
+// compensations run in reverse order when a step fails or on Compensate.
+type Saga struct {
+	env           *Env
+	compensations []*compensation
+}
+
+func BeginSaga(env *Env) *Saga {
+	return &Saga{env: env, compensations: make([]*compensation, 0)}
+}
+
+// AddCompensation registers operation function of callee with req as the
+// compensation of the steps so far
+func (s *Saga) AddCompensation(callee string, function string, req interface{}) {
+	s.compensations = append(s.compensations, &compensation{
+		Callee:   callee,
+		Function: function,
+		Input:    req,
+	})
+}
+
+// CompensationRetries is how many times a failed compensation is invoked
+// again before the saga gives up on it
+var CompensationRetries = 3
+
+// Call is TryCall compensating the saga if callee fails. It returns the
+// *AppError callee failed with. If the saga cannot be compensated, the
+// function fails with the CompensationFailed error of Compensate instead.
+func (s *Saga) Call(callee string, function string, req interface{}, resp interface{}) error {
+	_, err := TryCall(s.env, callee, function, req, resp)
+	if err != nil {
+		log.Printf("[WARN] Saga step %s of %s failed: %s", function, callee, err.Error())
+		CHECK(s.Compensate())
+		return err
+	}
+	return nil
+}
+
+// Compensate aborts the saga. The compensations are logged as one step and
+// invoked as logged steps after it, so each of them runs exactly once. A
+// compensation that fails is invoked again, up to CompensationRetries
+// times. Compensate returns a CompensationFailed *AppError naming the
+// compensations that failed every attempt, which the caller must not drop.
+func (s *Saga) Compensate() error {
+	if len(s.compensations) == 0 {
+		return nil
+	}
+	newLog, intentLog := ProposeNextStep(s.env, aws.JSONValue{
+		"type":          "Compensate",
+		"compensations": s.compensations,
+	})
+	if !newLog {
+		CheckLogDataField(intentLog, "type", "Compensate")
+		if logged, _ := intentLog.Data["compensations"].([]interface{}); len(logged) != len(s.compensations) {
+			logMismatch("Compensation count mismatch at step %d: expected=%d, have=%d",
+				intentLog.StepNumber, len(s.compensations), len(logged))
+		}
+		log.Printf("[INFO] Seen Compensate log for step %d", intentLog.StepNumber)
+	}
+	var failed []string
+	for i := len(s.compensations) - 1; i >= 0; i-- {
+		c := s.compensations[i]
+		for attempt := 0; ; attempt++ {
+			_, err := TryCall(s.env, c.Callee, c.Function, c.Input, nil)
+			if err == nil {
+				break
+			}
+			log.Printf("[WARN] Compensation %s of %s failed: %s", c.Function, c.Callee, err.Error())
+			if attempt == CompensationRetries {
+				failed = append(failed, fmt.Sprintf("%s of %s: %s", c.Function, c.Callee, err.Error()))
+				break
+			}
+		}
+	}
+	s.compensations = s.compensations[:0]
+	if len(failed) > 0 {
+		return NewAppError("CompensationFailed", "%s", strings.Join(failed, "; "))
+	}
+	return nil
+}
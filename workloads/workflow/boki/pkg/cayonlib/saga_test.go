@@ -0,0 +1,98 @@
+package cayonlib
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+type bookingReq struct {
+	Item string `json:"item"`
+}
+
+func TestSagaCompensatesInReverseOrder(t *testing.T) {
+	var mu sync.Mutex
+	var cancelled []string
+	booking := NewService().
+		Register("Reserve", func(env *Env, req bookingReq) error {
+			return nil
+		}).
+		Register("Cancel", func(env *Env, req bookingReq) error {
+			mu.Lock()
+			cancelled = append(cancelled, req.Item)
+			mu.Unlock()
+			return nil
+		})
+	payment := NewService().
+		Register("Charge", func(env *Env, req bookingReq) error {
+			return NewAppError("Declined", "Card declined for %s", req.Item)
+		})
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"booking": booking.Handler,
+		"payment": payment.Handler,
+		"trip": func(env *Env) interface{} {
+			saga := BeginSaga(env)
+			for _, item := range []string{"hotel", "flight"} {
+				if err := saga.Call("booking", "Reserve", bookingReq{Item: item}, nil); err != nil {
+					return err
+				}
+				saga.AddCompensation("booking", "Cancel", bookingReq{Item: item})
+			}
+			if err := saga.Call("payment", "Charge", bookingReq{Item: "trip"}, nil); err != nil {
+				return err
+			}
+			return 0
+		},
+	})
+	expectFailure(t, invoke(t, fe, "trip", "trip", nil), "Declined")
+	if expected := []string{"flight", "hotel"}; !reflect.DeepEqual(cancelled, expected) {
+		t.Fatalf("Expected compensations %v, have %v", expected, cancelled)
+	}
+	// The compensations are logged steps, a replay does not run them again
+	expectFailure(t, replay(t, fe, "trip", "trip", nil), "Declined")
+	if len(cancelled) != 2 {
+		t.Fatalf("Compensations ran again on replay: %v", cancelled)
+	}
+}
+
+func TestSagaRetriesCompensations(t *testing.T) {
+	var cancels, failures int32
+	booking := NewService().
+		Register("Reserve", func(env *Env, req bookingReq) error {
+			return nil
+		}).
+		Register("Cancel", func(env *Env, req bookingReq) error {
+			atomic.AddInt32(&cancels, 1)
+			if atomic.AddInt32(&failures, -1) >= 0 {
+				return NewAppError("Contention", "Cannot cancel %s", req.Item)
+			}
+			return nil
+		}).
+		Register("Charge", func(env *Env, req bookingReq) error {
+			return NewAppError("Declined", "Card declined for %s", req.Item)
+		})
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"booking": booking.Handler,
+		"trip": func(env *Env) interface{} {
+			saga := BeginSaga(env)
+			if err := saga.Call("booking", "Reserve", bookingReq{Item: "hotel"}, nil); err != nil {
+				return err
+			}
+			saga.AddCompensation("booking", "Cancel", bookingReq{Item: "hotel"})
+			return saga.Call("booking", "Charge", bookingReq{Item: "trip"}, nil)
+		},
+	})
+	failures = int32(CompensationRetries)
+	expectFailure(t, invoke(t, fe, "trip", "retried", nil), "Declined")
+	if cancels != int32(CompensationRetries)+1 {
+		t.Fatalf("Expected %d attempts, have %d", CompensationRetries+1, cancels)
+	}
+
+	cancels, failures = 0, int32(CompensationRetries)+1
+	expectFailure(t, invoke(t, fe, "trip", "failed", nil), "CompensationFailed")
+	expectFailure(t, replay(t, fe, "trip", "failed", nil), "CompensationFailed")
+	if cancels != int32(CompensationRetries)+1 {
+		t.Fatalf("Expected %d attempts, have %d", CompensationRetries+1, cancels)
+	}
+}
@@ -0,0 +1,111 @@
+package cayonlib
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// ScanPageSize is the number of items a page of Scan reads. Every page is
+// logged as one step, so it has to fit into a log record.
+var ScanPageSize = int64(100)
+
+// LibScanPage scans at most limit items from start, 0 is no limit. It
+// returns the start of the next page, nil after the last one.
+func LibScanPage(tablename string, projection []string,
+	start map[string]*dynamodb.AttributeValue, limit int64) ([]aws.JSONValue, map[string]*dynamodb.AttributeValue) {
+	input := &dynamodb.ScanInput{
+		TableName:         aws.String(kTablePrefix + tablename),
+		ConsistentRead:    aws.Bool(true),
+		ExclusiveStartKey: start,
+	}
+	if len(projection) != 0 {
+		expr, err := expression.NewBuilder().WithProjection(BuildProjection(projection)).Build()
+		CHECK(err)
+		input.ExpressionAttributeNames = expr.Names()
+		input.ProjectionExpression = expr.Projection()
+	}
+	if limit > 0 {
+		input.Limit = aws.Int64(limit)
+	}
+	res, err := DBClient.Scan(input)
+	CHECK(err)
+	var items []aws.JSONValue
+	err = dynamodbattribute.UnmarshalListOfMaps(res.Items, &items)
+	CHECK(err)
+	if len(res.LastEvaluatedKey) == 0 {
+		return items, nil
+	}
+	return items, res.LastEvaluatedKey
+}
+
+// ScanIterator reads the values of a table, a page per Next. Each page is
+// a step logging the values with the token of the next page, so a replay
+// walks the same pages.
+//
+//	it := NewScanIterator(env, tablename)
+//	for !it.Done() {
+//		values := it.Next()
+//	}
+type ScanIterator struct {
+	env       *Env
+	tablename string
+	next      map[string]interface{}
+	done      bool
+}
+
+func NewScanIterator(env *Env, tablename string) *ScanIterator {
+	return &ScanIterator{env: env, tablename: tablename}
+}
+
+func (it *ScanIterator) Done() bool {
+	return it.done
+}
+
+func (it *ScanIterator) Next() []interface{} {
+	if it.done {
+		return nil
+	}
+	env := it.env
+	step := env.StepNumber
+	newLog := false
+	intentLog := env.Fsm.GetStepLog(step)
+	if intentLog != nil {
+		env.StepNumber += 1
+	} else {
+		var start map[string]*dynamodb.AttributeValue
+		if it.next != nil {
+			var err error
+			start, err = dynamodbattribute.MarshalMap(it.next)
+			CHECK(err)
+		}
+		items, last := LibScanPage(it.tablename, []string{"V"}, start, ScanPageSize)
+		var res []interface{}
+		for _, item := range items {
+			res = append(res, item["V"])
+		}
+		data := aws.JSONValue{
+			"type":   "Scan",
+			"table":  it.tablename,
+			"result": res,
+		}
+		if last != nil {
+			var next aws.JSONValue
+			CHECK(dynamodbattribute.UnmarshalMap(last, &next))
+			data["next"] = next
+		}
+		newLog, intentLog = ProposeNextStep(env, data)
+	}
+	if !newLog {
+		CheckLogDataField(intentLog, "type", "Scan")
+		CheckLogDataField(intentLog, "table", it.tablename)
+		log.Printf("[INFO] Seen Scan log for step %d", intentLog.StepNumber)
+	}
+	it.next, _ = intentLog.Data["next"].(map[string]interface{})
+	it.done = it.next == nil
+	res, _ := intentLog.Data["result"].([]interface{})
+	return res
+}
@@ -0,0 +1,78 @@
+package cayonlib
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+func putValue(key string, value interface{}) {
+	LibWrite("scan", aws.JSONValue{"K": key},
+		map[expression.NameBuilder]expression.OperandBuilder{
+			expression.Name("V"): expression.Value(value),
+		})
+}
+
+func TestScanIteratorReplaysPages(t *testing.T) {
+	pageSize := ScanPageSize
+	ScanPageSize = 2
+	defer func() {
+		ScanPageSize = pageSize
+	}()
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"scanner": func(env *Env) interface{} {
+			pages := make([]interface{}, 0)
+			it := NewScanIterator(env, "scan")
+			for !it.Done() {
+				values := it.Next()
+				sort.Slice(values, func(i, j int) bool {
+					return values[i].(string) < values[j].(string)
+				})
+				pages = append(pages, values)
+			}
+			return pages
+		},
+	})
+	CreateMainTable("scan")
+	for i := 0; i < 5; i++ {
+		putValue(fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i))
+	}
+
+	pages := invoke(t, fe, "scanner", "scan", nil).Output.([]interface{})
+	if len(pages) != 3 {
+		t.Fatalf("Expected 3 pages of at most 2, have %v", pages)
+	}
+	values := make([]string, 0)
+	for _, page := range pages {
+		for _, v := range page.([]interface{}) {
+			values = append(values, v.(string))
+		}
+	}
+	sort.Strings(values)
+	if expected := []string{"v0", "v1", "v2", "v3", "v4"}; !reflect.DeepEqual(values, expected) {
+		t.Fatalf("Expected %v, have %v", expected, values)
+	}
+
+	// A replay reads the logged pages, not the table
+	putValue("k0", "changed")
+	putValue("k5", "v5")
+	if ow := replay(t, fe, "scanner", "scan", nil); !reflect.DeepEqual(ow.Output, pages) {
+		t.Fatalf("Expected the replay to return %v, have %+v", pages, ow)
+	}
+}
+
+func TestScanOfEmptyTable(t *testing.T) {
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"scanner": func(env *Env) interface{} {
+			return len(Scan(env, "scan").([]interface{}))
+		},
+	})
+	CreateMainTable("scan")
+	if ow := invoke(t, fe, "scanner", "scan", nil); ow.Output != 0.0 {
+		t.Fatalf("Expected no values, have %+v", ow)
+	}
+}
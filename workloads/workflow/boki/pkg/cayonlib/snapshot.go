@@ -0,0 +1,134 @@
+package cayonlib
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+type SnapshotKey struct {
+	Table string
+	Key   string
+}
+
+var snapshotRetryInterval = 5 * time.Millisecond
+
+// SnapshotReadTimeout bounds the wait for writes in flight, e.g. of a
+// writer that crashed after its PreWrite and was not re-executed yet
+var SnapshotReadTimeout = 10 * time.Second
+
+func writesKey(data aws.JSONValue, key SnapshotKey) bool {
+	if data["table"] == key.Table && data["key"] == key.Key {
+		return true
+	}
+	writes, _ := data["writes"].([]interface{})
+	for _, write := range writes {
+		if w, ok := write.(map[string]interface{}); ok && w["table"] == key.Table && w["key"] == key.Key {
+			return true
+		}
+	}
+	return false
+}
+
+// settledAt reports whether the value of key read with VERSION version is
+// its value as of log position snapshot, i.e. no write to key with a seqnum
+// in (version, snapshot] is still in flight or was applied after the read
+func settledAt(env *Env, key SnapshotKey, version uint64, snapshot uint64) bool {
+	inFlight := make(map[string]bool)
+	stale := false
+	readLogs(env, KeyStreamTag(key.Table, key.Key), version+1, func(seqNum uint64, data []byte) {
+		var intentLog IntentLogEntry
+		CHECK(decodeRecord(data, &intentLog))
+		if !writesKey(intentLog.Data, key) {
+			return
+		}
+		step := fmt.Sprintf("%s-%d", intentLog.InstanceId, intentLog.StepNumber)
+		switch intentLog.Data["type"] {
+		case "PreWrite", "OCCCommit":
+			if seqNum <= snapshot {
+				inFlight[step] = true
+			}
+		case "PostWrite", "OCCResult":
+			delete(inFlight, step)
+			applied, _ := intentLog.Data["applied"].(bool)
+			written, _ := LogInt(intentLog.Data["version"])
+			if applied && uint64(written) > version && uint64(written) <= snapshot {
+				stale = true
+			}
+		}
+	})
+	return !stale && len(inFlight) == 0
+}
+
+func trySnapshotRead(env *Env, keys []SnapshotKey) (uint64, []interface{}, bool) {
+	snapshot := uint64(0)
+	tail, err := env.FaasEnv.SharedLogCheckTail(env.FaasCtx, 0)
+	CHECK(err)
+	if tail != nil {
+		snapshot = tail.SeqNum
+	}
+	results := make([]interface{}, len(keys))
+	for i, key := range keys {
+		item := LibRead(key.Table, aws.JSONValue{"K": key.Key}, []string{"V", "VERSION"})
+		version := uint64(0)
+		if tmp, ok := item["VERSION"].(float64); ok {
+			version = uint64(tmp)
+		}
+		if version > snapshot || !settledAt(env, key, version, snapshot) {
+			return snapshot, nil, false
+		}
+		results[i] = item["V"]
+	}
+	return snapshot, results, true
+}
+
+// SnapshotRead reads keys as of a single shared-log position, and logs
+// them as one step. It fails with a SnapshotTimeout AppError, also logged,
+// if no position settles within SnapshotReadTimeout.
+func SnapshotRead(env *Env, keys []SnapshotKey) ([]interface{}, error) {
+	step := env.StepNumber
+	newLog := false
+	intentLog := env.Fsm.GetStepLog(step)
+	if intentLog != nil {
+		env.StepNumber += 1
+	} else {
+		var snapshot uint64
+		var results []interface{}
+		deadline := time.Now().Add(SnapshotReadTimeout)
+		data := aws.JSONValue{
+			"type": "SnapshotRead",
+			"keys": keys,
+		}
+		for {
+			var ok bool
+			snapshot, results, ok = trySnapshotRead(env, keys)
+			if ok {
+				data["snapshot"] = snapshot
+				data["results"] = results
+				break
+			}
+			if time.Now().After(deadline) {
+				log.Printf("[WARN] Snapshot %d did not settle in %v", snapshot, SnapshotReadTimeout)
+				data["timeout"] = true
+				break
+			}
+			log.Printf("[INFO] Snapshot %d is not settled, retry", snapshot)
+			time.Sleep(snapshotRetryInterval)
+		}
+		newLog, intentLog = ProposeNextStep(env, data)
+	}
+	if !newLog {
+		CheckLogDataField(intentLog, "type", "SnapshotRead")
+		log.Printf("[INFO] Seen SnapshotRead log for step %d", intentLog.StepNumber)
+	}
+	if timeout, _ := intentLog.Data["timeout"].(bool); timeout {
+		return nil, NewAppError("SnapshotTimeout", "Snapshot of step %d did not settle", intentLog.StepNumber)
+	}
+	results := intentLog.Data["results"].([]interface{})
+	if len(results) != len(keys) {
+		panic(fmt.Sprintf("SnapshotRead mismatch: expected %d keys, have %d", len(keys), len(results)))
+	}
+	return results, nil
+}
@@ -0,0 +1,63 @@
+package cayonlib
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestSnapshotRead(t *testing.T) {
+	keys := []SnapshotKey{{"snap", "x"}, {"snap", "y"}, {"snap", "z"}}
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"reader": func(env *Env) interface{} {
+			results, err := SnapshotRead(env, keys)
+			if err != nil {
+				return err
+			}
+			return results
+		},
+		// writer crashes between its PreWrite and the write
+		"writer": func(env *Env) interface{} {
+			ProposeNextStep(env, aws.JSONValue{
+				"type":  "PreWrite",
+				"key":   "x",
+				"table": "snap",
+			}, KeyStreamTag("snap", "x"))
+			return 0
+		},
+	})
+	CreateMainTable("snap")
+	for k, v := range map[string]*dynamodb.AttributeValue{
+		"x": {N: aws.String("1")},
+		"y": {S: aws.String("two")},
+	} {
+		_, err := DBClient.PutItem(&dynamodb.PutItemInput{
+			TableName: aws.String(kTablePrefix + "snap"),
+			Item:      map[string]*dynamodb.AttributeValue{"K": {S: aws.String(k)}, "V": v},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []interface{}{1.0, "two", nil}
+	for _, ow := range []OutputWrapper{invoke(t, fe, "reader", "settled", nil), replay(t, fe, "reader", "settled", nil)} {
+		if !reflect.DeepEqual(ow.Output, expected) {
+			t.Fatalf("Expected %v, have %+v", expected, ow)
+		}
+	}
+
+	timeout := SnapshotReadTimeout
+	SnapshotReadTimeout = 20 * time.Millisecond
+	defer func() {
+		SnapshotReadTimeout = timeout
+	}()
+	invoke(t, fe, "writer", "writer", nil)
+	expectFailure(t, invoke(t, fe, "reader", "inflight", nil), "SnapshotTimeout")
+	// The timeout is logged, a replay fails without waiting
+	SnapshotReadTimeout = time.Minute
+	expectFailure(t, replay(t, fe, "reader", "inflight", nil), "SnapshotTimeout")
+}
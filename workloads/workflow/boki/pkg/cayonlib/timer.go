@@ -0,0 +1,182 @@
+package cayonlib
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/lithammer/shortuuid"
+	"github.com/mitchellh/mapstructure"
+)
+
+// suspension is raised by Sleep to stop an instance until a timer resumes it
+type suspension struct {
+	at int64
+}
+
+func nowMs() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// Sleep pauses the instance for d as a logged step. The instance returns at
+// once and is resumed by FireTimers. Only an asynchronous instance can
+// sleep, the caller of a synchronous one waits for its result.
+func Sleep(env *Env, d time.Duration) {
+	async := env.wrapper != nil && env.wrapper.Async
+	if !async && env.Fsm.GetStepLog(env.StepNumber) == nil {
+		Fail("SyncSleep", "Instance %s of %s is synchronous and cannot sleep", env.InstanceId, env.LambdaId)
+	}
+	newLog, intentLog := ProposeNextStep(env, aws.JSONValue{
+		"type": "Sleep",
+		"at":   nowMs() + int64(d/time.Millisecond),
+	})
+	if !newLog {
+		CheckLogDataField(intentLog, "type", "Sleep")
+		log.Printf("[INFO] Seen Sleep log for step %d", intentLog.StepNumber)
+	}
+	at, _ := LogInt(intentLog.Data["at"])
+	if at <= nowMs() {
+		return
+	}
+	if !async {
+		// Logged by a synchronous instance when Sleep slept in place
+		Fail("SyncSleep", "Instance %s of %s is synchronous and cannot sleep", env.InstanceId, env.LambdaId)
+	}
+	LibAppendLog(env, TimerLogTag, aws.JSONValue{
+		"type":    "Timer",
+		"at":      at,
+		"callee":  env.LambdaId,
+		"wrapper": *env.wrapper,
+	})
+	panic(&suspension{at: at})
+}
+
+// ScheduleAt invokes callee with input asynchronously at t. The timer is
+// logged as a step, and fired by FireTimers.
+func ScheduleAt(env *Env, t time.Time, callee string, input interface{}) *Future {
+	newLog, intentLog := ProposeNextStep(env, aws.JSONValue{
+		"type":   "ScheduleAt",
+		"at":     t.UnixNano() / int64(time.Millisecond),
+		"callee": callee,
+		"wrapper": InputWrapper{
+			CallerName: env.LambdaId,
+			CallerId:   env.InstanceId,
+			CallerStep: env.StepNumber,
+			Async:      true,
+			InstanceId: shortuuid.New(),
+			Input:      input,
+		},
+	}, TimerLogTag)
+	if !newLog {
+		CheckLogDataField(intentLog, "type", "ScheduleAt")
+		CheckLogDataField(intentLog, "callee", callee)
+		log.Printf("[INFO] Seen ScheduleAt log for step %d", intentLog.StepNumber)
+	}
+	return &Future{
+		InstanceId: intentLog.Data["wrapper"].(map[string]interface{})["InstanceId"].(string),
+		stepNumber: intentLog.StepNumber,
+	}
+}
+
+type timerRecord struct {
+	seqNum uint64
+	at     int64
+	callee string
+	iw     InputWrapper
+	sleep  bool
+}
+
+// key identifies the step that logged the timer. A step proposed twice, or
+// a Sleep replayed before its timer fired, logs the same timer again.
+func (timer *timerRecord) key() string {
+	if timer.sleep {
+		return fmt.Sprintf("%s@%d", timer.iw.InstanceId, timer.at)
+	}
+	return fmt.Sprintf("%s-%d", timer.iw.CallerId, timer.iw.CallerStep)
+}
+
+// instanceDone tells whether instanceId logged it finished
+func instanceDone(env *Env, instanceId string) bool {
+	done := false
+	readLogs(env, InstanceDoneStreamTag(instanceId), 0, func(seqNum uint64, data []byte) {
+		var record aws.JSONValue
+		CHECK(decodeRecord(data, &record))
+		if record["InstanceId"] == instanceId {
+			done = true
+		}
+	})
+	return done
+}
+
+// FireTimers invokes the instances and functions whose timers are due.
+// Every timer is marked as fired in the timer log afterwards, a crash in
+// between fires it again, which replays to the same result. Only the first
+// timer of a step fires, and timers of instances already done are dropped.
+func FireTimers(env *Env) {
+	fired := make(map[uint64]bool)
+	timers := make([]*timerRecord, 0)
+	safe := uint64(0)
+	readLogs(env, TimerLogTag, 0, func(seqNum uint64, data []byte) {
+		safe = seqNum + 1
+		var record aws.JSONValue
+		CHECK(decodeRecord(data, &record))
+		if timerSeqNum, ok := LogInt(record["fired"]); ok {
+			fired[uint64(timerSeqNum)] = true
+			return
+		}
+		// Sleep logs a timer record, ScheduleAt its step
+		sleep := record["type"] == "Timer"
+		if !sleep {
+			var intentLog IntentLogEntry
+			CHECK(decodeRecord(data, &intentLog))
+			record = intentLog.Data
+		}
+		at, _ := LogInt(record["at"])
+		timer := &timerRecord{
+			seqNum: seqNum,
+			at:     at,
+			callee: record["callee"].(string),
+			sleep:  sleep,
+		}
+		CHECK(mapstructure.Decode(record["wrapper"], &timer.iw))
+		timers = append(timers, timer)
+	})
+	first := make(map[string]bool)
+	now := nowMs()
+	count := 0
+	pending := 0
+	for _, timer := range timers {
+		if fired[timer.seqNum] {
+			first[timer.key()] = true
+			continue
+		}
+		if first[timer.key()] {
+			// Marked as fired, the first timer of the step may be trimmed
+			// before this one
+			LibAppendLog(env, TimerLogTag, aws.JSONValue{"fired": timer.seqNum})
+			continue
+		}
+		first[timer.key()] = true
+		if timer.at > now {
+			if timer.seqNum < safe {
+				safe = timer.seqNum
+			}
+			pending++
+			continue
+		}
+		if instanceDone(env, timer.iw.InstanceId) {
+			LibAppendLog(env, TimerLogTag, aws.JSONValue{"fired": timer.seqNum})
+			continue
+		}
+		log.Printf("[INFO] Fire timer of instance %s of %s", timer.iw.InstanceId, timer.callee)
+		err := env.FaasEnv.InvokeFuncAsync(env.FaasCtx, timer.callee, timer.iw.Serialize())
+		CHECK(err)
+		LibAppendLog(env, TimerLogTag, aws.JSONValue{"fired": timer.seqNum})
+		count++
+	}
+	if safe > 0 {
+		LibTrimLog(env, TimerLogTag, safe)
+	}
+	log.Printf("[INFO] Fired %d timers, %d pending", count, pending)
+}
@@ -0,0 +1,160 @@
+package cayonlib
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/eniac/Beldi/pkg/localfaas"
+	"github.com/mitchellh/mapstructure"
+)
+
+func TestSleepSuspendsUntilFireTimers(t *testing.T) {
+	var started, woken int32
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"sleeper": func(env *Env) interface{} {
+			atomic.AddInt32(&started, 1)
+			Sleep(env, 50*time.Millisecond)
+			atomic.AddInt32(&woken, 1)
+			return 0
+		},
+		"timers": func(env *Env) interface{} {
+			FireTimers(env)
+			return 0
+		},
+	})
+	iw := InputWrapper{InstanceId: "sleeper", Async: true}
+	if err := fe.InvokeFuncAsync(context.Background(), "sleeper", iw.Serialize()); err != nil {
+		t.Fatal(err)
+	}
+	fe.Wait()
+	if started != 1 || woken != 0 {
+		t.Fatalf("Expected a suspended instance, have started=%d woken=%d", started, woken)
+	}
+
+	invoke(t, fe, "timers", "early", nil)
+	if woken != 0 {
+		t.Fatalf("Timer fired before it was due")
+	}
+	time.Sleep(60 * time.Millisecond)
+	invoke(t, fe, "timers", "due", nil)
+	if woken != 1 {
+		t.Fatalf("Expected the instance to resume, have woken=%d", woken)
+	}
+	invoke(t, fe, "timers", "again", nil)
+	if woken != 1 {
+		t.Fatalf("Timer fired twice, have woken=%d", woken)
+	}
+}
+
+func TestSleepRejectedInSyncInstance(t *testing.T) {
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"sleeper": func(env *Env) interface{} {
+			Sleep(env, 30*time.Millisecond)
+			return 0
+		},
+	})
+	start := time.Now()
+	expectFailure(t, invoke(t, fe, "sleeper", "sync", nil), "SyncSleep")
+	if elapsed := time.Since(start); elapsed >= 30*time.Millisecond {
+		t.Fatalf("Slept in place for %v", elapsed)
+	}
+	expectFailure(t, replay(t, fe, "sleeper", "sync", nil), "SyncSleep")
+}
+
+func TestScheduleAt(t *testing.T) {
+	inputs := make(chan interface{}, 2)
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"scheduler": func(env *Env) interface{} {
+			ScheduleAt(env, time.Now().Add(30*time.Millisecond), "target", "hello")
+			return 0
+		},
+		"target": func(env *Env) interface{} {
+			inputs <- env.Input
+			return 0
+		},
+		"timers": func(env *Env) interface{} {
+			FireTimers(env)
+			return 0
+		},
+	})
+	invoke(t, fe, "scheduler", "scheduler", nil)
+	replay(t, fe, "scheduler", "scheduler", nil)
+	time.Sleep(40 * time.Millisecond)
+	invoke(t, fe, "timers", "due", nil)
+	invoke(t, fe, "timers", "again", nil)
+	close(inputs)
+	var received []interface{}
+	for input := range inputs {
+		received = append(received, input)
+	}
+	if len(received) != 1 || received[0] != "hello" {
+		t.Fatalf("Expected target to run once with hello, have %v", received)
+	}
+}
+
+// scheduleTimers returns the environment of TestScheduleAt, where every
+// input target ran with is sent to inputs
+func scheduleTimers(t *testing.T, inputs chan interface{}) *localfaas.Environment {
+	return newTestEnv(t, map[string]func(env *Env) interface{}{
+		"scheduler": func(env *Env) interface{} {
+			return ScheduleAt(env, time.Now().Add(30*time.Millisecond), "target", "hello").InstanceId
+		},
+		"target": func(env *Env) interface{} {
+			inputs <- env.Input
+			return 0
+		},
+		"timers": func(env *Env) interface{} {
+			FireTimers(env)
+			return 0
+		},
+	})
+}
+
+func TestScheduleAtProposedTwiceFiresOnce(t *testing.T) {
+	inputs := make(chan interface{}, 2)
+	fe := scheduleTimers(t, inputs)
+	invoke(t, fe, "scheduler", "scheduler", nil)
+	// A concurrent run of the instance proposed the step as well, with
+	// another instance id for the target
+	env := &Env{FaasCtx: context.Background(), FaasEnv: fe}
+	fsm := getOrCreateIntentFsm("scheduler")
+	fsm.Catch(env)
+	logged := fsm.GetStepLog(0)
+	var wrapper InputWrapper
+	CHECK(mapstructure.Decode(logged.Data["wrapper"], &wrapper))
+	wrapper.InstanceId = "duplicate"
+	LibAppendLogWithTags(env, []uint64{IntentStepStreamTag("scheduler"), TimerLogTag}, &IntentLogEntry{
+		InstanceId: "scheduler",
+		StepNumber: 0,
+		Data: aws.JSONValue{
+			"type":    "ScheduleAt",
+			"at":      logged.Data["at"],
+			"callee":  "target",
+			"wrapper": wrapper,
+		},
+	})
+
+	time.Sleep(40 * time.Millisecond)
+	invoke(t, fe, "timers", "due", nil)
+	close(inputs)
+	if len(inputs) != 1 {
+		t.Fatalf("Expected target to run once, it ran %d times", len(inputs))
+	}
+}
+
+func TestFireTimersDropsDoneInstances(t *testing.T) {
+	inputs := make(chan interface{}, 2)
+	fe := scheduleTimers(t, inputs)
+	ow := invoke(t, fe, "scheduler", "scheduler", nil)
+	// The timer fired, and the target finished before the timer was marked
+	invoke(t, fe, "target", ow.Output.(string), "hello")
+	time.Sleep(40 * time.Millisecond)
+	invoke(t, fe, "timers", "due", nil)
+	close(inputs)
+	if len(inputs) != 1 {
+		t.Fatalf("Expected target to run once, it ran %d times", len(inputs))
+	}
+}
@@ -3,10 +3,10 @@ package cayonlib
 import (
 	"fmt"
 	"log"
-	"encoding/json"
 	"sync"
+	"time"
+	"cs.utexas.edu/zjia/faas/types"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/golang/snappy"
 	// "github.com/aws/aws-sdk-go/service/dynamodb/expression"
 )
 
@@ -16,13 +16,44 @@ type LockLogEntry struct {
 	StepNumber int32   `json:"step"`
 	UnlockOp   bool    `json:"unlockOp"`
 	Holder     string  `json:"holder"`
+	Lambda     string  `json:"lambda,omitempty"`
+	TxnTs      int64   `json:"txnTs,omitempty"`
+	TS         int64   `json:"ts,omitempty"`
+	Lease      int64   `json:"lease,omitempty"`
+	Broken     bool    `json:"broken,omitempty"`
+	Checkpoint bool    `json:"checkpoint,omitempty"`
 }
 
+// LockLease is how long a lock protects a holder whose transaction has not
+// been decided yet, unless the transaction was begun with BeginTxnWithLease
+var LockLease = 10 * time.Second
+
+// NO_WAIT, WAIT_DIE or WOUND_WAIT
+var LockPolicy = "NO_WAIT"
+
+var lockRetryInterval = 20 * time.Millisecond
+
 type LockFsm struct {
 	lockId     string
 	tailSeqNum uint64
 	stepNumber int32
 	tail       *LockLogEntry
+	checkpoint uint64
+	unsaved    int
+}
+
+// LockCheckpointInterval is the number of lock stream entries after which
+// catch saves the state of the lock in the aux data of the last one. A
+// process without the FSM starts from the latest saved state, so catching
+// up stays bounded although Boki cannot trim the stream.
+var LockCheckpointInterval = 16
+
+// lockAuxData is the state of a lock right after the entry holding it
+type lockAuxData struct {
+	LockId     string        `json:"lockId"`
+	StepNumber int32         `json:"step"`
+	Tail       *LockLogEntry `json:"tail"`
+	TailSeqNum uint64        `json:"tailSeqNum"`
 }
 
 var lockFsms      = map[string]LockFsm{}
@@ -54,19 +85,91 @@ func storeBackLockFsm(fsm LockFsm) {
 }
 
 func (fsm *LockFsm) catch(env *Env) {
+	FlushLogs(env)
 	tag := LockStreamTag(fsm.lockId)
+	if fsm.tailSeqNum == 0 {
+		for _, logEntry := range fsm.seek(env, tag) {
+			fsm.apply(logEntry, tag)
+		}
+	}
+	var last *types.LogEntry
 	for {
 		logEntry, err := env.FaasEnv.SharedLogReadNext(env.FaasCtx, tag, fsm.tailSeqNum)
 		CHECK(err)
 		if logEntry == nil {
 			break
 		}
-		decoded, err := snappy.Decode(nil, logEntry.Data)
+		fsm.apply(logEntry, tag)
+		last = logEntry
+	}
+	if last != nil && len(last.AuxData) == 0 && fsm.unsaved >= LockCheckpointInterval {
+		fsm.save(env, last.SeqNum)
+	}
+}
+
+// seek restores the latest state saved in the stream, and returns the
+// entries after it
+func (fsm *LockFsm) seek(env *Env, tag uint64) []*types.LogEntry {
+	entries := make([]*types.LogEntry, 0)
+	seqNum := ^uint64(0)
+	for {
+		logEntry, err := env.FaasEnv.SharedLogReadPrev(env.FaasCtx, tag, seqNum)
 		CHECK(err)
+		if logEntry == nil {
+			break
+		}
+		var aux lockAuxData
+		if len(logEntry.AuxData) > 0 && decodeRecord(logEntry.AuxData, &aux) == nil && aux.LockId == fsm.lockId {
+			if aux.Tail != nil {
+				aux.Tail.SeqNum = aux.TailSeqNum
+			}
+			fsm.tail = aux.Tail
+			fsm.stepNumber = aux.StepNumber
+			fsm.tailSeqNum = logEntry.SeqNum + 1
+			break
+		}
+		entries = append(entries, logEntry)
+		if logEntry.SeqNum == 0 {
+			break
+		}
+		seqNum = logEntry.SeqNum - 1
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+// save stores the state of the lock in the aux data of seqNum, the last
+// entry applied. Every process computes the same state from the log, so a
+// lost or concurrent save is harmless.
+func (fsm *LockFsm) save(env *Env, seqNum uint64) {
+	aux := &lockAuxData{
+		LockId:     fsm.lockId,
+		StepNumber: fsm.stepNumber,
+		Tail:       fsm.tail,
+	}
+	if fsm.tail != nil {
+		aux.TailSeqNum = fsm.tail.SeqNum
+	}
+	CHECK(env.FaasEnv.SharedLogSetAuxData(env.FaasCtx, seqNum, encodeRecord(aux)))
+	fsm.unsaved = 0
+}
+
+func (fsm *LockFsm) apply(logEntry *types.LogEntry, tag uint64) {
+	for _, decoded := range decodeLogEntry(logEntry, tag) {
 		var lockLog LockLogEntry
-		err = json.Unmarshal(decoded, &lockLog)
-		CHECK(err)
-		if lockLog.LockId == fsm.lockId && lockLog.StepNumber == fsm.stepNumber {
+		CHECK(decodeRecord(decoded, &lockLog))
+		if lockLog.LockId == fsm.lockId && lockLog.Checkpoint {
+			// A checkpoint behind our state is stale, and one ahead of it means
+			// the log below was trimmed
+			if lockLog.StepNumber >= fsm.stepNumber {
+				lockLog.SeqNum = logEntry.SeqNum
+				fsm.tail = &lockLog
+				fsm.stepNumber = lockLog.StepNumber
+				fsm.checkpoint = logEntry.SeqNum
+			}
+		} else if lockLog.LockId == fsm.lockId && lockLog.StepNumber == fsm.stepNumber {
 			// log.Printf("[INFO] Found my log: seqnum=%d, step=%d", logEntry.SeqNum, lockLog.StepNumber)
 			lockLog.SeqNum = logEntry.SeqNum
 			if lockLog.UnlockOp {
@@ -81,8 +184,9 @@ func (fsm *LockFsm) catch(env *Env) {
 			fsm.tail = &lockLog
 			fsm.stepNumber++
 		}
-		fsm.tailSeqNum = logEntry.SeqNum + 1
 	}
+	fsm.tailSeqNum = logEntry.SeqNum + 1
+	fsm.unsaved++
 }
 
 func (fsm *LockFsm) holder() string {
@@ -106,11 +210,83 @@ func (fsm *LockFsm) Lock(env *Env, holder string) bool {
 		StepNumber: fsm.stepNumber,
 		UnlockOp:   false,
 		Holder:     holder,
+		Lambda:     env.LambdaId,
+		TxnTs:      env.TxnTs,
+		TS:         nowMs(),
+		Lease:      txnLease(env),
 	})
 	fsm.catch(env)
 	return fsm.holder() == holder
 }
 
+// txnLease returns the lease in milliseconds of the locks env takes
+func txnLease(env *Env) int64 {
+	if env.TxnLease > 0 {
+		return env.TxnLease
+	}
+	return int64(LockLease / time.Millisecond)
+}
+
+// expired reports whether the lease of the lock holder has run out. Holders
+// that did not record a lease have the default one.
+func (fsm *LockFsm) expired() bool {
+	lease := fsm.tail.Lease
+	if lease == 0 {
+		lease = int64(LockLease / time.Millisecond)
+	}
+	return fsm.tail.TS+lease < nowMs()
+}
+
+// olderThan reports whether the transaction of env started before the one
+// holding the lock
+func (fsm *LockFsm) olderThan(env *Env) bool {
+	if env.TxnTs != fsm.tail.TxnTs {
+		return env.TxnTs < fsm.tail.TxnTs
+	}
+	return env.TxnId < fsm.tail.Holder
+}
+
+// tryBreak releases the lock on behalf of its holder once the holder's
+// transaction is known to abort. An undecided holder is aborted when its
+// lease has expired, or when it is wounded by an older transaction.
+func (fsm *LockFsm) tryBreak(env *Env) bool {
+	holder := fsm.tail.Holder
+	decision := getTxnDecision(env, holder)
+	if decision == "" {
+		expired := fsm.expired()
+		wound := LockPolicy == "WOUND_WAIT" && fsm.olderThan(env)
+		if !expired && !wound {
+			return false
+		}
+		decision = decideTxn(env, holder, "ABORT")
+		if decision == "ABORT" {
+			log.Printf("[WARN] Abort transaction %s holding lock %s (expired=%v)", holder, fsm.lockId, expired)
+		}
+	}
+	if decision != "ABORT" {
+		return false
+	}
+	LibAppendLog(env, LockStreamTag(fsm.lockId), &LockLogEntry{
+		LockId:     fsm.lockId,
+		StepNumber: fsm.stepNumber,
+		UnlockOp:   true,
+		Holder:     holder,
+		TS:         nowMs(),
+		Broken:     true,
+	})
+	return true
+}
+
+func (fsm *LockFsm) shouldWait(env *Env) bool {
+	switch LockPolicy {
+	case "WAIT_DIE":
+		return fsm.olderThan(env)
+	case "WOUND_WAIT":
+		return true
+	}
+	return false
+}
+
 func (fsm *LockFsm) Unlock(env *Env, holder string) {
 	fsm.catch(env)
 	if fsm.holder() != holder {
@@ -127,13 +303,32 @@ func (fsm *LockFsm) Unlock(env *Env, holder string) {
 
 func Lock(env *Env, tablename string, key string) bool {
 	lockId := fmt.Sprintf("%s-%s", tablename, key)
-	fsm := getOrCreateLockFsm(lockId)
-	success := fsm.Lock(env, env.TxnId)
-	storeBackLockFsm(fsm)
-	if !success {
-		log.Printf("[WARN] Failed to lock %s with txn %s", lockId, env.TxnId)
+	deadline := nowMs() + txnLease(env)
+	for {
+		fsm := getOrCreateLockFsm(lockId)
+		success := fsm.Lock(env, env.TxnId)
+		if !success && fsm.holder() != "" {
+			if fsm.tryBreak(env) {
+				storeBackLockFsm(fsm)
+				continue
+			}
+			if fsm.shouldWait(env) && nowMs() < deadline {
+				storeBackLockFsm(fsm)
+				time.Sleep(lockRetryInterval)
+				continue
+			}
+		} else if !success && nowMs() < deadline {
+			// Lost the race for an unlocked step
+			storeBackLockFsm(fsm)
+			time.Sleep(lockRetryInterval)
+			continue
+		}
+		storeBackLockFsm(fsm)
+		if !success {
+			log.Printf("[WARN] Failed to lock %s with txn %s", lockId, env.TxnId)
+		}
+		return success
 	}
-	return success
 }
 
 func Unlock(env *Env, tablename string, key string) {
@@ -143,7 +338,23 @@ func Unlock(env *Env, tablename string, key string) {
 	storeBackLockFsm(fsm)
 }
 
+// txnDecided reports whether the transaction of env is decided already,
+// which is the case when it is replayed after its commit or abort, or a
+// lock breaker aborted it. It takes no locks then.
+func txnDecided(env *Env) bool {
+	return getTxnDecision(env, env.TxnId) != ""
+}
+
+// TPLRead returns false if it cannot lock key. A decided transaction read
+// key only if it logged the read here.
 func TPLRead(env *Env, tablename string, key string) (bool, interface{}) {
+	if txnDecided(env) {
+		logged := env.Fsm.GetStepLog(env.StepNumber)
+		if logged == nil || logged.Data["type"] != "Read" {
+			return false, nil
+		}
+		return true, Read(env, tablename, key)
+	}
 	if Lock(env, tablename, key) {
 		return true, Read(env, tablename, key)
 	} else {
@@ -157,12 +368,23 @@ type TxnLogEntry struct {
 	TxnId    string        `json:"txnId"`
 	Callee   string        `json:"callee"`
 	WriteOp  aws.JSONValue `json:"write"`
+	ReadOp   aws.JSONValue `json:"read,omitempty"`
 }
 
+// TPLWrite returns false if it cannot lock key. A decided transaction
+// wrote key only if it recorded a write to it.
 func TPLWrite(env *Env, tablename string, key string, value aws.JSONValue) bool {
+	if txnDecided(env) {
+		for _, txnLog := range getAllTxnLogs(env) {
+			if txnLog.Callee == "" && txnLog.WriteOp["tablename"] == tablename && txnLog.WriteOp["key"] == key {
+				return true
+			}
+		}
+		return false
+	}
 	if Lock(env, tablename, key) {
 		tag := TransactionStreamTag(env.LambdaId, env.TxnId)
-		LibAppendLog(env, tag, &TxnLogEntry{
+		LibAppendLogWithTags(env, []uint64{tag}, &TxnLogEntry{
 			LambdaId: env.LambdaId,
 			TxnId:    env.TxnId,
 			Callee:   "",
@@ -178,23 +400,169 @@ func TPLWrite(env *Env, tablename string, key string, value aws.JSONValue) bool
 	}
 }
 
+type TxnDecisionEntry struct {
+	SeqNum   uint64 `json:"-"`
+	TxnId    string `json:"txnId"`
+	Decision string `json:"decision"`
+}
+
+// getTxnDecision returns the first decision recorded for txnId, or "" if
+// the transaction is still undecided. Participants learn the outcome of a
+// transaction from it.
+func getTxnDecision(env *Env, txnId string) string {
+	FlushLogs(env)
+	tag := TxnDecisionStreamTag(txnId)
+	seqNum := uint64(0)
+	for {
+		logEntry, err := env.FaasEnv.SharedLogReadNext(env.FaasCtx, tag, seqNum)
+		CHECK(err)
+		if logEntry == nil {
+			return ""
+		}
+		for _, decoded := range decodeLogEntry(logEntry, tag) {
+			var decision TxnDecisionEntry
+			err = decodeRecord(decoded, &decision)
+			CHECK(err)
+			if decision.TxnId == txnId {
+				return decision.Decision
+			}
+		}
+		seqNum = logEntry.SeqNum + 1
+	}
+}
+
+// decideTxn proposes a decision for txnId and returns the one that won
+func decideTxn(env *Env, txnId string, decision string) string {
+	if current := getTxnDecision(env, txnId); current != "" {
+		return current
+	}
+	LibAppendLog(env, TxnDecisionStreamTag(txnId), &TxnDecisionEntry{
+		TxnId:    txnId,
+		Decision: decision,
+	})
+	return getTxnDecision(env, txnId)
+}
+
+// 2PL or OCC, used by BeginTxn
+var DefaultTxnMode = "2PL"
+
 func BeginTxn(env *Env) {
+	BeginTxnWithMode(env, DefaultTxnMode)
+}
+
+func BeginTxnWithMode(env *Env, mode string) {
+	BeginTxnWithLease(env, mode, LockLease)
+}
+
+// legacyTxnSteps are the steps a transaction could log first before
+// BeginTxn was a step
+var legacyTxnSteps = map[string]bool{
+	"PreInvoke": true,
+	"PreWrite":  true,
+	"Read":      true,
+	"Scan":      true,
+}
+
+// isLegacyTxnStep reports whether logged, found where BeginTxn goes, was
+// logged by an instance started before BeginTxn was a step. Step records
+// have been timestamped since then, so an untimestamped record of a step
+// an old transaction could begin with is one.
+func isLegacyTxnStep(logged *IntentLogEntry) bool {
+	stepType, _ := logged.Data["type"].(string)
+	return logged.Ts == 0 && legacyTxnSteps[stepType]
+}
+
+// BeginTxnWithLease begins a transaction whose locks protect it for lease
+// while it is undecided. Transactions that run longer than LockLease
+// between taking a lock and committing need a longer one, or other
+// transactions may abort them.
+func BeginTxnWithLease(env *Env, mode string, lease time.Duration) {
+	if mode != "2PL" && mode != "OCC" {
+		panic(fmt.Sprintf("Unknown transaction mode %s", mode))
+	}
 	env.TxnId = env.InstanceId
+	env.TxnLease = int64(lease / time.Millisecond)
+	if logged := env.Fsm.GetStepLog(env.StepNumber); logged != nil && isLegacyTxnStep(logged) {
+		// It keeps its step numbering. Its start was not recorded, so it
+		// counts as starting now, and never wins against a transaction
+		// that really is younger.
+		env.TxnTs = nowMs()
+	} else {
+		newLog, intentLog := ProposeNextStep(env, aws.JSONValue{
+			"type":  "BeginTxn",
+			"ts":    nowMs(),
+			"lease": env.TxnLease,
+		})
+		if !newLog {
+			CheckLogDataField(intentLog, "type", "BeginTxn")
+			if lease, ok := LogInt(intentLog.Data["lease"]); ok {
+				env.TxnLease = lease
+			}
+		}
+		env.TxnTs, _ = LogInt(intentLog.Data["ts"])
+	}
+	env.TxnMode = mode
 	env.Instruction = "EXECUTE"
 }
 
-func CommitTxn(env *Env) {
+func TxnRead(env *Env, tablename string, key string) (bool, interface{}) {
+	if env.TxnMode == "OCC" {
+		return true, OCCRead(env, tablename, key)
+	}
+	return TPLRead(env, tablename, key)
+}
+
+func TxnWrite(env *Env, tablename string, key string, value aws.JSONValue) bool {
+	if env.TxnMode == "OCC" {
+		OCCWrite(env, tablename, key, value)
+		return true
+	}
+	return TPLWrite(env, tablename, key, value)
+}
+
+func resetTxn(env *Env) {
+	env.TxnId = ""
+	env.TxnTs = 0
+	env.TxnLease = 0
+	env.TxnMode = ""
+	env.Instruction = ""
+}
+
+// CommitTxn returns false if the transaction was aborted by a lock breaker,
+// a participant voted NO in the prepare round, or it failed OCC validation,
+// in which case it has been rolled back
+func CommitTxn(env *Env) bool {
+	if env.TxnMode == "OCC" {
+		committed := OCCCommit(env)
+		if !committed {
+			log.Printf("[WARN] Transaction %s failed validation", env.TxnId)
+		}
+		resetTxn(env)
+		return committed
+	}
+	env.Instruction = "PREPARE"
+	vote := "ABORT"
+	if TPLPrepare(env) {
+		vote = "COMMIT"
+	}
+	if decideTxn(env, env.TxnId, vote) != "COMMIT" {
+		AbortTxn(env)
+		return false
+	}
 	log.Printf("[INFO] Commit transaction %s", env.TxnId)
 	env.Instruction = "COMMIT"
 	TPLCommit(env)
-	env.TxnId = ""
-	env.Instruction = ""
+	resetTxn(env)
+	return true
 }
 
 func AbortTxn(env *Env) {
 	log.Printf("[WARN] Abort transaction %s", env.TxnId)
-	env.Instruction = "ABORT"
-	TPLAbort(env)
-	env.TxnId = ""
-	env.Instruction = ""
+	if env.TxnMode != "OCC" {
+		// OCC holds no locks and buffered writes are simply dropped
+		decideTxn(env, env.TxnId, "ABORT")
+		env.Instruction = "ABORT"
+		TPLAbort(env)
+	}
+	resetTxn(env)
 }
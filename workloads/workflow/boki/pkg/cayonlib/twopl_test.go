@@ -0,0 +1,150 @@
+package cayonlib
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/eniac/Beldi/pkg/localfaas"
+)
+
+// newTxnEnv returns an env of instanceId whose step stream holds logged
+func newTxnEnv(instanceId string, logged ...*IntentLogEntry) *Env {
+	env := &Env{
+		LambdaId:   "test",
+		InstanceId: instanceId,
+		FaasCtx:    context.Background(),
+		FaasEnv:    localfaas.NewEnvironment(),
+		Fsm:        NewIntentFsm(instanceId),
+	}
+	for _, intentLog := range logged {
+		env.Fsm.applyLog(intentLog)
+	}
+	return env
+}
+
+func TestBeginTxnKeepsLegacyNumbering(t *testing.T) {
+	env := newTxnEnv("legacy", &IntentLogEntry{
+		InstanceId: "legacy",
+		StepNumber: 0,
+		Data:       aws.JSONValue{"type": "Read"},
+	})
+	start := nowMs()
+	BeginTxn(env)
+	if env.StepNumber != 0 {
+		t.Fatalf("BeginTxn of a legacy instance took step %d", env.StepNumber)
+	}
+	if env.TxnTs < start {
+		t.Fatalf("Legacy transaction has timestamp %d before %d", env.TxnTs, start)
+	}
+}
+
+func TestBeginTxnRejectsOtherSteps(t *testing.T) {
+	for _, data := range []aws.JSONValue{{"type": "Read"}, {"type": "Sleep"}} {
+		intentLog := &IntentLogEntry{InstanceId: "new", StepNumber: 0, Data: data}
+		if data["type"] == "Read" {
+			// A timestamped record was logged after BeginTxn became a step
+			intentLog.Ts = nowMs()
+		}
+		func() {
+			defer func() {
+				if _, ok := recover().(*LogMismatchError); !ok {
+					t.Fatalf("BeginTxn accepted %+v", intentLog)
+				}
+			}()
+			BeginTxn(newTxnEnv("new", intentLog))
+		}()
+	}
+}
+
+func TestBeginTxnReplaysLease(t *testing.T) {
+	env := newTxnEnv("txn")
+	BeginTxnWithLease(env, "2PL", time.Minute)
+	replayed := newTxnEnv("txn", env.Fsm.GetStepLog(0))
+	replayed.FaasEnv = env.FaasEnv
+	BeginTxn(replayed)
+	if replayed.TxnTs != env.TxnTs || replayed.TxnLease != int64(time.Minute/time.Millisecond) {
+		t.Fatalf("Replay has ts=%d lease=%d, expected ts=%d lease=%d",
+			replayed.TxnTs, replayed.TxnLease, env.TxnTs, time.Minute/time.Millisecond)
+	}
+}
+
+func TestLockLeaseIsPerTxn(t *testing.T) {
+	dropLockFsms()
+	defer dropLockFsms()
+	env := newTxnEnv("long")
+	BeginTxnWithLease(env, "2PL", time.Hour)
+	if !Lock(env, "table", "long") {
+		t.Fatalf("Failed to lock with %s", env.TxnId)
+	}
+	short := newTxnEnv("short")
+	short.FaasEnv = env.FaasEnv
+	BeginTxnWithLease(short, "2PL", time.Millisecond)
+	if !Lock(short, "table", "short") {
+		t.Fatalf("Failed to lock with %s", short.TxnId)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	other := newTxnEnv("other")
+	other.FaasEnv = env.FaasEnv
+	BeginTxn(other)
+	if Lock(other, "table", "long") {
+		t.Fatalf("Broke the lock of %s within its lease", env.TxnId)
+	}
+	if !Lock(other, "table", "short") {
+		t.Fatalf("Failed to break the lock of %s after its lease", short.TxnId)
+	}
+	if decision := getTxnDecision(other, "short"); decision != "ABORT" {
+		t.Fatalf("Expected %s to be aborted, have %q", short.TxnId, decision)
+	}
+}
+
+func TestTwoPhaseCommitWithCallee(t *testing.T) {
+	dropLockFsms()
+	defer dropLockFsms()
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"caller": func(env *Env) interface{} {
+			BeginTxn(env)
+			TxnWrite(env, "tpc", "a", aws.JSONValue{"V": env.Input})
+			SyncInvoke(env, "callee", env.Input)
+			return CommitTxn(env)
+		},
+		"callee": func(env *Env) interface{} {
+			TxnWrite(env, "tpc", "b", aws.JSONValue{"V": env.Input})
+			if env.Input == "lost" {
+				// Loses its lock before the prepare round
+				Unlock(env, "tpc", "b")
+			}
+			return 0
+		},
+	})
+	CreateMainTable("tpc")
+	value := func(key string) interface{} {
+		return LibRead("tpc", aws.JSONValue{"K": key}, []string{"V"})["V"]
+	}
+
+	if ow := invoke(t, fe, "caller", "ok", "ok"); ow.Output != true {
+		t.Fatalf("Expected commit, have %+v", ow)
+	}
+	if value("a") != "ok" || value("b") != "ok" {
+		t.Fatalf("Committed a=%v b=%v", value("a"), value("b"))
+	}
+	if ow := replay(t, fe, "caller", "ok", "ok"); ow.Output != true {
+		t.Fatalf("Expected replayed commit, have %+v", ow)
+	}
+
+	if ow := invoke(t, fe, "caller", "lost", "lost"); ow.Output != false {
+		t.Fatalf("Expected the callee to vote NO, have %+v", ow)
+	}
+	if value("a") != "ok" || value("b") != "ok" {
+		t.Fatalf("Aborted transaction wrote a=%v b=%v", value("a"), value("b"))
+	}
+	// The abort released the locks of caller and callee
+	if ow := invoke(t, fe, "caller", "again", "again"); ow.Output != true {
+		t.Fatalf("Expected commit after the abort, have %+v", ow)
+	}
+	if value("a") != "again" || value("b") != "again" {
+		t.Fatalf("Committed a=%v b=%v", value("a"), value("b"))
+	}
+}
@@ -0,0 +1,60 @@
+package cayonlib
+
+import (
+	"log"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/lithammer/shortuuid"
+)
+
+// logValue logs the value made by f as a step the first time, and returns
+// the logged value on replay
+func logValue(env *Env, kind string, f func() interface{}) interface{} {
+	step := env.StepNumber
+	newLog := false
+	intentLog := env.Fsm.GetStepLog(step)
+	if intentLog != nil {
+		env.StepNumber += 1
+	} else {
+		newLog, intentLog = ProposeNextStep(env, aws.JSONValue{
+			"type":  kind,
+			"value": f(),
+		})
+	}
+	if !newLog {
+		CheckLogDataField(intentLog, "type", kind)
+		log.Printf("[INFO] Seen %s log for step %d", kind, intentLog.StepNumber)
+	}
+	return intentLog.Data["value"]
+}
+
+// UUID is shortuuid.New() as a step
+func UUID(env *Env) string {
+	return logValue(env, "UUID", func() interface{} {
+		return shortuuid.New()
+	}).(string)
+}
+
+// Now is time.Now() as a step
+func Now(env *Env) time.Time {
+	value := logValue(env, "Now", func() interface{} {
+		return time.Now().Format(time.RFC3339Nano)
+	}).(string)
+	t, err := time.Parse(time.RFC3339Nano, value)
+	CHECK(err)
+	return t
+}
+
+// Rand returns a generator seeded with a seed logged as a step, which draws
+// the same numbers on replay
+func Rand(env *Env) *rand.Rand {
+	value := logValue(env, "Rand", func() interface{} {
+		return strconv.FormatInt(time.Now().UnixNano(), 10)
+	}).(string)
+	seed, err := strconv.ParseInt(value, 10, 64)
+	CHECK(err)
+	return rand.New(rand.NewSource(seed))
+}
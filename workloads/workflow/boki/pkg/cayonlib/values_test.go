@@ -0,0 +1,34 @@
+package cayonlib
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValuesReplay(t *testing.T) {
+	fe := newTestEnv(t, map[string]func(env *Env) interface{}{
+		"values": func(env *Env) interface{} {
+			return []interface{}{UUID(env), Now(env).Format(time.RFC3339Nano), Rand(env).Int63()}
+		},
+		"reordered": func(env *Env) interface{} {
+			return []interface{}{Now(env), UUID(env)}
+		},
+	})
+	first := invoke(t, fe, "values", "first", nil)
+	time.Sleep(time.Millisecond)
+	if replayed := replay(t, fe, "values", "first", nil); !reflect.DeepEqual(replayed.Output, first.Output) {
+		t.Fatalf("Replay returned %v, the run %v", replayed.Output, first.Output)
+	}
+	second := invoke(t, fe, "values", "second", nil)
+	for i, v := range second.Output.([]interface{}) {
+		if v == first.Output.([]interface{})[i] {
+			t.Fatalf("Instances share value %d: %v", i, v)
+		}
+	}
+	// A value logged as a UUID is no time
+	if err := crash(t, fe, "reordered", "first", nil); !strings.Contains(err.Error(), "LogMismatch") {
+		t.Fatalf("Expected a LogMismatch crash, have %v", err)
+	}
+}
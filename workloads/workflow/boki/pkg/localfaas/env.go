@@ -0,0 +1,301 @@
+package localfaas
+
+import (
+	"cs.utexas.edu/zjia/faas/types"
+
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// Environment is an in-process stand-in for the Boki engine. It implements
+// the shared log and function invocation surface of types.Environment, so
+// that workflows built on cayonlib can run inside a single process.
+type Environment struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	nextSeq  uint64
+	nextId   uint64
+	entries  []*types.LogEntry
+	tagIndex map[uint64][]*types.LogEntry
+	liveTags map[uint64]int
+
+	funcsMu  sync.RWMutex
+	handlers map[string]types.FuncHandler
+
+	pending sync.WaitGroup
+	errsMu  sync.Mutex
+	errs    []error
+}
+
+func NewEnvironment() *Environment {
+	env := &Environment{
+		nextSeq:  1,
+		nextId:   1,
+		entries:  make([]*types.LogEntry, 0),
+		tagIndex: make(map[uint64][]*types.LogEntry),
+		liveTags: make(map[uint64]int),
+		handlers: make(map[string]types.FuncHandler),
+	}
+	env.cond = sync.NewCond(&env.mu)
+	return env
+}
+
+func (env *Environment) Register(funcName string, factory types.FuncHandlerFactory) {
+	handler, err := factory.New(env, funcName)
+	if err != nil {
+		panic(err)
+	}
+	env.funcsMu.Lock()
+	env.handlers[funcName] = handler
+	env.funcsMu.Unlock()
+}
+
+func (env *Environment) getHandler(funcName string) (types.FuncHandler, error) {
+	env.funcsMu.RLock()
+	handler, exists := env.handlers[funcName]
+	env.funcsMu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("Function %s is not registered", funcName)
+	}
+	return handler, nil
+}
+
+func (env *Environment) call(ctx context.Context, funcName string, input []byte) (output []byte, err error) {
+	handler, err := env.getHandler(funcName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[ERROR] Function %s crashed: %v", funcName, r)
+			output = nil
+			err = fmt.Errorf("Function %s crashed: %v", funcName, r)
+		}
+	}()
+	return handler.Call(ctx, input)
+}
+
+func (env *Environment) InvokeFunc(ctx context.Context, funcName string, input []byte) ([]byte, error) {
+	return env.call(ctx, funcName, input)
+}
+
+func (env *Environment) InvokeFuncAsync(ctx context.Context, funcName string, input []byte) error {
+	if _, err := env.getHandler(funcName); err != nil {
+		return err
+	}
+	env.pending.Add(1)
+	go func() {
+		defer env.pending.Done()
+		if _, err := env.call(context.Background(), funcName, input); err != nil {
+			env.errsMu.Lock()
+			env.errs = append(env.errs, err)
+			env.errsMu.Unlock()
+		}
+	}()
+	return nil
+}
+
+// Wait blocks until all asynchronous invocations issued so far, including
+// the ones they issue transitively, have returned. It returns the errors of
+// failed asynchronous invocations.
+func (env *Environment) Wait() []error {
+	env.pending.Wait()
+	env.errsMu.Lock()
+	errs := env.errs
+	env.errs = nil
+	env.errsMu.Unlock()
+	return errs
+}
+
+func (env *Environment) GrpcCall(ctx context.Context, service string, method string, request []byte) ([]byte, error) {
+	return nil, fmt.Errorf("Not implemented")
+}
+
+func (env *Environment) GenerateUniqueID() uint64 {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	id := env.nextId
+	env.nextId++
+	return id
+}
+
+func copyLogEntry(entry *types.LogEntry) *types.LogEntry {
+	return &types.LogEntry{
+		SeqNum:  entry.SeqNum,
+		Tags:    append([]uint64(nil), entry.Tags...),
+		Data:    append([]byte(nil), entry.Data...),
+		AuxData: append([]byte(nil), entry.AuxData...),
+	}
+}
+
+func (env *Environment) SharedLogAppend(ctx context.Context, tags []uint64, data []byte) (uint64, error) {
+	for _, tag := range tags {
+		if tag == 0 || (^tag) == 0 {
+			return 0, fmt.Errorf("Invalid tag %d", tag)
+		}
+	}
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	entry := &types.LogEntry{
+		SeqNum: env.nextSeq,
+		Tags:   append([]uint64(nil), tags...),
+		Data:   append([]byte(nil), data...),
+	}
+	env.nextSeq++
+	env.entries = append(env.entries, entry)
+	for _, tag := range tags {
+		env.tagIndex[tag] = append(env.tagIndex[tag], entry)
+	}
+	if len(tags) > 0 {
+		env.liveTags[entry.SeqNum] = len(tags)
+	}
+	env.cond.Broadcast()
+	return entry.SeqNum, nil
+}
+
+// stream returns the entries visible under tag, in seqnum order. Tag 0
+// selects the whole log. Caller must hold env.mu.
+func (env *Environment) stream(tag uint64) []*types.LogEntry {
+	if tag == 0 {
+		return env.entries
+	}
+	return env.tagIndex[tag]
+}
+
+func (env *Environment) readNext(tag uint64, seqNum uint64) *types.LogEntry {
+	entries := env.stream(tag)
+	idx := sort.Search(len(entries), func(i int) bool {
+		return entries[i].SeqNum >= seqNum
+	})
+	if idx == len(entries) {
+		return nil
+	}
+	return copyLogEntry(entries[idx])
+}
+
+func (env *Environment) readPrev(tag uint64, seqNum uint64) *types.LogEntry {
+	entries := env.stream(tag)
+	idx := sort.Search(len(entries), func(i int) bool {
+		return entries[i].SeqNum > seqNum
+	})
+	if idx == 0 {
+		return nil
+	}
+	return copyLogEntry(entries[idx-1])
+}
+
+func (env *Environment) SharedLogReadNext(ctx context.Context, tag uint64, seqNum uint64) (*types.LogEntry, error) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	return env.readNext(tag, seqNum), nil
+}
+
+func (env *Environment) SharedLogReadNextBlock(ctx context.Context, tag uint64, seqNum uint64) (*types.LogEntry, error) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			env.mu.Lock()
+			env.cond.Broadcast()
+			env.mu.Unlock()
+		case <-done:
+		}
+	}()
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	for {
+		if entry := env.readNext(tag, seqNum); entry != nil {
+			return entry, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		env.cond.Wait()
+	}
+}
+
+func (env *Environment) SharedLogReadPrev(ctx context.Context, tag uint64, seqNum uint64) (*types.LogEntry, error) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	return env.readPrev(tag, seqNum), nil
+}
+
+func (env *Environment) SharedLogCheckTail(ctx context.Context, tag uint64) (*types.LogEntry, error) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	return env.readPrev(tag, ^uint64(0)), nil
+}
+
+func (env *Environment) SharedLogSetAuxData(ctx context.Context, seqNum uint64, auxData []byte) error {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	idx := sort.Search(len(env.entries), func(i int) bool {
+		return env.entries[i].SeqNum >= seqNum
+	})
+	if idx == len(env.entries) || env.entries[idx].SeqNum != seqNum {
+		return fmt.Errorf("Cannot find log entry with seqnum %d", seqNum)
+	}
+	env.entries[idx].AuxData = append([]byte(nil), auxData...)
+	return nil
+}
+
+// SharedLogTrim drops the entries of tag with seqnum below seqNum. Tag 0
+// trims the whole log. An entry leaves the global log once it has been
+// trimmed from all of its tags.
+func (env *Environment) SharedLogTrim(ctx context.Context, tag uint64, seqNum uint64) error {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if tag == 0 {
+		for tag := range env.tagIndex {
+			env.trimTag(tag, seqNum)
+		}
+		env.compact(seqNum)
+	} else if env.trimTag(tag, seqNum) {
+		env.compact(0)
+	}
+	return nil
+}
+
+// trimTag reports whether some entry lost its last tag. Caller must hold
+// env.mu.
+func (env *Environment) trimTag(tag uint64, seqNum uint64) bool {
+	entries := env.tagIndex[tag]
+	idx := sort.Search(len(entries), func(i int) bool {
+		return entries[i].SeqNum >= seqNum
+	})
+	if idx == 0 {
+		return false
+	}
+	dropped := false
+	for _, entry := range entries[:idx] {
+		env.liveTags[entry.SeqNum]--
+		if env.liveTags[entry.SeqNum] == 0 {
+			dropped = true
+		}
+	}
+	if idx == len(entries) {
+		delete(env.tagIndex, tag)
+	} else {
+		env.tagIndex[tag] = append([]*types.LogEntry(nil), entries[idx:]...)
+	}
+	return dropped
+}
+
+// compact removes from the global log the entries below seqNum and the ones
+// without any remaining tag. Caller must hold env.mu.
+func (env *Environment) compact(seqNum uint64) {
+	kept := make([]*types.LogEntry, 0, len(env.entries))
+	for _, entry := range env.entries {
+		count, tagged := env.liveTags[entry.SeqNum]
+		if entry.SeqNum < seqNum || (tagged && count == 0) {
+			delete(env.liveTags, entry.SeqNum)
+		} else {
+			kept = append(kept, entry)
+		}
+	}
+	env.entries = kept
+}
@@ -0,0 +1,153 @@
+package localfaas
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cs.utexas.edu/zjia/faas/types"
+)
+
+func seqNums(t *testing.T, env *Environment, tag uint64) []uint64 {
+	t.Helper()
+	var res []uint64
+	for seqNum := uint64(0); ; {
+		entry, err := env.SharedLogReadNext(context.Background(), tag, seqNum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			return res
+		}
+		res = append(res, entry.SeqNum)
+		seqNum = entry.SeqNum + 1
+	}
+}
+
+func equal(a []uint64, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSharedLogTags(t *testing.T) {
+	env := NewEnvironment()
+	ctx := context.Background()
+	for _, tags := range [][]uint64{{1}, {2}, {1, 2}, {}} {
+		if _, err := env.SharedLogAppend(ctx, tags, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := env.SharedLogAppend(ctx, []uint64{0}, nil); err == nil {
+		t.Fatalf("Appended with the reserved tag 0")
+	}
+	for tag, expected := range map[uint64][]uint64{0: {1, 2, 3, 4}, 1: {1, 3}, 2: {2, 3}, 3: nil} {
+		if have := seqNums(t, env, tag); !equal(have, expected) {
+			t.Errorf("Tag %d: expected %v, have %v", tag, expected, have)
+		}
+	}
+	if entry, _ := env.SharedLogReadPrev(ctx, 1, 2); entry == nil || entry.SeqNum != 1 {
+		t.Errorf("Expected 1 before 2 under tag 1, have %v", entry)
+	}
+	if entry, _ := env.SharedLogCheckTail(ctx, 2); entry == nil || entry.SeqNum != 3 {
+		t.Errorf("Expected tail 3 of tag 2, have %v", entry)
+	}
+	// Readers get copies
+	entry, _ := env.SharedLogReadNext(ctx, 1, 0)
+	entry.Data[0] = 'y'
+	if entry, _ = env.SharedLogReadNext(ctx, 1, 0); string(entry.Data) != "x" {
+		t.Errorf("Log entry was modified through a read")
+	}
+}
+
+func TestSharedLogTrim(t *testing.T) {
+	env := NewEnvironment()
+	ctx := context.Background()
+	for _, tags := range [][]uint64{{1}, {1, 2}, {1}, {2}} {
+		env.SharedLogAppend(ctx, tags, nil)
+	}
+	env.SharedLogTrim(ctx, 1, 3)
+	if have := seqNums(t, env, 1); !equal(have, []uint64{3}) {
+		t.Errorf("Expected [3] under tag 1, have %v", have)
+	}
+	// 2 stays under tag 2, 1 lost its last tag
+	if have := seqNums(t, env, 0); !equal(have, []uint64{2, 3, 4}) {
+		t.Errorf("Expected [2 3 4] in the log, have %v", have)
+	}
+	env.SharedLogTrim(ctx, 0, 4)
+	if have := seqNums(t, env, 0); !equal(have, []uint64{4}) {
+		t.Errorf("Expected [4] in the log, have %v", have)
+	}
+	if seqNum, _ := env.SharedLogAppend(ctx, []uint64{1}, nil); seqNum != 5 {
+		t.Errorf("Seqnums must not be reused, have %d", seqNum)
+	}
+}
+
+func TestSharedLogReadNextBlock(t *testing.T) {
+	env := NewEnvironment()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		env.SharedLogAppend(context.Background(), []uint64{2}, nil)
+		env.SharedLogAppend(context.Background(), []uint64{1}, nil)
+	}()
+	entry, err := env.SharedLogReadNextBlock(context.Background(), 1, 0)
+	if err != nil || entry.SeqNum != 2 {
+		t.Fatalf("Expected entry 2, have %v %v", entry, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := env.SharedLogReadNextBlock(ctx, 1, 3); err == nil {
+		t.Fatalf("Expected the read to end with its context")
+	}
+}
+
+type funcHandler func(ctx context.Context, input []byte) ([]byte, error)
+
+func (f funcHandler) Call(ctx context.Context, input []byte) ([]byte, error) {
+	return f(ctx, input)
+}
+
+func (f funcHandler) New(env types.Environment, funcName string) (types.FuncHandler, error) {
+	return f, nil
+}
+
+func (f funcHandler) GrpcNew(env types.Environment, service string) (types.GrpcFuncHandler, error) {
+	return nil, nil
+}
+
+func TestInvokeFuncAsync(t *testing.T) {
+	env := NewEnvironment()
+	ctx := context.Background()
+	calls := make(chan string, 3)
+	env.Register("child", funcHandler(func(ctx context.Context, input []byte) ([]byte, error) {
+		calls <- string(input)
+		if string(input) == "crash" {
+			panic("crash")
+		}
+		return input, nil
+	}))
+	env.Register("parent", funcHandler(func(ctx context.Context, input []byte) ([]byte, error) {
+		// Wait covers invocations issued by asynchronous ones
+		return nil, env.InvokeFuncAsync(ctx, "child", input)
+	}))
+	if err := env.InvokeFuncAsync(ctx, "missing", nil); err == nil {
+		t.Fatalf("Invoked a function that is not registered")
+	}
+	env.InvokeFuncAsync(ctx, "parent", []byte("ok"))
+	env.InvokeFuncAsync(ctx, "parent", []byte("crash"))
+	if errs := env.Wait(); len(errs) != 1 {
+		t.Fatalf("Expected the crash only, have %v", errs)
+	}
+	if len(calls) != 2 {
+		t.Fatalf("Expected 2 calls of child, have %d", len(calls))
+	}
+	if _, err := env.InvokeFunc(ctx, "child", []byte("crash")); err == nil {
+		t.Fatalf("Expected a crash to be returned as error")
+	}
+}
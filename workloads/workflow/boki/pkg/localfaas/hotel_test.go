@@ -0,0 +1,205 @@
+package localfaas_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"cs.utexas.edu/zjia/faas-memdb"
+	"github.com/eniac/Beldi/internal/hotel/main/data"
+	"github.com/eniac/Beldi/internal/hotel/main/flight"
+	"github.com/eniac/Beldi/internal/hotel/main/frontend"
+	"github.com/eniac/Beldi/internal/hotel/main/hotel"
+	"github.com/eniac/Beldi/internal/hotel/main/order"
+	"github.com/eniac/Beldi/internal/hotel/main/rate"
+	"github.com/eniac/Beldi/pkg/cayonlib"
+	"github.com/eniac/Beldi/pkg/localfaas"
+)
+
+// newHotelEnv runs the services of the hotel reservation flow, as their
+// handlers register them, on a fresh memdb
+func newHotelEnv(t *testing.T) *localfaas.Environment {
+	dbClient := cayonlib.DBClient
+	cayonlib.DBClient = memdb.New()
+	cayonlib.ResetIntentFsmCache()
+	t.Cleanup(func() {
+		cayonlib.DBClient = dbClient
+	})
+	for _, table := range []string{data.Thotel(), data.Tflight(), data.Torder()} {
+		cayonlib.CreateLambdaTables(table)
+	}
+	cayonlib.Populate(data.Thotel(), "h1", hotel.Hotel{HotelId: "h1", Cap: 1, Customers: []string{}}, false)
+	cayonlib.Populate(data.Tflight(), "f1", flight.Flight{FlightId: "f1", Cap: 1, Customers: []string{}}, false)
+	cayonlib.Populate(data.Tflight(), "f0", flight.Flight{FlightId: "f0", Cap: 0, Customers: []string{}}, false)
+
+	fe := localfaas.NewEnvironment()
+	fe.Register(data.Thotel(), cayonlib.CreateFuncHandlerFactory(cayonlib.NewService().
+		Register("ReserveHotel", func(env *cayonlib.Env, req data.ReserveHotelRequest) bool {
+			return hotel.ReserveHotel(env, req.HotelId, req.UserId)
+		}).
+		Register("HoldHotel", func(env *cayonlib.Env, req data.ReserveHotelRequest) bool {
+			return hotel.HoldHotel(env, req.HotelId, req.UserId)
+		}).
+		Register("CancelHotel", func(env *cayonlib.Env, req data.ReserveHotelRequest) error {
+			return hotel.CancelHotel(env, req.HotelId, req.UserId)
+		}).Handler))
+	fe.Register(data.Tflight(), cayonlib.CreateFuncHandlerFactory(cayonlib.NewService().
+		Register("ReserveFlight", func(env *cayonlib.Env, req data.ReserveFlightRequest) bool {
+			return flight.ReserveFlight(env, req.FlightId, req.UserId)
+		}).
+		Register("HoldFlight", func(env *cayonlib.Env, req data.ReserveFlightRequest) bool {
+			return flight.HoldFlight(env, req.FlightId, req.UserId)
+		}).Handler))
+	fe.Register(data.Torder(), cayonlib.CreateFuncHandlerFactory(cayonlib.NewService().
+		Register("PlaceOrder", func(env *cayonlib.Env, req data.PlaceOrderRequest) {
+			order.PlaceOrder(env, req.UserId, req.FlightId, req.HotelId)
+		}).Handler))
+	fe.Register(data.Tfrontend(), cayonlib.CreateFuncHandlerFactory(func(env *cayonlib.Env) interface{} {
+		var req data.PlaceOrderRequest
+		cayonlib.DecodeValue(env.Input, &req)
+		return frontend.SendRequest(env, req.UserId, req.FlightId, req.HotelId)
+	}))
+	fe.Register("saga", cayonlib.CreateFuncHandlerFactory(func(env *cayonlib.Env) interface{} {
+		var req data.PlaceOrderRequest
+		cayonlib.DecodeValue(env.Input, &req)
+		return frontend.SagaSendRequest(env, req.UserId, req.FlightId, req.HotelId)
+	}))
+	return fe
+}
+
+func sendRequest(t *testing.T, fe *localfaas.Environment, instanceId string, req data.PlaceOrderRequest) interface{} {
+	t.Helper()
+	return invokeFrontend(t, fe, data.Tfrontend(), instanceId, req)
+}
+
+func invokeFrontend(t *testing.T, fe *localfaas.Environment, funcName string, instanceId string, req data.PlaceOrderRequest) interface{} {
+	t.Helper()
+	iw := cayonlib.InputWrapper{InstanceId: instanceId, Input: req}
+	res, err := fe.InvokeFunc(context.Background(), funcName, iw.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := fe.Wait(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	var ow cayonlib.OutputWrapper
+	ow.Deserialize(res)
+	if ow.Status != "Success" {
+		t.Fatalf("Request %s failed: %+v", instanceId, ow.Error)
+	}
+	return ow.Output
+}
+
+func orders(t *testing.T) []interface{} {
+	t.Helper()
+	var res []interface{}
+	for _, item := range cayonlib.LibScan(data.Torder(), []string{"V"}) {
+		res = append(res, item["V"])
+	}
+	return res
+}
+
+func TestSendRequest(t *testing.T) {
+	fe := newHotelEnv(t)
+	req := data.PlaceOrderRequest{UserId: "u1", FlightId: "f1", HotelId: "h1"}
+	if res := sendRequest(t, fe, "ok", req); res != "Place Order Success" {
+		t.Fatalf("Expected success, have %v", res)
+	}
+	placed := orders(t)
+	if len(placed) != 1 {
+		t.Fatalf("Expected one order, have %v", placed)
+	}
+	if o := placed[0].(map[string]interface{}); o["HotelId"] != "h1" || o["FlightId"] != "f1" || o["UserId"] != "u1" {
+		t.Fatalf("Unexpected order %v", o)
+	}
+
+	// A re-execution replays the logged steps and places no second order
+	cayonlib.ResetIntentFsmCache()
+	if res := sendRequest(t, fe, "ok", req); res != "Place Order Success" {
+		t.Fatalf("Replay returned %v", res)
+	}
+	if placed = orders(t); len(placed) != 1 {
+		t.Fatalf("Replay placed another order: %v", placed)
+	}
+
+	// The flight is full, the transaction aborts and releases the hotel
+	full := data.PlaceOrderRequest{UserId: "u2", FlightId: "f0", HotelId: "h1"}
+	if res := sendRequest(t, fe, "full", full); res != "Place Order Fails" {
+		t.Fatalf("Expected failure, have %v", res)
+	}
+	if res := sendRequest(t, fe, "again", req); res != "Place Order Success" {
+		t.Fatalf("Hotel is still locked after the abort: %v", res)
+	}
+	if placed = orders(t); len(placed) != 2 {
+		t.Fatalf("Expected two orders, have %v", placed)
+	}
+}
+
+func TestSagaSendRequest(t *testing.T) {
+	fe := newHotelEnv(t)
+	// The flight is full, the hold of the hotel is cancelled
+	full := data.PlaceOrderRequest{UserId: "u1", FlightId: "f0", HotelId: "h1"}
+	if res := invokeFrontend(t, fe, "saga", "full", full); res != "Place Order Fails" {
+		t.Fatalf("Expected failure, have %v", res)
+	}
+	if item := cayonlib.LibRead(data.Thotel(), map[string]interface{}{"K": "h1"}, []string{"V"}); item["V"].(map[string]interface{})["Cap"] != 1.0 {
+		t.Fatalf("The hotel seat was not given back: %v", item)
+	}
+	req := data.PlaceOrderRequest{UserId: "u2", FlightId: "f1", HotelId: "h1"}
+	if res := invokeFrontend(t, fe, "saga", "ok", req); res != "Place Order Success" {
+		t.Fatalf("Expected success, have %v", res)
+	}
+	if placed := orders(t); len(placed) != 1 {
+		t.Fatalf("Expected one order, have %v", placed)
+	}
+}
+
+func TestGetRatesKeepsPerHotelReads(t *testing.T) {
+	fe := newHotelEnv(t)
+	cayonlib.CreateLambdaTables(data.Trate())
+	for _, id := range []string{"h1", "h2"} {
+		cayonlib.Populate(data.Trate(), id, data.RatePlan{HotelId: id, Code: "c" + id}, false)
+	}
+	req := rate.Request{HotelIds: []string{"h2", "h1", "h3"}}
+	fe.Register("rates", cayonlib.CreateFuncHandlerFactory(func(env *cayonlib.Env) interface{} {
+		return rate.GetRates(env, req)
+	}))
+	// GetRates as it was, one Read per hotel
+	fe.Register("oldrates", cayonlib.CreateFuncHandlerFactory(func(env *cayonlib.Env) interface{} {
+		var plans rate.RatePlans
+		for _, id := range req.HotelIds {
+			var plan data.RatePlan
+			cayonlib.DecodeValue(cayonlib.Read(env, data.Trate(), id), &plan)
+			if plan.HotelId != "" {
+				plans = append(plans, plan)
+			}
+		}
+		return rate.Result{RatePlans: plans}
+	}))
+	getRates := func(funcName string, instanceId string) interface{} {
+		cayonlib.ResetIntentFsmCache()
+		iw := cayonlib.InputWrapper{InstanceId: instanceId}
+		res, err := fe.InvokeFunc(context.Background(), funcName, iw.Serialize())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ow cayonlib.OutputWrapper
+		ow.Deserialize(res)
+		if ow.Status != "Success" {
+			t.Fatalf("GetRates of %s failed: %+v", instanceId, ow.Error)
+		}
+		return ow.Output
+	}
+
+	expected := getRates("rates", "new")
+	if plans := expected.(map[string]interface{})["RatePlans"].([]interface{}); len(plans) != 2 {
+		t.Fatalf("Expected the plans of h1 and h2, have %v", expected)
+	}
+	if res := getRates("rates", "new"); !reflect.DeepEqual(res, expected) {
+		t.Fatalf("Replay returned %v, expected %v", res, expected)
+	}
+	getRates("oldrates", "old")
+	if res := getRates("rates", "old"); !reflect.DeepEqual(res, expected) {
+		t.Fatalf("Replay of an instance that read per hotel returned %v, expected %v", res, expected)
+	}
+}
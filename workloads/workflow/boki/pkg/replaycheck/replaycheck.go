@@ -0,0 +1,235 @@
+// Package replaycheck finds non-deterministic workflow code before it meets
+// a replay in production. Check runs a function, then runs it again with a
+// crash injected at every step boundary and replays it from the log,
+// reporting every replayed step that differs from the logged one.
+package replaycheck
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"cs.utexas.edu/zjia/faas-memdb"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/eniac/Beldi/pkg/cayonlib"
+	"github.com/eniac/Beldi/pkg/localfaas"
+)
+
+// Fields generated afresh whenever a step is proposed, replays use the
+// logged ones
+var ignoredFields = map[string]bool{
+	"instanceId": true,
+	"at":         true,
+	"wrapper":    true,
+}
+
+// Divergence is a replayed step differing from the logged step. Crash is
+// the step boundary the crash was injected at.
+type Divergence struct {
+	Crash      int32
+	InstanceId string
+	Step       int32
+	Field      string
+	Logged     interface{}
+	Replayed   interface{}
+}
+
+func (d *Divergence) String() string {
+	logged, _ := json.Marshal(d.Logged)
+	replayed, _ := json.Marshal(d.Replayed)
+	return fmt.Sprintf("crash at %d: instance %s step %d: %s logged=%s replayed=%s",
+		d.Crash, d.InstanceId, d.Step, d.Field, logged, replayed)
+}
+
+type Report struct {
+	Steps       int32
+	Divergences []*Divergence
+}
+
+func (r *Report) Ok() bool {
+	return len(r.Divergences) == 0
+}
+
+func (r *Report) String() string {
+	lines := []string{fmt.Sprintf("%d steps, %d divergences", r.Steps, len(r.Divergences))}
+	for _, d := range r.Divergences {
+		lines = append(lines, d.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+type crash struct {
+	step int32
+}
+
+func (c *crash) Error() string {
+	return fmt.Sprintf("Injected crash at step %d", c.step)
+}
+
+// Only one check runs at a time, the hooks are global
+var checkMutex = sync.Mutex{}
+
+func normalize(v interface{}) interface{} {
+	raw, err := json.Marshal(v)
+	cayonlib.CHECK(err)
+	var res interface{}
+	cayonlib.CHECK(json.Unmarshal(raw, &res))
+	return res
+}
+
+func compareStep(logged aws.JSONValue, replayed aws.JSONValue) []string {
+	fields := make([]string, 0)
+	seen := make(map[string]bool)
+	for k := range logged {
+		seen[k] = true
+	}
+	for k := range replayed {
+		seen[k] = true
+	}
+	for k := range seen {
+		if ignoredFields[k] {
+			continue
+		}
+		if !reflect.DeepEqual(normalize(logged[k]), normalize(replayed[k])) {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+type checker struct {
+	mu          sync.Mutex
+	instanceId  string
+	crashAt     int32
+	steps       int32
+	divergences []*Divergence
+}
+
+func (c *checker) stepHook(env *cayonlib.Env, step int32) {
+	if env.InstanceId != c.instanceId {
+		return
+	}
+	c.mu.Lock()
+	if step > c.steps {
+		c.steps = step
+	}
+	c.mu.Unlock()
+	if step == c.crashAt {
+		panic(&crash{step: step})
+	}
+}
+
+func (c *checker) replayHook(env *cayonlib.Env, logged *cayonlib.IntentLogEntry, data aws.JSONValue) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, field := range compareStep(logged.Data, data) {
+		c.divergences = append(c.divergences, &Divergence{
+			InstanceId: env.InstanceId,
+			Step:       logged.StepNumber,
+			Field:      field,
+			Logged:     logged.Data[field],
+			Replayed:   data[field],
+		})
+	}
+}
+
+func invoke(fe *localfaas.Environment, funcName string, instanceId string, input interface{}) (cayonlib.OutputWrapper, error) {
+	iw := cayonlib.InputWrapper{
+		InstanceId: instanceId,
+		Input:      input,
+	}
+	var ow cayonlib.OutputWrapper
+	res, err := fe.InvokeFunc(context.Background(), funcName, iw.Serialize())
+	if err != nil {
+		return ow, err
+	}
+	ow.Deserialize(res)
+	return ow, nil
+}
+
+// Check runs funcName with input, then for every step boundary of it
+// crashes a fresh run there and replays it. setup registers the functions
+// in a fresh environment and creates the tables in a fresh memdb, which
+// becomes cayonlib.DBClient. Children of the checked instance replay
+// without crashes, their divergences are reported as well.
+func Check(setup func(fe *localfaas.Environment), funcName string, input interface{}) *Report {
+	checkMutex.Lock()
+	defer checkMutex.Unlock()
+	dbClient := cayonlib.DBClient
+	defer func() {
+		cayonlib.StepHook = nil
+		cayonlib.ReplayHook = nil
+		cayonlib.DBClient = dbClient
+	}()
+
+	c := &checker{instanceId: "replaycheck", crashAt: -1}
+	cayonlib.StepHook = c.stepHook
+	cayonlib.ReplayHook = c.replayHook
+	fresh := func() *localfaas.Environment {
+		cayonlib.DBClient = memdb.New()
+		cayonlib.ResetIntentFsmCache()
+		fe := localfaas.NewEnvironment()
+		setup(fe)
+		return fe
+	}
+
+	fe := fresh()
+	expected, err := invoke(fe, funcName, c.instanceId, input)
+	cayonlib.CHECK(err)
+	fe.Wait()
+	report := &Report{Steps: c.steps}
+
+	for step := int32(0); step <= report.Steps; step++ {
+		c.crashAt = step
+		fe := fresh()
+		_, crashErr := invoke(fe, funcName, c.instanceId, input)
+		fe.Wait()
+		c.crashAt = -1
+		ow, err := invoke(fe, funcName, c.instanceId, input)
+		fe.Wait()
+		c.mu.Lock()
+		if crashErr == nil {
+			// The first run took another path than the reference run
+			c.divergences = append(c.divergences, &Divergence{
+				InstanceId: c.instanceId,
+				Step:       step,
+				Field:      "steps",
+				Logged:     report.Steps,
+			})
+		} else if err != nil && strings.Contains(err.Error(), "LogMismatch") {
+			c.divergences = append(c.divergences, &Divergence{
+				InstanceId: c.instanceId,
+				Step:       -1,
+				Field:      "LogMismatch",
+				Replayed:   err.Error(),
+			})
+		} else if err != nil {
+			c.divergences = append(c.divergences, &Divergence{
+				InstanceId: c.instanceId,
+				Step:       -1,
+				Field:      "crashed",
+				Replayed:   err.Error(),
+			})
+		} else if !reflect.DeepEqual(normalize(ow), normalize(expected)) {
+			c.divergences = append(c.divergences, &Divergence{
+				InstanceId: c.instanceId,
+				Step:       -1,
+				Field:      "output",
+				Logged:     expected,
+				Replayed:   ow,
+			})
+		}
+		for _, d := range c.divergences {
+			d.Crash = step
+		}
+		report.Divergences = append(report.Divergences, c.divergences...)
+		c.divergences = nil
+		c.mu.Unlock()
+	}
+	return report
+}
@@ -0,0 +1,70 @@
+package replaycheck
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/eniac/Beldi/pkg/cayonlib"
+	"github.com/eniac/Beldi/pkg/localfaas"
+)
+
+// check runs Check on a function taking the steps of f
+func check(f func(env *cayonlib.Env) interface{}) *Report {
+	return Check(func(fe *localfaas.Environment) {
+		fe.Register("checked", cayonlib.CreateFuncHandlerFactory(f))
+		fe.Register("callee", cayonlib.CreateFuncHandlerFactory(func(env *cayonlib.Env) interface{} {
+			return env.Input
+		}))
+	}, "checked", nil)
+}
+
+func TestCheckDeterministic(t *testing.T) {
+	report := check(func(env *cayonlib.Env) interface{} {
+		cayonlib.ProposeNextStep(env, aws.JSONValue{"type": "Noop", "value": 1})
+		output, _ := cayonlib.SyncInvoke(env, "callee", "hello")
+		return output
+	})
+	if !report.Ok() || report.Steps != 2 {
+		t.Fatalf("Expected 2 steps without divergences, have %s", report)
+	}
+}
+
+func TestCheckReportsChangedFields(t *testing.T) {
+	var runs int32
+	report := check(func(env *cayonlib.Env) interface{} {
+		n := atomic.AddInt32(&runs, 1)
+		cayonlib.ProposeNextStep(env, aws.JSONValue{"type": "Noop", "value": n})
+		return 0
+	})
+	if report.Ok() {
+		t.Fatalf("Expected divergences, have %s", report)
+	}
+	for _, d := range report.Divergences {
+		if d.Field != "value" || d.Step != 0 {
+			t.Fatalf("Unexpected divergence %s", d)
+		}
+	}
+}
+
+func TestCheckReportsLogMismatches(t *testing.T) {
+	var runs int32
+	report := check(func(env *cayonlib.Env) interface{} {
+		stepType := "Even"
+		if atomic.AddInt32(&runs, 1)%2 == 1 {
+			stepType = "Odd"
+		}
+		newLog, intentLog := cayonlib.ProposeNextStep(env, aws.JSONValue{"type": stepType})
+		if !newLog {
+			cayonlib.CheckLogDataField(intentLog, "type", stepType)
+		}
+		return 0
+	})
+	found := false
+	for _, d := range report.Divergences {
+		found = found || d.Field == "LogMismatch"
+	}
+	if !found {
+		t.Fatalf("Expected a LogMismatch, have %s", report)
+	}
+}
@@ -0,0 +1,952 @@
+package memdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const maxItemSize = 400 * 1024
+const maxTransactItems = 25
+
+func validationError(format string, args ...interface{}) error {
+	return awserr.New("ValidationException", fmt.Sprintf(format, args...), nil)
+}
+
+func resourceNotFound() error {
+	return awserr.New(dynamodb.ErrCodeResourceNotFoundException, "Requested resource not found", nil)
+}
+
+func conditionalCheckFailed() error {
+	return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+}
+
+type keySchema struct {
+	hash string
+	rng  string
+}
+
+func parseKeySchema(elems []*dynamodb.KeySchemaElement) keySchema {
+	var schema keySchema
+	for _, elem := range elems {
+		if aws.StringValue(elem.KeyType) == "HASH" {
+			schema.hash = aws.StringValue(elem.AttributeName)
+		} else {
+			schema.rng = aws.StringValue(elem.AttributeName)
+		}
+	}
+	return schema
+}
+
+func (schema keySchema) names() []string {
+	if schema.rng == "" {
+		return []string{schema.hash}
+	}
+	return []string{schema.hash, schema.rng}
+}
+
+type index struct {
+	name     string
+	key      keySchema
+	projType string
+	nonKey   []string
+}
+
+type table struct {
+	name     string
+	key      keySchema
+	attrDefs map[string]string
+	indexes  map[string]*index
+	items    map[string]item
+	desc     *dynamodb.CreateTableInput
+}
+
+// DB is an in-memory implementation of the DynamoDB operations used by
+// cayonlib and beldilib. All reads are strongly consistent.
+type DB struct {
+	dynamodbiface.DynamoDBAPI
+
+	mu     sync.Mutex
+	tables map[string]*table
+
+	// PageSize bounds the number of items a single Scan or Query evaluates,
+	// standing in for the 1MB page limit of DynamoDB. Zero means unbounded.
+	PageSize int
+}
+
+func New() *DB {
+	return &DB{tables: make(map[string]*table)}
+}
+
+func (db *DB) getTable(name *string) (*table, error) {
+	t, exists := db.tables[aws.StringValue(name)]
+	if !exists {
+		return nil, resourceNotFound()
+	}
+	return t, nil
+}
+
+func (t *table) checkKeyValue(name string, v *dynamodb.AttributeValue) error {
+	if v == nil || typeOf(v) != t.attrDefs[name] {
+		return validationError("One or more parameter values were invalid: Type mismatch for key %s expected: %s actual: %s",
+			name, t.attrDefs[name], typeOf(v))
+	}
+	if (v.S != nil && *v.S == "") || (v.B != nil && len(v.B) == 0) {
+		return validationError("One or more parameter values are not valid. The AttributeValue for a key attribute cannot contain an empty string value. Key: %s", name)
+	}
+	return nil
+}
+
+// itemKey returns the storage key of it, which must carry the key
+// attributes of t. When exact is set, it must carry nothing else.
+func (t *table) itemKey(it item, exact bool) (string, error) {
+	names := t.key.names()
+	if exact && len(it) != len(names) {
+		return "", validationError("The provided key element does not match the schema")
+	}
+	parts := make([]string, 0, 2)
+	for _, name := range names {
+		v, ok := it[name]
+		if !ok {
+			if exact {
+				return "", validationError("The provided key element does not match the schema")
+			}
+			return "", validationError("One or more parameter values were invalid: Missing the key %s in the item", name)
+		}
+		if err := t.checkKeyValue(name, v); err != nil {
+			return "", err
+		}
+		parts = append(parts, keyString(v))
+	}
+	return strings.Join(parts, "\x00"), nil
+}
+
+func (db *DB) CreateTable(input *dynamodb.CreateTableInput) (*dynamodb.CreateTableOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	name := aws.StringValue(input.TableName)
+	if _, exists := db.tables[name]; exists {
+		return nil, awserr.New(dynamodb.ErrCodeResourceInUseException, fmt.Sprintf("Table already exists: %s", name), nil)
+	}
+	t := &table{
+		name:     name,
+		key:      parseKeySchema(input.KeySchema),
+		attrDefs: make(map[string]string),
+		indexes:  make(map[string]*index),
+		items:    make(map[string]item),
+		desc:     input,
+	}
+	for _, def := range input.AttributeDefinitions {
+		t.attrDefs[aws.StringValue(def.AttributeName)] = aws.StringValue(def.AttributeType)
+	}
+	if t.key.hash == "" {
+		return nil, validationError("1 validation error detected: Value null at 'keySchema' failed to satisfy constraint: Member must not be null")
+	}
+	for _, gsi := range input.GlobalSecondaryIndexes {
+		t.addIndex(gsi.IndexName, gsi.KeySchema, gsi.Projection)
+	}
+	if err := t.checkAttrDefs(); err != nil {
+		return nil, err
+	}
+	db.tables[name] = t
+	return &dynamodb.CreateTableOutput{TableDescription: t.describe()}, nil
+}
+
+func (t *table) addIndex(name *string, keySchema []*dynamodb.KeySchemaElement, projection *dynamodb.Projection) {
+	idx := &index{
+		name: aws.StringValue(name),
+		key:  parseKeySchema(keySchema),
+	}
+	if projection != nil {
+		idx.projType = aws.StringValue(projection.ProjectionType)
+		for _, attr := range projection.NonKeyAttributes {
+			idx.nonKey = append(idx.nonKey, aws.StringValue(attr))
+		}
+	}
+	t.indexes[idx.name] = idx
+}
+
+func (t *table) checkAttrDefs() error {
+	for _, name := range append(t.key.names(), t.indexKeyNames()...) {
+		if _, ok := t.attrDefs[name]; !ok {
+			return validationError("One or more parameter values were invalid: Some index key attributes are not defined in AttributeDefinitions. Keys: [%s]", name)
+		}
+	}
+	return nil
+}
+
+func (t *table) indexKeyNames() []string {
+	names := make([]string, 0)
+	for _, idx := range t.indexes {
+		names = append(names, idx.key.names()...)
+	}
+	return names
+}
+
+func (t *table) describe() *dynamodb.TableDescription {
+	desc := &dynamodb.TableDescription{
+		AttributeDefinitions: t.desc.AttributeDefinitions,
+		ItemCount:            aws.Int64(int64(len(t.items))),
+		KeySchema:            t.desc.KeySchema,
+		TableName:            aws.String(t.name),
+		TableStatus:          aws.String(dynamodb.TableStatusActive),
+	}
+	for _, gsi := range t.desc.GlobalSecondaryIndexes {
+		desc.GlobalSecondaryIndexes = append(desc.GlobalSecondaryIndexes, &dynamodb.GlobalSecondaryIndexDescription{
+			IndexName:   gsi.IndexName,
+			IndexStatus: aws.String(dynamodb.TableStatusActive),
+			KeySchema:   gsi.KeySchema,
+			Projection:  gsi.Projection,
+		})
+	}
+	return desc
+}
+
+func (db *DB) DeleteTable(input *dynamodb.DeleteTableInput) (*dynamodb.DeleteTableOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t, err := db.getTable(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	delete(db.tables, t.name)
+	desc := t.describe()
+	desc.TableStatus = aws.String(dynamodb.TableStatusDeleting)
+	return &dynamodb.DeleteTableOutput{TableDescription: desc}, nil
+}
+
+func (db *DB) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t, err := db.getTable(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.DescribeTableOutput{Table: t.describe()}, nil
+}
+
+// UpdateTable creates global secondary indexes. Indexes are computed on
+// every read, so a new one is active and complete right away.
+func (db *DB) UpdateTable(input *dynamodb.UpdateTableInput) (*dynamodb.UpdateTableOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t, err := db.getTable(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, def := range input.AttributeDefinitions {
+		if _, exists := t.attrDefs[aws.StringValue(def.AttributeName)]; !exists {
+			t.desc.AttributeDefinitions = append(t.desc.AttributeDefinitions, def)
+		}
+		t.attrDefs[aws.StringValue(def.AttributeName)] = aws.StringValue(def.AttributeType)
+	}
+	for _, update := range input.GlobalSecondaryIndexUpdates {
+		create := update.Create
+		if create == nil {
+			return nil, validationError("Only creating global secondary indexes is supported")
+		}
+		if _, exists := t.indexes[aws.StringValue(create.IndexName)]; exists {
+			return nil, validationError("Attempting to create an index which already exists")
+		}
+		t.addIndex(create.IndexName, create.KeySchema, create.Projection)
+		t.desc.GlobalSecondaryIndexes = append(t.desc.GlobalSecondaryIndexes, &dynamodb.GlobalSecondaryIndex{
+			IndexName:  create.IndexName,
+			KeySchema:  create.KeySchema,
+			Projection: create.Projection,
+		})
+	}
+	if err := t.checkAttrDefs(); err != nil {
+		return nil, err
+	}
+	return &dynamodb.UpdateTableOutput{TableDescription: t.describe()}, nil
+}
+
+func (db *DB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t, err := db.getTable(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	k, err := t.itemKey(input.Key, true)
+	if err != nil {
+		return nil, err
+	}
+	attrs := newExprAttrs(input.ExpressionAttributeNames, nil)
+	paths, err := parseProjectionExpression(input.ProjectionExpression, attrs)
+	if err != nil {
+		return nil, err
+	}
+	if err := attrs.checkUnused(); err != nil {
+		return nil, err
+	}
+	it, exists := t.items[k]
+	if !exists {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	return &dynamodb.GetItemOutput{Item: project(it, paths)}, nil
+}
+
+func (db *DB) BatchGetItem(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	output := &dynamodb.BatchGetItemOutput{
+		Responses:       make(map[string][]map[string]*dynamodb.AttributeValue),
+		UnprocessedKeys: make(map[string]*dynamodb.KeysAndAttributes),
+	}
+	for name, request := range input.RequestItems {
+		t, err := db.getTable(aws.String(name))
+		if err != nil {
+			return nil, err
+		}
+		attrs := newExprAttrs(request.ExpressionAttributeNames, nil)
+		paths, err := parseProjectionExpression(request.ProjectionExpression, attrs)
+		if err != nil {
+			return nil, err
+		}
+		if err := attrs.checkUnused(); err != nil {
+			return nil, err
+		}
+		items := make([]map[string]*dynamodb.AttributeValue, 0)
+		for _, key := range request.Keys {
+			k, err := t.itemKey(key, true)
+			if err != nil {
+				return nil, err
+			}
+			if it, exists := t.items[k]; exists {
+				items = append(items, project(it, paths))
+			}
+		}
+		output.Responses[name] = items
+	}
+	return output, nil
+}
+
+func checkItem(it item) error {
+	if itemSize(it) > maxItemSize {
+		return validationError("Item size has exceeded the maximum allowed size")
+	}
+	return nil
+}
+
+func returnValues(option *string, old item, new item, actions []updateAction) item {
+	switch aws.StringValue(option) {
+	case "ALL_OLD":
+		return copyItem(old)
+	case "ALL_NEW":
+		return copyItem(new)
+	case "UPDATED_OLD", "UPDATED_NEW":
+		src := old
+		if aws.StringValue(option) == "UPDATED_NEW" {
+			src = new
+		}
+		res := make(item)
+		for _, action := range actions {
+			if v, ok := src[action.path[0].name]; ok {
+				res[action.path[0].name] = copyValue(v)
+			}
+		}
+		if len(res) == 0 {
+			return nil
+		}
+		return res
+	}
+	return nil
+}
+
+func (db *DB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t, err := db.getTable(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	op, err := t.preparePut(input.Item, input.ConditionExpression,
+		input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	old, err := op.eval()
+	if err != nil {
+		return nil, err
+	}
+	op.commit()
+	return &dynamodb.PutItemOutput{Attributes: returnValues(input.ReturnValues, old, nil, nil)}, nil
+}
+
+func (db *DB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t, err := db.getTable(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	op, err := t.prepareUpdate(input.Key, input.UpdateExpression, input.ConditionExpression,
+		input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	old, err := op.eval()
+	if err != nil {
+		return nil, err
+	}
+	op.commit()
+	return &dynamodb.UpdateItemOutput{
+		Attributes: returnValues(input.ReturnValues, old, op.result, op.actions),
+	}, nil
+}
+
+func (db *DB) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t, err := db.getTable(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	op, err := t.prepareDelete(input.Key, input.ConditionExpression,
+		input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	old, err := op.eval()
+	if err != nil {
+		return nil, err
+	}
+	op.commit()
+	return &dynamodb.DeleteItemOutput{Attributes: returnValues(input.ReturnValues, old, nil, nil)}, nil
+}
+
+// writeOp is a single-item write whose condition is evaluated before any
+// change is made, so that transactions can check every item up front.
+type writeOp struct {
+	t       *table
+	key     string
+	kind    string
+	cond    condition
+	put     item
+	keyItem item
+	actions []updateAction
+	result  item
+}
+
+func (t *table) preparePut(it item, condExpr *string, names map[string]*string,
+	values map[string]*dynamodb.AttributeValue) (*writeOp, error) {
+	k, err := t.itemKey(it, false)
+	if err != nil {
+		return nil, err
+	}
+	for name, idxType := range t.indexTypes() {
+		if v, ok := it[name]; ok && typeOf(v) != idxType {
+			return nil, validationError("One or more parameter values were invalid: Type mismatch for Index Key %s Expected: %s Actual: %s",
+				name, idxType, typeOf(v))
+		}
+	}
+	attrs := newExprAttrs(names, values)
+	cond, err := parseConditionExpression(condExpr, attrs)
+	if err != nil {
+		return nil, err
+	}
+	if err := attrs.checkUnused(); err != nil {
+		return nil, err
+	}
+	if err := checkItem(it); err != nil {
+		return nil, err
+	}
+	return &writeOp{t: t, key: k, kind: "Put", cond: cond, put: copyItem(it)}, nil
+}
+
+func (t *table) prepareUpdate(key item, updateExpr *string, condExpr *string, names map[string]*string,
+	values map[string]*dynamodb.AttributeValue) (*writeOp, error) {
+	k, err := t.itemKey(key, true)
+	if err != nil {
+		return nil, err
+	}
+	attrs := newExprAttrs(names, values)
+	cond, err := parseConditionExpression(condExpr, attrs)
+	if err != nil {
+		return nil, err
+	}
+	actions, err := parseUpdateExpression(updateExpr, attrs)
+	if err != nil {
+		return nil, err
+	}
+	if err := attrs.checkUnused(); err != nil {
+		return nil, err
+	}
+	for _, action := range actions {
+		for _, name := range t.key.names() {
+			if action.path[0].name == name {
+				return nil, validationError("One or more parameter values were invalid: Cannot update attribute %s. This attribute is part of the key", name)
+			}
+		}
+	}
+	return &writeOp{t: t, key: k, kind: "Update", cond: cond, keyItem: copyItem(key), actions: actions}, nil
+}
+
+func (t *table) prepareDelete(key item, condExpr *string, names map[string]*string,
+	values map[string]*dynamodb.AttributeValue) (*writeOp, error) {
+	k, err := t.itemKey(key, true)
+	if err != nil {
+		return nil, err
+	}
+	attrs := newExprAttrs(names, values)
+	cond, err := parseConditionExpression(condExpr, attrs)
+	if err != nil {
+		return nil, err
+	}
+	if err := attrs.checkUnused(); err != nil {
+		return nil, err
+	}
+	return &writeOp{t: t, key: k, kind: "Delete", cond: cond}, nil
+}
+
+func (t *table) prepareConditionCheck(key item, condExpr *string, names map[string]*string,
+	values map[string]*dynamodb.AttributeValue) (*writeOp, error) {
+	if condExpr == nil {
+		return nil, validationError("The ConditionExpression of a ConditionCheck must not be empty")
+	}
+	op, err := t.prepareDelete(key, condExpr, names, values)
+	if err != nil {
+		return nil, err
+	}
+	op.kind = "ConditionCheck"
+	return op, nil
+}
+
+func (t *table) indexTypes() map[string]string {
+	res := make(map[string]string)
+	for _, name := range t.indexKeyNames() {
+		res[name] = t.attrDefs[name]
+	}
+	return res
+}
+
+// eval checks the condition of op and computes the resulting item. It
+// returns the current item, and does not modify the table.
+func (op *writeOp) eval() (item, error) {
+	old := op.t.items[op.key]
+	if op.cond != nil {
+		ok, err := evalCondition(old, op.cond)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, conditionalCheckFailed()
+		}
+	}
+	switch op.kind {
+	case "Put":
+		op.result = op.put
+	case "Update":
+		res := copyItem(old)
+		if res == nil {
+			res = copyItem(op.keyItem)
+		}
+		if err := applyUpdate(res, op.actions); err != nil {
+			return nil, err
+		}
+		for name, idxType := range op.t.indexTypes() {
+			if v, ok := res[name]; ok && typeOf(v) != idxType {
+				return nil, validationError("One or more parameter values were invalid: Type mismatch for Index Key %s Expected: %s Actual: %s",
+					name, idxType, typeOf(v))
+			}
+		}
+		if err := checkItem(res); err != nil {
+			return nil, err
+		}
+		op.result = res
+	}
+	return old, nil
+}
+
+func (op *writeOp) commit() {
+	switch op.kind {
+	case "Put", "Update":
+		op.t.items[op.key] = op.result
+	case "Delete":
+		delete(op.t.items, op.key)
+	}
+}
+
+func (db *DB) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if len(input.TransactItems) == 0 || len(input.TransactItems) > maxTransactItems {
+		return nil, validationError("1 validation error detected: Value at 'transactItems' failed to satisfy constraint: Member must have length less than or equal to %d", maxTransactItems)
+	}
+	ops := make([]*writeOp, 0, len(input.TransactItems))
+	seen := make(map[string]bool)
+	for _, ti := range input.TransactItems {
+		var op *writeOp
+		var t *table
+		var err error
+		switch {
+		case ti.Put != nil:
+			if t, err = db.getTable(ti.Put.TableName); err == nil {
+				op, err = t.preparePut(ti.Put.Item, ti.Put.ConditionExpression,
+					ti.Put.ExpressionAttributeNames, ti.Put.ExpressionAttributeValues)
+			}
+		case ti.Update != nil:
+			if t, err = db.getTable(ti.Update.TableName); err == nil {
+				op, err = t.prepareUpdate(ti.Update.Key, ti.Update.UpdateExpression, ti.Update.ConditionExpression,
+					ti.Update.ExpressionAttributeNames, ti.Update.ExpressionAttributeValues)
+			}
+		case ti.Delete != nil:
+			if t, err = db.getTable(ti.Delete.TableName); err == nil {
+				op, err = t.prepareDelete(ti.Delete.Key, ti.Delete.ConditionExpression,
+					ti.Delete.ExpressionAttributeNames, ti.Delete.ExpressionAttributeValues)
+			}
+		case ti.ConditionCheck != nil:
+			if t, err = db.getTable(ti.ConditionCheck.TableName); err == nil {
+				op, err = t.prepareConditionCheck(ti.ConditionCheck.Key, ti.ConditionCheck.ConditionExpression,
+					ti.ConditionCheck.ExpressionAttributeNames, ti.ConditionCheck.ExpressionAttributeValues)
+			}
+		default:
+			err = validationError("TransactItems can only contain one of Check, Put, Update or Delete")
+		}
+		if err != nil {
+			return nil, err
+		}
+		id := t.name + "\x01" + op.key
+		if seen[id] {
+			return nil, validationError("Transaction request cannot include multiple operations on one item")
+		}
+		seen[id] = true
+		ops = append(ops, op)
+	}
+	reasons := make([]string, len(ops))
+	cancelled := false
+	for i, op := range ops {
+		reasons[i] = "None"
+		if _, err := op.eval(); err != nil {
+			cancelled = true
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+				reasons[i] = "ConditionalCheckFailed"
+			} else {
+				reasons[i] = "ValidationError"
+			}
+		}
+	}
+	if cancelled {
+		return nil, awserr.New(dynamodb.ErrCodeTransactionCanceledException,
+			fmt.Sprintf("Transaction cancelled, please refer cancellation reasons for specific reasons [%s]",
+				strings.Join(reasons, ", ")), nil)
+	}
+	for _, op := range ops {
+		op.commit()
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// view is the ordered sequence of items a Scan or Query walks over: either
+// the base table or one of its indexes.
+type view struct {
+	t   *table
+	idx *index
+}
+
+func (db *DB) getView(tableName *string, indexName *string, consistentRead *bool) (*view, error) {
+	t, err := db.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if indexName == nil {
+		return &view{t: t}, nil
+	}
+	idx, exists := t.indexes[aws.StringValue(indexName)]
+	if !exists {
+		return nil, validationError("The table does not have the specified index: %s", aws.StringValue(indexName))
+	}
+	if aws.BoolValue(consistentRead) {
+		return nil, validationError("Consistent reads are not supported on global secondary indexes")
+	}
+	return &view{t: t, idx: idx}, nil
+}
+
+func (v *view) keyNames() []string {
+	if v.idx == nil {
+		return v.t.key.names()
+	}
+	return append(v.idx.key.names(), v.t.key.names()...)
+}
+
+func (v *view) schema() keySchema {
+	if v.idx == nil {
+		return v.t.key
+	}
+	return v.idx.key
+}
+
+func (v *view) compare(a item, b item) int {
+	for i, name := range v.keyNames() {
+		if i == 0 || name == v.t.key.hash {
+			ka, kb := keyString(a[name]), keyString(b[name])
+			if ka != kb {
+				if ka < kb {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+		if c, _ := compareScalars(a[name], b[name]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func (v *view) items() []item {
+	res := make([]item, 0, len(v.t.items))
+	for _, it := range v.t.items {
+		if v.idx != nil {
+			if _, ok := it[v.idx.key.hash]; !ok {
+				continue
+			}
+			if _, ok := it[v.idx.key.rng]; v.idx.key.rng != "" && !ok {
+				continue
+			}
+		}
+		res = append(res, it)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return v.compare(res[i], res[j]) < 0
+	})
+	return res
+}
+
+func (v *view) lastKey(it item) item {
+	res := make(item)
+	for _, name := range v.keyNames() {
+		res[name] = copyValue(it[name])
+	}
+	return res
+}
+
+func (v *view) visible(it item) item {
+	if v.idx == nil || v.idx.projType == "ALL" {
+		return it
+	}
+	res := make(item)
+	for _, name := range v.keyNames() {
+		res[name] = it[name]
+	}
+	if v.idx.projType == "INCLUDE" {
+		for _, name := range v.idx.nonKey {
+			if value, ok := it[name]; ok {
+				res[name] = value
+			}
+		}
+	}
+	return res
+}
+
+func (v *view) startAfter(items []item, start item) (int, error) {
+	if start == nil {
+		return 0, nil
+	}
+	for _, name := range v.keyNames() {
+		if _, ok := start[name]; !ok {
+			return 0, validationError("The provided starting key is invalid: The provided key element does not match the schema")
+		}
+	}
+	return sort.Search(len(items), func(i int) bool {
+		return v.compare(items[i], start) > 0
+	}), nil
+}
+
+type readRequest struct {
+	filter condition
+	paths  []docPath
+	limit  int
+	start  item
+}
+
+func (db *DB) prepareRead(filterExpr *string, projExpr *string, keyExpr *string, names map[string]*string,
+	values map[string]*dynamodb.AttributeValue, limit *int64, start item) (*readRequest, condition, error) {
+	attrs := newExprAttrs(names, values)
+	keyCond, err := parseConditionExpression(keyExpr, attrs)
+	if err != nil {
+		return nil, nil, err
+	}
+	filter, err := parseConditionExpression(filterExpr, attrs)
+	if err != nil {
+		return nil, nil, err
+	}
+	paths, err := parseProjectionExpression(projExpr, attrs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := attrs.checkUnused(); err != nil {
+		return nil, nil, err
+	}
+	if limit != nil && *limit <= 0 {
+		return nil, nil, validationError("1 validation error detected: Value '%d' at 'limit' failed to satisfy constraint: Member must have value greater than or equal to 1", *limit)
+	}
+	req := &readRequest{filter: filter, paths: paths, limit: int(aws.Int64Value(limit)), start: start}
+	if db.PageSize > 0 && (req.limit == 0 || req.limit > db.PageSize) {
+		req.limit = db.PageSize
+	}
+	return req, keyCond, nil
+}
+
+// run walks candidates in order, starting after req.start, and returns the
+// matching items together with the key to resume from, if any.
+func (v *view) run(candidates []item, req *readRequest) ([]map[string]*dynamodb.AttributeValue, item, int, error) {
+	pos, err := v.startAfter(candidates, req.start)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	results := make([]map[string]*dynamodb.AttributeValue, 0)
+	scanned := 0
+	var last item
+	for ; pos < len(candidates); pos++ {
+		if req.limit > 0 && scanned == req.limit {
+			break
+		}
+		it := v.visible(candidates[pos])
+		scanned++
+		last = candidates[pos]
+		if req.filter != nil {
+			ok, err := evalCondition(it, req.filter)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		results = append(results, project(it, req.paths))
+	}
+	if req.limit > 0 && scanned == req.limit && last != nil {
+		return results, v.lastKey(last), scanned, nil
+	}
+	return results, nil, scanned, nil
+}
+
+func (db *DB) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	v, err := db.getView(input.TableName, input.IndexName, input.ConsistentRead)
+	if err != nil {
+		return nil, err
+	}
+	req, _, err := db.prepareRead(input.FilterExpression, input.ProjectionExpression, nil,
+		input.ExpressionAttributeNames, input.ExpressionAttributeValues, input.Limit, input.ExclusiveStartKey)
+	if err != nil {
+		return nil, err
+	}
+	items, last, scanned, err := v.run(v.items(), req)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.ScanOutput{
+		Count:            aws.Int64(int64(len(items))),
+		Items:            items,
+		LastEvaluatedKey: last,
+		ScannedCount:     aws.Int64(int64(scanned)),
+	}, nil
+}
+
+// hashKeyValue finds the equality predicate on the partition key that every
+// key condition must contain.
+func hashKeyValue(cond condition, hash string) (*dynamodb.AttributeValue, error) {
+	conds := []condition{cond}
+	if and, ok := cond.(*andCond); ok {
+		conds = []condition{and.left, and.right}
+	}
+	for _, c := range conds {
+		cmp, ok := c.(*cmpCond)
+		if !ok || cmp.op != "=" {
+			continue
+		}
+		if p, ok := cmp.left.(*pathOperand); ok && len(p.path) == 1 && p.path[0].name == hash {
+			if v, ok := cmp.right.(*valueOperand); ok {
+				return v.value, nil
+			}
+		}
+		if p, ok := cmp.right.(*pathOperand); ok && len(p.path) == 1 && p.path[0].name == hash {
+			if v, ok := cmp.left.(*valueOperand); ok {
+				return v.value, nil
+			}
+		}
+	}
+	return nil, validationError("Query condition missed key schema element: %s", hash)
+}
+
+func (db *DB) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	v, err := db.getView(input.TableName, input.IndexName, input.ConsistentRead)
+	if err != nil {
+		return nil, err
+	}
+	if input.KeyConditionExpression == nil {
+		return nil, validationError("Either the KeyConditions or KeyConditionExpression parameter must be specified in the request.")
+	}
+	req, keyCond, err := db.prepareRead(input.FilterExpression, input.ProjectionExpression, input.KeyConditionExpression,
+		input.ExpressionAttributeNames, input.ExpressionAttributeValues, input.Limit, input.ExclusiveStartKey)
+	if err != nil {
+		return nil, err
+	}
+	schema := v.schema()
+	hashValue, err := hashKeyValue(keyCond, schema.hash)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]item, 0)
+	for _, it := range v.items() {
+		if !equalValues(it[schema.hash], hashValue) {
+			continue
+		}
+		ok, err := evalCondition(it, keyCond)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			candidates = append(candidates, it)
+		}
+	}
+	if input.ScanIndexForward != nil && !*input.ScanIndexForward {
+		for i, j := 0, len(candidates)-1; i < j; i, j = i+1, j-1 {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		}
+		return v.runReversed(candidates, req)
+	}
+	items, last, scanned, err := v.run(candidates, req)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.QueryOutput{
+		Count:            aws.Int64(int64(len(items))),
+		Items:            items,
+		LastEvaluatedKey: last,
+		ScannedCount:     aws.Int64(int64(scanned)),
+	}, nil
+}
+
+func (v *view) runReversed(candidates []item, req *readRequest) (*dynamodb.QueryOutput, error) {
+	start := 0
+	if req.start != nil {
+		start = sort.Search(len(candidates), func(i int) bool {
+			return v.compare(candidates[i], req.start) < 0
+		})
+	}
+	rest := &readRequest{filter: req.filter, paths: req.paths, limit: req.limit}
+	items, last, scanned, err := v.run(candidates[start:], rest)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.QueryOutput{
+		Count:            aws.Int64(int64(len(items))),
+		Items:            items,
+		LastEvaluatedKey: last,
+		ScannedCount:     aws.Int64(int64(scanned)),
+	}, nil
+}
@@ -0,0 +1,430 @@
+package memdb
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func str(v string) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{S: aws.String(v)}
+}
+
+func num(v string) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(v)}
+}
+
+func strSet(vs ...string) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{SS: aws.StringSlice(vs)}
+}
+
+// newTable creates table "t" keyed by K, with the sparse index gcidx on
+// GCQ and TS
+func newTable(t *testing.T) *DB {
+	db := New()
+	_, err := db.CreateTable(&dynamodb.CreateTableInput{
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("K"), AttributeType: aws.String("S")},
+			{AttributeName: aws.String("GCQ"), AttributeType: aws.String("S")},
+			{AttributeName: aws.String("TS"), AttributeType: aws.String("N")},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("K"), KeyType: aws.String("HASH")},
+		},
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String("gcidx"),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{AttributeName: aws.String("GCQ"), KeyType: aws.String("HASH")},
+					{AttributeName: aws.String("TS"), KeyType: aws.String("RANGE")},
+				},
+				Projection: &dynamodb.Projection{ProjectionType: aws.String("KEYS_ONLY")},
+			},
+		},
+		TableName: aws.String("t"),
+	})
+	if err != nil {
+		t.Fatalf("CreateTable: %v", err)
+	}
+	return db
+}
+
+func put(t *testing.T, db *DB, it item) {
+	if _, err := db.PutItem(&dynamodb.PutItemInput{TableName: aws.String("t"), Item: it}); err != nil {
+		t.Fatalf("PutItem: %v", err)
+	}
+}
+
+func get(t *testing.T, db *DB, key string) item {
+	res, err := db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String("t"),
+		Key:       item{"K": str(key)},
+	})
+	if err != nil {
+		t.Fatalf("GetItem: %v", err)
+	}
+	return res.Item
+}
+
+// update passes #v for V and the values of values that the expressions
+// use, since unused ones are rejected
+func update(db *DB, key string, updateExpr string, condExpr string,
+	values item, returnValues string) (*dynamodb.UpdateItemOutput, error) {
+	input := &dynamodb.UpdateItemInput{
+		TableName:        aws.String("t"),
+		Key:              item{"K": str(key)},
+		UpdateExpression: aws.String(updateExpr),
+	}
+	exprs := updateExpr + " " + condExpr
+	if strings.Contains(exprs, "#v") {
+		input.ExpressionAttributeNames = map[string]*string{"#v": aws.String("V")}
+	}
+	for name, value := range values {
+		if strings.Contains(exprs, name) {
+			if input.ExpressionAttributeValues == nil {
+				input.ExpressionAttributeValues = make(item)
+			}
+			input.ExpressionAttributeValues[name] = value
+		}
+	}
+	if condExpr != "" {
+		input.ConditionExpression = aws.String(condExpr)
+	}
+	if returnValues != "" {
+		input.ReturnValues = aws.String(returnValues)
+	}
+	return db.UpdateItem(input)
+}
+
+func isConditionFailure(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+func TestConditionExpressions(t *testing.T) {
+	db := newTable(t)
+	put(t, db, item{"K": str("a"), "V": num("3"), "NAME": str("hotel-1"), "TAGS": strSet("x", "y")})
+	cases := []struct {
+		cond string
+		ok   bool
+	}{
+		{"attribute_exists(#v)", true},
+		{"attribute_not_exists(#v)", false},
+		{"#v > :one", true},
+		{"#v <= :one", false},
+		{"#v BETWEEN :one AND :five", true},
+		{"#v IN (:one, :five)", false},
+		{"#v > :one AND begins_with(NAME, :prefix)", true},
+		{"#v < :one OR contains(TAGS, :tag)", true},
+		{"NOT contains(TAGS, :tag)", false},
+		{"size(TAGS) = :two", true},
+		{"attribute_type(TAGS, :ss)", true},
+	}
+	for _, c := range cases {
+		_, err := update(db, "a", "SET X = :one", c.cond, item{
+			":one": num("1"), ":two": num("2"), ":five": num("5"),
+			":prefix": str("hotel-"), ":tag": str("y"), ":ss": str("SS"),
+		}, "")
+		if c.ok && err != nil {
+			t.Errorf("%s: %v", c.cond, err)
+		} else if !c.ok && !isConditionFailure(err) {
+			t.Errorf("%s: expected a condition failure, have %v", c.cond, err)
+		}
+	}
+}
+
+func TestConditionalPut(t *testing.T) {
+	db := newTable(t)
+	input := &dynamodb.PutItemInput{
+		TableName:           aws.String("t"),
+		Item:                item{"K": str("a"), "V": num("1")},
+		ConditionExpression: aws.String("attribute_not_exists(K)"),
+	}
+	if _, err := db.PutItem(input); err != nil {
+		t.Fatalf("first PutItem: %v", err)
+	}
+	input.Item = item{"K": str("a"), "V": num("2")}
+	if _, err := db.PutItem(input); !isConditionFailure(err) {
+		t.Fatalf("expected a condition failure, have %v", err)
+	}
+	if v := aws.StringValue(get(t, db, "a")["V"].N); v != "1" {
+		t.Errorf("V = %s, want 1", v)
+	}
+}
+
+func TestUpdateExpressions(t *testing.T) {
+	db := newTable(t)
+	put(t, db, item{
+		"K":    str("a"),
+		"V":    num("10"),
+		"OLD":  str("x"),
+		"LIST": {L: []*dynamodb.AttributeValue{num("1")}},
+		"LOGS": {M: item{"i1-1": str("w")}},
+	})
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String("t"),
+		Key:       item{"K": str("a")},
+		UpdateExpression: aws.String("SET #v = #v - :one, INIT = if_not_exists(INIT, :one), " +
+			"LIST = list_append(LIST, :list), LOGS.#c = :w REMOVE OLD ADD CNT :five"),
+		ExpressionAttributeNames: map[string]*string{"#v": aws.String("V"), "#c": aws.String("i2-1")},
+		ExpressionAttributeValues: item{
+			":one":  num("1"),
+			":five": num("5"),
+			":list": {L: []*dynamodb.AttributeValue{num("2")}},
+			":w":    str("w2"),
+		},
+	}
+	if _, err := db.UpdateItem(input); err != nil {
+		t.Fatalf("UpdateItem: %v", err)
+	}
+	it := get(t, db, "a")
+	if v := aws.StringValue(it["V"].N); v != "9" {
+		t.Errorf("V = %s, want 9", v)
+	}
+	if v := aws.StringValue(it["INIT"].N); v != "1" {
+		t.Errorf("INIT = %s, want 1", v)
+	}
+	if v := aws.StringValue(it["CNT"].N); v != "5" {
+		t.Errorf("CNT = %s, want 5", v)
+	}
+	if _, exists := it["OLD"]; exists {
+		t.Errorf("OLD was not removed")
+	}
+	if n := len(it["LIST"].L); n != 2 {
+		t.Errorf("LIST has %d elements, want 2", n)
+	}
+	if n := len(it["LOGS"].M); n != 2 {
+		t.Errorf("LOGS has %d entries, want 2", n)
+	}
+
+	// if_not_exists keeps an existing value
+	input.UpdateExpression = aws.String("SET INIT = if_not_exists(INIT, :five)")
+	input.ExpressionAttributeNames = nil
+	input.ExpressionAttributeValues = item{":five": num("5")}
+	if _, err := db.UpdateItem(input); err != nil {
+		t.Fatalf("UpdateItem: %v", err)
+	}
+	if v := aws.StringValue(get(t, db, "a")["INIT"].N); v != "1" {
+		t.Errorf("INIT = %s, want 1", v)
+	}
+}
+
+func TestStringSets(t *testing.T) {
+	db := newTable(t)
+	add := func(vs ...string) *dynamodb.UpdateItemOutput {
+		res, err := update(db, "a", "ADD WRITES :w", "", item{":w": strSet(vs...)}, "UPDATED_OLD")
+		if err != nil {
+			t.Fatalf("ADD: %v", err)
+		}
+		return res
+	}
+	if res := add("t/1"); res.Attributes != nil {
+		t.Errorf("first ADD returned %v, want nothing", res.Attributes)
+	}
+	res := add("t/1", "t/2")
+	if old := aws.StringValueSlice(res.Attributes["WRITES"].SS); !reflect.DeepEqual(old, []string{"t/1"}) {
+		t.Errorf("second ADD returned %v, want [t/1]", old)
+	}
+	writes := aws.StringValueSlice(get(t, db, "a")["WRITES"].SS)
+	sort.Strings(writes)
+	if !reflect.DeepEqual(writes, []string{"t/1", "t/2"}) {
+		t.Errorf("WRITES = %v, want [t/1 t/2]", writes)
+	}
+
+	if _, err := update(db, "a", "DELETE WRITES :w", "", item{":w": strSet("t/1", "t/2")}, ""); err != nil {
+		t.Fatalf("DELETE: %v", err)
+	}
+	if _, exists := get(t, db, "a")["WRITES"]; exists {
+		t.Errorf("an empty set must be removed")
+	}
+	if _, err := update(db, "a", "ADD WRITES :w", "", item{":w": strSet()}, ""); err == nil {
+		t.Errorf("adding an empty set must fail")
+	}
+}
+
+func TestReturnValues(t *testing.T) {
+	db := newTable(t)
+	cases := []struct {
+		option string
+		want   item
+	}{
+		{"NONE", nil},
+		{"UPDATED_OLD", item{"V": num("1")}},
+		{"UPDATED_NEW", item{"V": num("2")}},
+		{"ALL_OLD", item{"K": str("a"), "V": num("1"), "W": str("w")}},
+		{"ALL_NEW", item{"K": str("a"), "V": num("2"), "W": str("w")}},
+	}
+	for _, c := range cases {
+		put(t, db, item{"K": str("a"), "V": num("1"), "W": str("w")})
+		res, err := update(db, "a", "SET #v = :two", "", item{":two": num("2")}, c.option)
+		if err != nil {
+			t.Fatalf("%s: %v", c.option, err)
+		}
+		if !reflect.DeepEqual(res.Attributes, c.want) {
+			t.Errorf("%s returned %v, want %v", c.option, res.Attributes, c.want)
+		}
+	}
+
+	res, err := db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName:    aws.String("t"),
+		Key:          item{"K": str("a")},
+		ReturnValues: aws.String("ALL_OLD"),
+	})
+	if err != nil {
+		t.Fatalf("DeleteItem: %v", err)
+	}
+	if v := aws.StringValue(res.Attributes["W"].S); v != "w" {
+		t.Errorf("DeleteItem returned W = %s, want w", v)
+	}
+}
+
+func TestIndexQuery(t *testing.T) {
+	db := newTable(t)
+	put(t, db, item{"K": str("a"), "GCQ": str("DONE"), "TS": num("1")})
+	put(t, db, item{"K": str("b"), "GCQ": str("DONE"), "TS": num("3")})
+	put(t, db, item{"K": str("c"), "GCQ": str("DONE"), "TS": num("2")})
+	put(t, db, item{"K": str("d"), "GCQ": str("DANGLE"), "TS": num("1")})
+	// not in the sparse index
+	put(t, db, item{"K": str("e"), "TS": num("1"), "V": str("v")})
+
+	query := func(limit int64, start item) *dynamodb.QueryOutput {
+		input := &dynamodb.QueryInput{
+			TableName:                 aws.String("t"),
+			IndexName:                 aws.String("gcidx"),
+			KeyConditionExpression:    aws.String("GCQ = :q AND TS < :ts"),
+			ExpressionAttributeValues: item{":q": str("DONE"), ":ts": num("3")},
+			ExclusiveStartKey:         start,
+		}
+		if limit > 0 {
+			input.Limit = aws.Int64(limit)
+		}
+		res, err := db.Query(input)
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		return res
+	}
+	keys := func(items []map[string]*dynamodb.AttributeValue) []string {
+		res := make([]string, 0, len(items))
+		for _, it := range items {
+			res = append(res, aws.StringValue(it["K"].S))
+		}
+		return res
+	}
+
+	res := query(0, nil)
+	if got := keys(res.Items); !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Errorf("Query returned %v, want [a c] in TS order", got)
+	}
+	if _, exists := res.Items[0]["V"]; exists {
+		t.Errorf("a KEYS_ONLY index returned a non-key attribute")
+	}
+
+	first := query(1, nil)
+	if got := keys(first.Items); !reflect.DeepEqual(got, []string{"a"}) || first.LastEvaluatedKey == nil {
+		t.Fatalf("first page is %v, last key %v", got, first.LastEvaluatedKey)
+	}
+	second := query(1, first.LastEvaluatedKey)
+	if got := keys(second.Items); !reflect.DeepEqual(got, []string{"c"}) {
+		t.Errorf("second page is %v, want [c]", got)
+	}
+
+	if _, err := db.Query(&dynamodb.QueryInput{
+		TableName:                 aws.String("t"),
+		IndexName:                 aws.String("gcidx"),
+		KeyConditionExpression:    aws.String("GCQ = :q"),
+		ExpressionAttributeValues: item{":q": str("DONE")},
+		ConsistentRead:            aws.Bool(true),
+	}); err == nil {
+		t.Errorf("a consistent read of an index must fail")
+	}
+}
+
+func TestUpdateTableAddsIndex(t *testing.T) {
+	db := New()
+	_, err := db.CreateTable(&dynamodb.CreateTableInput{
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("K"), AttributeType: aws.String("S")},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("K"), KeyType: aws.String("HASH")},
+		},
+		TableName: aws.String("t"),
+	})
+	if err != nil {
+		t.Fatalf("CreateTable: %v", err)
+	}
+	put(t, db, item{"K": str("a"), "GCQ": str("DONE"), "TS": num("1")})
+	_, err = db.UpdateTable(&dynamodb.UpdateTableInput{
+		TableName: aws.String("t"),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("GCQ"), AttributeType: aws.String("S")},
+			{AttributeName: aws.String("TS"), AttributeType: aws.String("N")},
+		},
+		GlobalSecondaryIndexUpdates: []*dynamodb.GlobalSecondaryIndexUpdate{
+			{
+				Create: &dynamodb.CreateGlobalSecondaryIndexAction{
+					IndexName: aws.String("gcidx"),
+					KeySchema: []*dynamodb.KeySchemaElement{
+						{AttributeName: aws.String("GCQ"), KeyType: aws.String("HASH")},
+						{AttributeName: aws.String("TS"), KeyType: aws.String("RANGE")},
+					},
+					Projection: &dynamodb.Projection{ProjectionType: aws.String("KEYS_ONLY")},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("UpdateTable: %v", err)
+	}
+	desc, err := db.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String("t")})
+	if err != nil {
+		t.Fatalf("DescribeTable: %v", err)
+	}
+	if n := len(desc.Table.GlobalSecondaryIndexes); n != 1 {
+		t.Fatalf("table has %d indexes, want 1", n)
+	}
+	res, err := db.Query(&dynamodb.QueryInput{
+		TableName:                 aws.String("t"),
+		IndexName:                 aws.String("gcidx"),
+		KeyConditionExpression:    aws.String("GCQ = :q"),
+		ExpressionAttributeValues: item{":q": str("DONE")},
+	})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(res.Items) != 1 {
+		t.Errorf("index returned %d items, want the existing one", len(res.Items))
+	}
+}
+
+func TestTransactWriteItems(t *testing.T) {
+	db := newTable(t)
+	put(t, db, item{"K": str("a"), "V": num("1")})
+	_, err := db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{Update: &dynamodb.Update{
+				TableName:                 aws.String("t"),
+				Key:                       item{"K": str("a")},
+				UpdateExpression:          aws.String("SET V = :two"),
+				ExpressionAttributeValues: item{":two": num("2")},
+			}},
+			{ConditionCheck: &dynamodb.ConditionCheck{
+				TableName:           aws.String("t"),
+				Key:                 item{"K": str("b")},
+				ConditionExpression: aws.String("attribute_exists(K)"),
+			}},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != dynamodb.ErrCodeTransactionCanceledException {
+		t.Fatalf("expected a canceled transaction, have %v", err)
+	}
+	if v := aws.StringValue(get(t, db, "a")["V"].N); v != "1" {
+		t.Errorf("a canceled transaction changed V to %s", v)
+	}
+}
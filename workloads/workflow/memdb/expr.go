@@ -0,0 +1,1140 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	tokIdent = iota
+	tokName
+	tokValue
+	tokNumber
+	tokPunct
+	tokEOF
+)
+
+type token struct {
+	kind int
+	text string
+}
+
+func tokenize(expr string) ([]token, error) {
+	toks := make([]token, 0)
+	runes := []rune(expr)
+	isIdent := func(r rune) bool {
+		return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '#' || r == ':':
+			j := i + 1
+			for j < len(runes) && isIdent(runes[j]) {
+				j++
+			}
+			if j == i+1 {
+				return nil, validationError("Invalid expression: Syntax error; token: \"%c\", near: \"%s\"", r, expr)
+			}
+			kind := tokName
+			if r == ':' {
+				kind = tokValue
+			}
+			toks = append(toks, token{kind: kind, text: string(runes[i:j])})
+			i = j
+		case unicode.IsDigit(r):
+			j := i
+			for j < len(runes) && unicode.IsDigit(runes[j]) {
+				j++
+			}
+			toks = append(toks, token{kind: tokNumber, text: string(runes[i:j])})
+			i = j
+		case isIdent(r):
+			j := i
+			for j < len(runes) && isIdent(runes[j]) {
+				j++
+			}
+			toks = append(toks, token{kind: tokIdent, text: string(runes[i:j])})
+			i = j
+		case r == '<' || r == '>':
+			if i+1 < len(runes) && (runes[i+1] == '=' || (r == '<' && runes[i+1] == '>')) {
+				toks = append(toks, token{kind: tokPunct, text: string(runes[i : i+2])})
+				i += 2
+			} else {
+				toks = append(toks, token{kind: tokPunct, text: string(r)})
+				i++
+			}
+		case strings.ContainsRune("()[],.=+-", r):
+			toks = append(toks, token{kind: tokPunct, text: string(r)})
+			i++
+		default:
+			return nil, validationError("Invalid expression: Syntax error; token: \"%c\", near: \"%s\"", r, expr)
+		}
+	}
+	return append(toks, token{kind: tokEOF}), nil
+}
+
+// exprAttrs resolves the placeholders of one request and tracks which of
+// them were used, since DynamoDB rejects requests with unused placeholders.
+type exprAttrs struct {
+	names      map[string]*string
+	values     map[string]*dynamodb.AttributeValue
+	usedNames  map[string]bool
+	usedValues map[string]bool
+}
+
+func newExprAttrs(names map[string]*string, values map[string]*dynamodb.AttributeValue) *exprAttrs {
+	return &exprAttrs{
+		names:      names,
+		values:     values,
+		usedNames:  make(map[string]bool),
+		usedValues: make(map[string]bool),
+	}
+}
+
+func (attrs *exprAttrs) checkUnused() error {
+	for name := range attrs.names {
+		if !attrs.usedNames[name] {
+			return validationError("Value provided in ExpressionAttributeNames unused in expressions: keys: {%s}", name)
+		}
+	}
+	for value := range attrs.values {
+		if !attrs.usedValues[value] {
+			return validationError("Value provided in ExpressionAttributeValues unused in expressions: keys: {%s}", value)
+		}
+	}
+	return nil
+}
+
+type pathElem struct {
+	name    string
+	index   int
+	isIndex bool
+}
+
+type docPath []pathElem
+
+func (p docPath) String() string {
+	var b strings.Builder
+	for i, e := range p {
+		if e.isIndex {
+			fmt.Fprintf(&b, "[%d]", e.index)
+		} else {
+			if i > 0 {
+				b.WriteString(".")
+			}
+			b.WriteString(e.name)
+		}
+	}
+	return b.String()
+}
+
+type operand interface{}
+
+type pathOperand struct{ path docPath }
+type valueOperand struct{ value *dynamodb.AttributeValue }
+type sizeOperand struct{ path docPath }
+type ifNotExistsOperand struct {
+	path     docPath
+	fallback operand
+}
+type listAppendOperand struct{ left, right operand }
+type arithOperand struct {
+	op          string
+	left, right operand
+}
+
+type condition interface{}
+
+type andCond struct{ left, right condition }
+type orCond struct{ left, right condition }
+type notCond struct{ cond condition }
+type cmpCond struct {
+	op          string
+	left, right operand
+}
+type betweenCond struct{ value, low, high operand }
+type inCond struct {
+	value   operand
+	options []operand
+}
+type funcCond struct {
+	name string
+	path docPath
+	arg  operand
+}
+
+type parser struct {
+	toks  []token
+	pos   int
+	expr  string
+	attrs *exprAttrs
+}
+
+func newParser(expr string, attrs *exprAttrs) (*parser, error) {
+	toks, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{toks: toks, expr: expr, attrs: attrs}, nil
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) peekAt(offset int) token {
+	if p.pos+offset >= len(p.toks) {
+		return token{kind: tokEOF}
+	}
+	return p.toks[p.pos+offset]
+}
+
+func (p *parser) next() token {
+	tok := p.toks[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) syntaxError() error {
+	tok := p.peek()
+	if tok.kind == tokEOF {
+		return validationError("Invalid expression: Syntax error; token: <EOF>, near: \"%s\"", p.expr)
+	}
+	return validationError("Invalid expression: Syntax error; token: \"%s\", near: \"%s\"", tok.text, p.expr)
+}
+
+func (p *parser) isPunct(text string) bool {
+	tok := p.peek()
+	return tok.kind == tokPunct && tok.text == text
+}
+
+func (p *parser) isKeyword(keyword string) bool {
+	tok := p.peek()
+	return tok.kind == tokIdent && strings.EqualFold(tok.text, keyword)
+}
+
+func (p *parser) expectPunct(text string) error {
+	if !p.isPunct(text) {
+		return p.syntaxError()
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) isFunction(names ...string) bool {
+	tok := p.peek()
+	if tok.kind != tokIdent {
+		return false
+	}
+	if next := p.peekAt(1); next.kind != tokPunct || next.text != "(" {
+		return false
+	}
+	for _, name := range names {
+		if tok.text == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) parsePathElemName() (string, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokIdent:
+		return tok.text, nil
+	case tokName:
+		name, ok := p.attrs.names[tok.text]
+		if !ok || name == nil {
+			return "", validationError("Invalid expression: An expression attribute name used in the document path is not defined; attribute name: %s", tok.text)
+		}
+		p.attrs.usedNames[tok.text] = true
+		return *name, nil
+	}
+	p.pos--
+	return "", p.syntaxError()
+}
+
+func (p *parser) parsePath() (docPath, error) {
+	name, err := p.parsePathElemName()
+	if err != nil {
+		return nil, err
+	}
+	path := docPath{pathElem{name: name}}
+	for {
+		if p.isPunct(".") {
+			p.next()
+			name, err := p.parsePathElemName()
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, pathElem{name: name})
+		} else if p.isPunct("[") {
+			p.next()
+			tok := p.next()
+			if tok.kind != tokNumber {
+				p.pos--
+				return nil, p.syntaxError()
+			}
+			index, err := strconv.Atoi(tok.text)
+			if err != nil {
+				return nil, validationError("Invalid expression: List index is out of range: %s", tok.text)
+			}
+			if err := p.expectPunct("]"); err != nil {
+				return nil, err
+			}
+			path = append(path, pathElem{index: index, isIndex: true})
+		} else {
+			return path, nil
+		}
+	}
+}
+
+func (p *parser) parseValue() (operand, error) {
+	tok := p.next()
+	value, ok := p.attrs.values[tok.text]
+	if !ok || value == nil {
+		return nil, validationError("Invalid expression: An expression attribute value used in expression is not defined; attribute value: %s", tok.text)
+	}
+	if typeOf(value) == "" {
+		return nil, validationError("ExpressionAttributeValues contains invalid value: Supplied AttributeValue is empty, must contain exactly one of the supported datatypes for key %s", tok.text)
+	}
+	p.attrs.usedValues[tok.text] = true
+	return &valueOperand{value: value}, nil
+}
+
+func (p *parser) parseCondOperand() (operand, error) {
+	if p.peek().kind == tokValue {
+		return p.parseValue()
+	}
+	if p.isFunction("size") {
+		p.next()
+		p.next()
+		path, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return &sizeOperand{path: path}, nil
+	}
+	path, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	return &pathOperand{path: path}, nil
+}
+
+func (p *parser) parseCondition() (condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orCond{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (condition, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andCond{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (condition, error) {
+	if p.isKeyword("NOT") {
+		p.next()
+		cond, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notCond{cond: cond}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (condition, error) {
+	if p.isPunct("(") {
+		p.next()
+		cond, err := p.parseCondition()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return cond, nil
+	}
+	if p.isFunction("attribute_exists", "attribute_not_exists", "attribute_type", "begins_with", "contains") {
+		name := p.next().text
+		p.next()
+		path, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		cond := &funcCond{name: name, path: path}
+		if name != "attribute_exists" && name != "attribute_not_exists" {
+			if err := p.expectPunct(","); err != nil {
+				return nil, err
+			}
+			if cond.arg, err = p.parseCondOperand(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return cond, nil
+	}
+	left, err := p.parseCondOperand()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	switch {
+	case tok.kind == tokPunct && (tok.text == "=" || tok.text == "<>" || tok.text == "<" ||
+		tok.text == "<=" || tok.text == ">" || tok.text == ">="):
+		p.next()
+		right, err := p.parseCondOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &cmpCond{op: tok.text, left: left, right: right}, nil
+	case p.isKeyword("BETWEEN"):
+		p.next()
+		low, err := p.parseCondOperand()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isKeyword("AND") {
+			return nil, p.syntaxError()
+		}
+		p.next()
+		high, err := p.parseCondOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &betweenCond{value: left, low: low, high: high}, nil
+	case p.isKeyword("IN"):
+		p.next()
+		if err := p.expectPunct("("); err != nil {
+			return nil, err
+		}
+		cond := &inCond{value: left}
+		for {
+			option, err := p.parseCondOperand()
+			if err != nil {
+				return nil, err
+			}
+			cond.options = append(cond.options, option)
+			if p.isPunct(",") {
+				p.next()
+				continue
+			}
+			if err := p.expectPunct(")"); err != nil {
+				return nil, err
+			}
+			return cond, nil
+		}
+	}
+	return nil, p.syntaxError()
+}
+
+func parseConditionExpression(expr *string, attrs *exprAttrs) (condition, error) {
+	if expr == nil {
+		return nil, nil
+	}
+	p, err := newParser(*expr, attrs)
+	if err != nil {
+		return nil, err
+	}
+	cond, err := p.parseCondition()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, p.syntaxError()
+	}
+	return cond, nil
+}
+
+func parseProjectionExpression(expr *string, attrs *exprAttrs) ([]docPath, error) {
+	if expr == nil {
+		return nil, nil
+	}
+	p, err := newParser(*expr, attrs)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]docPath, 0)
+	for {
+		path, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+		if p.isPunct(",") {
+			p.next()
+			continue
+		}
+		if p.peek().kind != tokEOF {
+			return nil, p.syntaxError()
+		}
+		return paths, nil
+	}
+}
+
+type updateAction struct {
+	kind  string
+	path  docPath
+	value operand
+}
+
+func (p *parser) parseSetOperand() (operand, error) {
+	if p.peek().kind == tokValue {
+		return p.parseValue()
+	}
+	if p.isFunction("if_not_exists") {
+		p.next()
+		p.next()
+		path, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(","); err != nil {
+			return nil, err
+		}
+		fallback, err := p.parseSetOperand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return &ifNotExistsOperand{path: path, fallback: fallback}, nil
+	}
+	if p.isFunction("list_append") {
+		p.next()
+		p.next()
+		left, err := p.parseSetOperand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(","); err != nil {
+			return nil, err
+		}
+		right, err := p.parseSetOperand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return &listAppendOperand{left: left, right: right}, nil
+	}
+	path, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	return &pathOperand{path: path}, nil
+}
+
+func parseUpdateExpression(expr *string, attrs *exprAttrs) ([]updateAction, error) {
+	if expr == nil {
+		return nil, nil
+	}
+	p, err := newParser(*expr, attrs)
+	if err != nil {
+		return nil, err
+	}
+	actions := make([]updateAction, 0)
+	seen := make(map[string]bool)
+	for p.peek().kind != tokEOF {
+		tok := p.next()
+		clause := strings.ToUpper(tok.text)
+		if tok.kind != tokIdent || (clause != "SET" && clause != "REMOVE" && clause != "ADD" && clause != "DELETE") {
+			p.pos--
+			return nil, p.syntaxError()
+		}
+		if seen[clause] {
+			return nil, validationError("Invalid UpdateExpression: The \"%s\" section can only be used once in an update expression;", clause)
+		}
+		seen[clause] = true
+		for {
+			path, err := p.parsePath()
+			if err != nil {
+				return nil, err
+			}
+			action := updateAction{kind: clause, path: path}
+			switch clause {
+			case "SET":
+				if err := p.expectPunct("="); err != nil {
+					return nil, err
+				}
+				left, err := p.parseSetOperand()
+				if err != nil {
+					return nil, err
+				}
+				if p.isPunct("+") || p.isPunct("-") {
+					op := p.next().text
+					right, err := p.parseSetOperand()
+					if err != nil {
+						return nil, err
+					}
+					left = &arithOperand{op: op, left: left, right: right}
+				}
+				action.value = left
+			case "ADD", "DELETE":
+				if p.peek().kind != tokValue {
+					return nil, p.syntaxError()
+				}
+				if action.value, err = p.parseValue(); err != nil {
+					return nil, err
+				}
+			}
+			actions = append(actions, action)
+			if p.isPunct(",") {
+				p.next()
+				continue
+			}
+			break
+		}
+	}
+	if len(actions) == 0 {
+		return nil, validationError("Invalid UpdateExpression: The expression can not be empty;")
+	}
+	for i := range actions {
+		for j := i + 1; j < len(actions); j++ {
+			if pathsOverlap(actions[i].path, actions[j].path) {
+				return nil, validationError("Invalid UpdateExpression: Two document paths overlap with each other; must remove or rewrite one of these paths; path one: [%s], path two: [%s]",
+					actions[i].path, actions[j].path)
+			}
+		}
+	}
+	return actions, nil
+}
+
+func pathsOverlap(a docPath, b docPath) bool {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func getPath(it item, path docPath) *dynamodb.AttributeValue {
+	if it == nil {
+		return nil
+	}
+	cur, ok := it[path[0].name]
+	if !ok {
+		return nil
+	}
+	for _, elem := range path[1:] {
+		if elem.isIndex {
+			if cur.L == nil || elem.index >= len(cur.L) {
+				return nil
+			}
+			cur = cur.L[elem.index]
+		} else {
+			if cur.M == nil {
+				return nil
+			}
+			if cur, ok = cur.M[elem.name]; !ok {
+				return nil
+			}
+		}
+	}
+	return cur
+}
+
+func invalidPath() error {
+	return validationError("The document path provided in the update expression is invalid for update")
+}
+
+func setPath(it item, path docPath, value *dynamodb.AttributeValue) error {
+	if len(path) == 1 {
+		it[path[0].name] = value
+		return nil
+	}
+	parent := getPath(it, path[:len(path)-1])
+	last := path[len(path)-1]
+	if parent == nil {
+		return invalidPath()
+	}
+	if last.isIndex {
+		if parent.L == nil {
+			return invalidPath()
+		}
+		if last.index >= len(parent.L) {
+			parent.L = append(parent.L, value)
+		} else {
+			parent.L[last.index] = value
+		}
+	} else {
+		if parent.M == nil {
+			return invalidPath()
+		}
+		parent.M[last.name] = value
+	}
+	return nil
+}
+
+func removePath(it item, path docPath) error {
+	if len(path) == 1 {
+		delete(it, path[0].name)
+		return nil
+	}
+	parent := getPath(it, path[:len(path)-1])
+	last := path[len(path)-1]
+	if parent == nil {
+		return nil
+	}
+	if last.isIndex {
+		if parent.L == nil {
+			return invalidPath()
+		}
+		if last.index < len(parent.L) {
+			parent.L = append(parent.L[:last.index], parent.L[last.index+1:]...)
+		}
+	} else {
+		if parent.M == nil {
+			return invalidPath()
+		}
+		delete(parent.M, last.name)
+	}
+	return nil
+}
+
+func evalOperand(it item, op operand) (*dynamodb.AttributeValue, error) {
+	switch op := op.(type) {
+	case *pathOperand:
+		return getPath(it, op.path), nil
+	case *valueOperand:
+		return op.value, nil
+	case *sizeOperand:
+		v := getPath(it, op.path)
+		if v == nil {
+			return nil, nil
+		}
+		var size int
+		switch typeOf(v) {
+		case "S":
+			size = len(*v.S)
+		case "B":
+			size = len(v.B)
+		case "SS", "NS", "BS":
+			size = setSize(v)
+		case "L":
+			size = len(v.L)
+		case "M":
+			size = len(v.M)
+		default:
+			return nil, validationError("Invalid ConditionExpression: Incorrect operand type for operator or function; operator or function: size, operand type: %s", typeOf(v))
+		}
+		n := strconv.Itoa(size)
+		return &dynamodb.AttributeValue{N: &n}, nil
+	case *ifNotExistsOperand:
+		if v := getPath(it, op.path); v != nil {
+			return v, nil
+		}
+		return evalOperand(it, op.fallback)
+	case *listAppendOperand:
+		left, err := evalOperand(it, op.left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := evalOperand(it, op.right)
+		if err != nil {
+			return nil, err
+		}
+		if left == nil || right == nil || left.L == nil || right.L == nil {
+			return nil, validationError("Invalid UpdateExpression: Incorrect operand type for operator or function; operator or function: list_append")
+		}
+		res := &dynamodb.AttributeValue{L: make([]*dynamodb.AttributeValue, 0, len(left.L)+len(right.L))}
+		res.L = append(res.L, left.L...)
+		res.L = append(res.L, right.L...)
+		return res, nil
+	case *arithOperand:
+		left, err := evalOperand(it, op.left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := evalOperand(it, op.right)
+		if err != nil {
+			return nil, err
+		}
+		if left == nil || right == nil {
+			return nil, validationError("The provided expression refers to an attribute that does not exist in the item")
+		}
+		if left.N == nil || right.N == nil {
+			return nil, validationError("An operand in the update expression has an incorrect data type")
+		}
+		fl, err := parseNumber(*left.N)
+		if err != nil {
+			return nil, err
+		}
+		fr, err := parseNumber(*right.N)
+		if err != nil {
+			return nil, err
+		}
+		if op.op == "+" {
+			fl.Add(fl, fr)
+		} else {
+			fl.Sub(fl, fr)
+		}
+		n := formatNumber(fl)
+		return &dynamodb.AttributeValue{N: &n}, nil
+	}
+	panic(fmt.Sprintf("Unknown operand %T", op))
+}
+
+func evalCondition(it item, cond condition) (bool, error) {
+	switch cond := cond.(type) {
+	case *andCond:
+		left, err := evalCondition(it, cond.left)
+		if err != nil || !left {
+			return false, err
+		}
+		return evalCondition(it, cond.right)
+	case *orCond:
+		left, err := evalCondition(it, cond.left)
+		if err != nil || left {
+			return left, err
+		}
+		return evalCondition(it, cond.right)
+	case *notCond:
+		res, err := evalCondition(it, cond.cond)
+		return !res, err
+	case *cmpCond:
+		left, err := evalOperand(it, cond.left)
+		if err != nil {
+			return false, err
+		}
+		right, err := evalOperand(it, cond.right)
+		if err != nil {
+			return false, err
+		}
+		if left == nil || right == nil {
+			return false, nil
+		}
+		switch cond.op {
+		case "=":
+			return equalValues(left, right), nil
+		case "<>":
+			return !equalValues(left, right), nil
+		}
+		c, ok := compareScalars(left, right)
+		if !ok {
+			return false, nil
+		}
+		switch cond.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		case ">=":
+			return c >= 0, nil
+		}
+	case *betweenCond:
+		value, err := evalOperand(it, cond.value)
+		if err != nil {
+			return false, err
+		}
+		low, err := evalOperand(it, cond.low)
+		if err != nil {
+			return false, err
+		}
+		high, err := evalOperand(it, cond.high)
+		if err != nil {
+			return false, err
+		}
+		if value == nil || low == nil || high == nil {
+			return false, nil
+		}
+		if c, ok := compareScalars(low, high); ok && c > 0 {
+			return false, validationError("Invalid ConditionExpression: The BETWEEN operator requires upper bound to be greater than or equal to lower bound")
+		}
+		cl, okl := compareScalars(value, low)
+		ch, okh := compareScalars(value, high)
+		return okl && okh && cl >= 0 && ch <= 0, nil
+	case *inCond:
+		value, err := evalOperand(it, cond.value)
+		if err != nil || value == nil {
+			return false, err
+		}
+		for _, option := range cond.options {
+			v, err := evalOperand(it, option)
+			if err != nil {
+				return false, err
+			}
+			if v != nil && equalValues(value, v) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case *funcCond:
+		v := getPath(it, cond.path)
+		switch cond.name {
+		case "attribute_exists":
+			return v != nil, nil
+		case "attribute_not_exists":
+			return v == nil, nil
+		}
+		arg, err := evalOperand(it, cond.arg)
+		if err != nil {
+			return false, err
+		}
+		if v == nil || arg == nil {
+			return false, nil
+		}
+		switch cond.name {
+		case "attribute_type":
+			if arg.S == nil {
+				return false, validationError("Invalid ConditionExpression: Incorrect operand type for operator or function; operator or function: attribute_type")
+			}
+			return typeOf(v) == *arg.S, nil
+		case "begins_with":
+			if v.S != nil && arg.S != nil {
+				return strings.HasPrefix(*v.S, *arg.S), nil
+			}
+			if v.B != nil && arg.B != nil {
+				return bytes.HasPrefix(v.B, arg.B), nil
+			}
+			return false, nil
+		case "contains":
+			switch typeOf(v) {
+			case "S":
+				return arg.S != nil && strings.Contains(*v.S, *arg.S), nil
+			case "B":
+				return arg.B != nil && bytes.Contains(v.B, arg.B), nil
+			case "SS", "NS", "BS":
+				elemType := typeOf(v)[:1]
+				if typeOf(arg) != elemType {
+					return false, nil
+				}
+				_, ok := setKeys(v)[strings.TrimPrefix(keyString(arg), elemType+":")]
+				return ok, nil
+			case "L":
+				for _, e := range v.L {
+					if equalValues(e, arg) {
+						return true, nil
+					}
+				}
+			}
+			return false, nil
+		}
+	}
+	panic(fmt.Sprintf("Unknown condition %T", cond))
+}
+
+func applyUpdate(it item, actions []updateAction) error {
+	// All operands are evaluated against the item as it was before the update
+	values := make([]*dynamodb.AttributeValue, len(actions))
+	for i, action := range actions {
+		if action.value == nil {
+			continue
+		}
+		v, err := evalOperand(it, action.value)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			return validationError("The provided expression refers to an attribute that does not exist in the item")
+		}
+		values[i] = copyValue(v)
+	}
+	for i, action := range actions {
+		switch action.kind {
+		case "SET":
+			if err := setPath(it, action.path, values[i]); err != nil {
+				return err
+			}
+		case "REMOVE":
+			if err := removePath(it, action.path); err != nil {
+				return err
+			}
+		case "ADD":
+			if err := addToPath(it, action.path, values[i]); err != nil {
+				return err
+			}
+		case "DELETE":
+			if err := deleteFromPath(it, action.path, values[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func addToPath(it item, path docPath, value *dynamodb.AttributeValue) error {
+	cur := getPath(it, path)
+	switch typeOf(value) {
+	case "N":
+		if cur == nil {
+			return setPath(it, path, value)
+		}
+		if cur.N == nil {
+			return validationError("An operand in the update expression has an incorrect data type")
+		}
+		fc, err := parseNumber(*cur.N)
+		if err != nil {
+			return err
+		}
+		fv, err := parseNumber(*value.N)
+		if err != nil {
+			return err
+		}
+		n := formatNumber(fc.Add(fc, fv))
+		return setPath(it, path, &dynamodb.AttributeValue{N: &n})
+	case "SS", "NS", "BS":
+		if cur == nil {
+			return setPath(it, path, value)
+		}
+		if typeOf(cur) != typeOf(value) {
+			return validationError("An operand in the update expression has an incorrect data type")
+		}
+		res := copyValue(cur)
+		members := setKeys(cur)
+		for k, i := range setKeys(value) {
+			if _, exists := members[k]; exists {
+				continue
+			}
+			switch typeOf(value) {
+			case "SS":
+				res.SS = append(res.SS, value.SS[i])
+			case "NS":
+				res.NS = append(res.NS, value.NS[i])
+			case "BS":
+				res.BS = append(res.BS, value.BS[i])
+			}
+		}
+		return setPath(it, path, res)
+	}
+	return validationError("Invalid UpdateExpression: Incorrect operand type for operator or function; operator: ADD, operand type: %s", typeOf(value))
+}
+
+func deleteFromPath(it item, path docPath, value *dynamodb.AttributeValue) error {
+	switch typeOf(value) {
+	case "SS", "NS", "BS":
+	default:
+		return validationError("Invalid UpdateExpression: Incorrect operand type for operator or function; operator: DELETE, operand type: %s", typeOf(value))
+	}
+	cur := getPath(it, path)
+	if cur == nil {
+		return nil
+	}
+	if typeOf(cur) != typeOf(value) {
+		return validationError("An operand in the update expression has an incorrect data type")
+	}
+	removed := setKeys(value)
+	res := &dynamodb.AttributeValue{}
+	for k, i := range setKeys(cur) {
+		if _, ok := removed[k]; ok {
+			continue
+		}
+		switch typeOf(cur) {
+		case "SS":
+			res.SS = append(res.SS, cur.SS[i])
+		case "NS":
+			res.NS = append(res.NS, cur.NS[i])
+		case "BS":
+			res.BS = append(res.BS, cur.BS[i])
+		}
+	}
+	if setSize(res) == 0 {
+		return removePath(it, path)
+	}
+	return setPath(it, path, res)
+}
+
+func project(it item, paths []docPath) item {
+	if paths == nil {
+		return copyItem(it)
+	}
+	res := make(item)
+	for _, path := range paths {
+		v := getPath(it, path)
+		if v == nil {
+			continue
+		}
+		projectInto(res, path, copyValue(v))
+	}
+	return res
+}
+
+func projectInto(res item, path docPath, value *dynamodb.AttributeValue) {
+	if len(path) == 1 {
+		res[path[0].name] = value
+		return
+	}
+	cur, ok := res[path[0].name]
+	if !ok {
+		cur = newContainer(path[1])
+		res[path[0].name] = cur
+	}
+	for i, elem := range path[1:] {
+		last := i == len(path)-2
+		if elem.isIndex {
+			// Projected list elements are compacted, as DynamoDB does
+			if last {
+				cur.L = append(cur.L, value)
+				return
+			}
+			child := newContainer(path[i+2])
+			cur.L = append(cur.L, child)
+			cur = child
+		} else {
+			if last {
+				cur.M[elem.name] = value
+				return
+			}
+			child, ok := cur.M[elem.name]
+			if !ok {
+				child = newContainer(path[i+2])
+				cur.M[elem.name] = child
+			}
+			cur = child
+		}
+	}
+}
+
+func newContainer(elem pathElem) *dynamodb.AttributeValue {
+	if elem.isIndex {
+		return &dynamodb.AttributeValue{L: []*dynamodb.AttributeValue{}}
+	}
+	return &dynamodb.AttributeValue{M: map[string]*dynamodb.AttributeValue{}}
+}
@@ -0,0 +1,5 @@
+module cs.utexas.edu/zjia/faas-memdb
+
+go 1.14
+
+require github.com/aws/aws-sdk-go v1.34.6
@@ -0,0 +1,284 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+type item = map[string]*dynamodb.AttributeValue
+
+func copyValue(v *dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	if v == nil {
+		return nil
+	}
+	res := &dynamodb.AttributeValue{}
+	if v.S != nil {
+		res.S = aws.String(*v.S)
+	}
+	if v.N != nil {
+		res.N = aws.String(*v.N)
+	}
+	if v.B != nil {
+		res.B = append([]byte{}, v.B...)
+	}
+	if v.BOOL != nil {
+		res.BOOL = aws.Bool(*v.BOOL)
+	}
+	if v.NULL != nil {
+		res.NULL = aws.Bool(*v.NULL)
+	}
+	for _, s := range v.SS {
+		res.SS = append(res.SS, aws.String(*s))
+	}
+	for _, n := range v.NS {
+		res.NS = append(res.NS, aws.String(*n))
+	}
+	for _, b := range v.BS {
+		res.BS = append(res.BS, append([]byte{}, b...))
+	}
+	if v.L != nil {
+		res.L = make([]*dynamodb.AttributeValue, 0, len(v.L))
+		for _, e := range v.L {
+			res.L = append(res.L, copyValue(e))
+		}
+	}
+	if v.M != nil {
+		res.M = copyItem(v.M)
+	}
+	return res
+}
+
+func copyItem(it item) item {
+	if it == nil {
+		return nil
+	}
+	res := make(item, len(it))
+	for k, v := range it {
+		res[k] = copyValue(v)
+	}
+	return res
+}
+
+func typeOf(v *dynamodb.AttributeValue) string {
+	switch {
+	case v == nil:
+		return ""
+	case v.S != nil:
+		return "S"
+	case v.N != nil:
+		return "N"
+	case v.B != nil:
+		return "B"
+	case v.BOOL != nil:
+		return "BOOL"
+	case v.NULL != nil:
+		return "NULL"
+	case v.SS != nil:
+		return "SS"
+	case v.NS != nil:
+		return "NS"
+	case v.BS != nil:
+		return "BS"
+	case v.L != nil:
+		return "L"
+	case v.M != nil:
+		return "M"
+	}
+	return ""
+}
+
+func parseNumber(s string) (*big.Float, error) {
+	f, _, err := big.ParseFloat(s, 10, 256, big.ToNearestEven)
+	if err != nil {
+		return nil, validationError("The parameter cannot be converted to a numeric value: %s", s)
+	}
+	return f, nil
+}
+
+func formatNumber(f *big.Float) string {
+	if f.IsInt() {
+		i, _ := f.Int(nil)
+		return i.String()
+	}
+	return f.Text('g', 38)
+}
+
+func compareNumbers(a string, b string) int {
+	fa, err := parseNumber(a)
+	if err != nil {
+		panic(err)
+	}
+	fb, err := parseNumber(b)
+	if err != nil {
+		panic(err)
+	}
+	return fa.Cmp(fb)
+}
+
+// compareScalars orders two values of the same scalar type (S, N or B). ok is
+// false when the values are not comparable.
+func compareScalars(a *dynamodb.AttributeValue, b *dynamodb.AttributeValue) (int, bool) {
+	ta, tb := typeOf(a), typeOf(b)
+	if ta != tb {
+		return 0, false
+	}
+	switch ta {
+	case "S":
+		if *a.S < *b.S {
+			return -1, true
+		} else if *a.S > *b.S {
+			return 1, true
+		}
+		return 0, true
+	case "N":
+		return compareNumbers(*a.N, *b.N), true
+	case "B":
+		return bytes.Compare(a.B, b.B), true
+	}
+	return 0, false
+}
+
+func equalValues(a *dynamodb.AttributeValue, b *dynamodb.AttributeValue) bool {
+	ta, tb := typeOf(a), typeOf(b)
+	if ta != tb || ta == "" {
+		return false
+	}
+	switch ta {
+	case "S", "N", "B":
+		c, _ := compareScalars(a, b)
+		return c == 0
+	case "BOOL":
+		return *a.BOOL == *b.BOOL
+	case "NULL":
+		return true
+	case "SS", "NS", "BS":
+		ka, kb := setKeys(a), setKeys(b)
+		if len(ka) != len(kb) {
+			return false
+		}
+		for k := range ka {
+			if _, ok := kb[k]; !ok {
+				return false
+			}
+		}
+		return true
+	case "L":
+		if len(a.L) != len(b.L) {
+			return false
+		}
+		for i := range a.L {
+			if !equalValues(a.L[i], b.L[i]) {
+				return false
+			}
+		}
+		return true
+	case "M":
+		if len(a.M) != len(b.M) {
+			return false
+		}
+		for k, va := range a.M {
+			vb, ok := b.M[k]
+			if !ok || !equalValues(va, vb) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// setKeys returns the canonical members of a set value.
+func setKeys(v *dynamodb.AttributeValue) map[string]int {
+	res := make(map[string]int)
+	switch typeOf(v) {
+	case "SS":
+		for i, s := range v.SS {
+			res[*s] = i
+		}
+	case "NS":
+		for i, n := range v.NS {
+			f, err := parseNumber(*n)
+			if err != nil {
+				panic(err)
+			}
+			res[formatNumber(f)] = i
+		}
+	case "BS":
+		for i, b := range v.BS {
+			res[string(b)] = i
+		}
+	}
+	return res
+}
+
+func setSize(v *dynamodb.AttributeValue) int {
+	return len(v.SS) + len(v.NS) + len(v.BS)
+}
+
+func sizeOf(v *dynamodb.AttributeValue) int {
+	switch typeOf(v) {
+	case "S":
+		return len(*v.S)
+	case "N":
+		return len(*v.N)/2 + 1
+	case "B":
+		return len(v.B)
+	case "BOOL", "NULL":
+		return 1
+	case "SS":
+		n := 0
+		for _, s := range v.SS {
+			n += len(*s)
+		}
+		return n
+	case "NS":
+		n := 0
+		for _, s := range v.NS {
+			n += len(*s)/2 + 1
+		}
+		return n
+	case "BS":
+		n := 0
+		for _, b := range v.BS {
+			n += len(b)
+		}
+		return n
+	case "L":
+		n := 3
+		for _, e := range v.L {
+			n += sizeOf(e) + 1
+		}
+		return n
+	case "M":
+		return 3 + itemSize(v.M)
+	}
+	return 0
+}
+
+func itemSize(it item) int {
+	n := 0
+	for k, v := range it {
+		n += len(k) + sizeOf(v)
+	}
+	return n
+}
+
+func keyString(v *dynamodb.AttributeValue) string {
+	switch typeOf(v) {
+	case "S":
+		return "S:" + *v.S
+	case "N":
+		f, err := parseNumber(*v.N)
+		if err != nil {
+			panic(err)
+		}
+		return "N:" + formatNumber(f)
+	case "B":
+		return "B:" + string(v.B)
+	}
+	panic(fmt.Sprintf("Invalid key value %v", v))
+}